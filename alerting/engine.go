@@ -0,0 +1,446 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// windowKey identifies the sustained-breach window of one rule on one computer
+type windowKey struct {
+	RuleID     uuid.UUID
+	ComputerID string
+}
+
+// window tracks an ongoing breach until the rule's sustain conditions are met
+type window struct {
+	Start   time.Time
+	Samples int
+	Fired   bool
+}
+
+// verdict is the outcome of feeding one sample into a rule's window
+type verdict int
+
+const (
+	verdictHold   verdict = iota // no change: still sustaining, or inside the hysteresis band
+	verdictBreach                // sustain window satisfied; raise or bump the alert
+	verdictClear                 // recovered past the hysteresis threshold; resolve any open alert
+)
+
+// Websocket event types for alert lifecycle transitions
+const (
+	EventFired        = "alert"
+	EventAcknowledged = "alert_acknowledged"
+	EventResolved     = "alert_resolved"
+)
+
+// Event is an alert lifecycle transition to broadcast to dashboards
+type Event struct {
+	Type  string
+	Alert models.Alert
+}
+
+// Engine evaluates alert rules against ingested samples and keeps one open
+// alert per fingerprint
+type Engine struct {
+	mu      sync.Mutex
+	rules   []models.AlertRule
+	loaded  bool
+	windows map[windowKey]*window
+	open    map[string]bool // fingerprints that may have an unresolved alert
+}
+
+// Default is the process-wide engine used by the ingestion handlers
+var Default = NewEngine()
+
+func NewEngine() *Engine {
+	return &Engine{
+		windows: make(map[windowKey]*window),
+		open:    make(map[string]bool),
+	}
+}
+
+// Reload refreshes the cached rules; call after any rule is created, updated or deleted
+func (e *Engine) Reload(db *gorm.DB) error {
+	rules, err := models.GetEnabledAlertRules(db)
+	if err != nil {
+		return err
+	}
+
+	fingerprints, err := models.GetOpenAlertFingerprints(db)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rules = rules
+	e.loaded = true
+
+	e.open = make(map[string]bool, len(fingerprints))
+	for _, fp := range fingerprints {
+		e.open[fp] = true
+	}
+
+	// Drop windows for rules that no longer exist or are disabled
+	active := make(map[uuid.UUID]bool, len(rules))
+	for _, rule := range rules {
+		active[rule.ID] = true
+	}
+	for key := range e.windows {
+		if !active[key.RuleID] {
+			delete(e.windows, key)
+		}
+	}
+	return nil
+}
+
+// Rules returns the cached enabled rules, loading them on first use
+func (e *Engine) Rules(db *gorm.DB) ([]models.AlertRule, error) {
+	e.mu.Lock()
+	loaded := e.loaded
+	e.mu.Unlock()
+
+	if !loaded {
+		if err := e.Reload(db); err != nil {
+			return nil, err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]models.AlertRule(nil), e.rules...), nil
+}
+
+// MetricValue extracts a rule metric from a sample. The offline metric isn't
+// part of a sample; see EvaluateOffline.
+func MetricValue(metric string, log *models.ResourceLog) (float64, bool) {
+	switch metric {
+	case models.MetricCPU:
+		return log.CPU, true
+	case models.MetricMemory:
+		return log.Memory, true
+	case models.MetricNetworkIn:
+		return log.NetworkIn, true
+	case models.MetricNetworkOut:
+		return log.NetworkOut, true
+	}
+	return 0, false
+}
+
+// Evaluate checks every matching rule against a newly stored sample. Once a
+// rule's sustain window is satisfied its alert is raised, and later breaches
+// bump the same open alert; when the metric recovers past the hysteresis
+// threshold the alert is resolved automatically. Only lifecycle transitions
+// are returned. A sample also resolves the computer's offline rule alerts,
+// since it is reporting again.
+func (e *Engine) Evaluate(db *gorm.DB, log *models.ResourceLog, computer *models.Computer) ([]Event, error) {
+	rules, err := e.Rules(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Matches(computer) {
+			continue
+		}
+
+		if rule.Metric == models.MetricOffline {
+			e.reset(rule, computer.ComputerID)
+			event, err := e.clearRule(db, rule, computer)
+			if err != nil {
+				return events, err
+			}
+			if event != nil {
+				events = append(events, *event)
+			}
+			continue
+		}
+
+		value, ok := MetricValue(rule.Metric, log)
+		if !ok {
+			continue
+		}
+
+		event, err := e.apply(db, rule, computer, value, log.Timestamp, log)
+		if err != nil {
+			return events, err
+		}
+		if event != nil {
+			events = append(events, *event)
+		}
+	}
+
+	return events, nil
+}
+
+// EvaluateOffline checks the computer's offline rules against how long it has
+// been silent. The heartbeat supervisor calls it on every scan; Evaluate
+// resolves the alerts once the computer reports again.
+func (e *Engine) EvaluateOffline(db *gorm.DB, computer *models.Computer, silence time.Duration, now time.Time) ([]Event, error) {
+	rules, err := e.Rules(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Metric != models.MetricOffline || !rule.Matches(computer) {
+			continue
+		}
+
+		event, err := e.apply(db, rule, computer, silence.Seconds(), now, nil)
+		if err != nil {
+			return events, err
+		}
+		if event != nil {
+			events = append(events, *event)
+		}
+	}
+
+	return events, nil
+}
+
+// apply feeds one value into a rule's window and raises or resolves its alert
+// accordingly. log is the sample the value came from, if any, and supplies
+// the processes attached to a newly fired alert.
+func (e *Engine) apply(db *gorm.DB, rule *models.AlertRule, computer *models.Computer, value float64, at time.Time, log *models.ResourceLog) (*Event, error) {
+	switch e.observe(rule, computer.ComputerID, value, at) {
+	case verdictBreach:
+		if computer.AlertsSuppressed() {
+			return nil, nil
+		}
+		ruleID := rule.ID
+		alert, created, err := e.Raise(db, models.Alert{
+			ComputerID:  computer.ComputerID,
+			Type:        rule.AlertType,
+			Message:     Describe(rule, value),
+			Timestamp:   at,
+			Severity:    rule.Severity,
+			RuleID:      &ruleID,
+			Fingerprint: models.AlertFingerprint(rule.ID.String(), computer.ComputerID),
+		})
+		if err != nil || !created {
+			return nil, err
+		}
+		if log != nil {
+			if err := attachProcesses(db, &alert, rule.Metric, log); err != nil {
+				utils.LogError("Failed to attach processes to alert: %v", err)
+			}
+		}
+		return &Event{Type: EventFired, Alert: alert}, nil
+
+	case verdictClear:
+		return e.clearRule(db, rule, computer)
+	}
+	return nil, nil
+}
+
+// clearRule resolves the rule's open alert on the computer, if any
+func (e *Engine) clearRule(db *gorm.DB, rule *models.AlertRule, computer *models.Computer) (*Event, error) {
+	alert, err := e.Clear(db, models.AlertFingerprint(rule.ID.String(), computer.ComputerID))
+	if err != nil || alert == nil {
+		return nil, err
+	}
+	return &Event{Type: EventResolved, Alert: *alert}, nil
+}
+
+const (
+	culpritCount    = 5               // top processes attached to a fired alert
+	processLookback = 5 * time.Minute // oldest process snapshot that can explain an alert
+)
+
+// attachProcesses links a newly fired alert to the process snapshot of the
+// sample that fired it, or the computer's most recent one, and fills in the
+// top processes by the rule's metric for the broadcast
+func attachProcesses(db *gorm.DB, alert *models.Alert, metric string, log *models.ResourceLog) error {
+	snapshotID := log.ID
+	processes := log.Processes
+	if len(processes) == 0 {
+		snapshot, err := models.GetLatestProcessSnapshot(db, alert.ComputerID, log.Timestamp.Add(-processLookback), log.Timestamp)
+		if err != nil || snapshot == nil {
+			return err
+		}
+		snapshotID = snapshot.ResourceLogID
+		processes = snapshot.Processes
+	}
+
+	if err := db.Model(alert).Update("process_snapshot_id", snapshotID).Error; err != nil {
+		return err
+	}
+	alert.ProcessSnapshotID = &snapshotID
+	alert.Processes = processes.Top(metric, culpritCount)
+	return nil
+}
+
+// Raise records a breach for alert.Fingerprint. If an alert with that
+// fingerprint is already open its occurrence count and last-seen time are
+// bumped; otherwise a new firing alert is created. created reports which.
+func (e *Engine) Raise(db *gorm.DB, alert models.Alert) (models.Alert, bool, error) {
+	if _, err := e.Rules(db); err != nil {
+		return alert, false, err
+	}
+
+	if e.isOpen(alert.Fingerprint) {
+		existing, err := models.GetOpenAlertByFingerprint(db, alert.Fingerprint)
+		if err == nil {
+			err = existing.RecordOccurrence(db, alert.Message, alert.Timestamp)
+			return *existing, false, err
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return alert, false, err
+		}
+	}
+
+	alert.Status = models.AlertStatusFiring
+	alert.FirstSeen = alert.Timestamp
+	alert.LastSeen = alert.Timestamp
+	alert.OccurrenceCount = 1
+	if err := db.Create(&alert).Error; err != nil {
+		return alert, false, err
+	}
+
+	e.setOpen(alert.Fingerprint, true)
+	return alert, true, nil
+}
+
+// Clear automatically resolves the open alert for a fingerprint, returning it,
+// or nil if nothing was open
+func (e *Engine) Clear(db *gorm.DB, fingerprint string) (*models.Alert, error) {
+	if _, err := e.Rules(db); err != nil {
+		return nil, err
+	}
+
+	if !e.isOpen(fingerprint) {
+		return nil, nil
+	}
+
+	alert, err := models.GetOpenAlertByFingerprint(db, fingerprint)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Resolved by hand since we last looked
+		e.setOpen(fingerprint, false)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := alert.Resolve(db, "auto"); err != nil {
+		return nil, err
+	}
+
+	e.setOpen(fingerprint, false)
+	return alert, nil
+}
+
+func (e *Engine) isOpen(fingerprint string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.open[fingerprint]
+}
+
+func (e *Engine) setOpen(fingerprint string, open bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if open {
+		e.open[fingerprint] = true
+	} else {
+		delete(e.open, fingerprint)
+	}
+}
+
+// reset forgets the rule's window on a computer
+func (e *Engine) reset(rule *models.AlertRule, computerID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.windows, windowKey{RuleID: rule.ID, ComputerID: computerID})
+}
+
+// observe feeds a sample into the rule's window. A breach must hold for the
+// rule's sustain window before it counts; once it has, every further
+// breaching sample is reported until the value clears the hysteresis band.
+func (e *Engine) observe(rule *models.AlertRule, computerID string, value float64, at time.Time) verdict {
+	key := windowKey{RuleID: rule.ID, ComputerID: computerID}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.windows[key]
+
+	if !rule.Compare(value) {
+		if rule.Clears(value) {
+			delete(e.windows, key)
+			return verdictClear
+		}
+		// Inside the hysteresis band: a fired alert stays open, an
+		// unfired window has to start over
+		if ok && !w.Fired {
+			delete(e.windows, key)
+		}
+		return verdictHold
+	}
+
+	if !ok {
+		w = &window{Start: at}
+		e.windows[key] = w
+	}
+	w.Samples++
+
+	if w.Fired {
+		return verdictBreach
+	}
+
+	samples := rule.SustainedSamples
+	if samples < 1 {
+		samples = 1
+	}
+	if w.Samples < samples || at.Sub(w.Start) < time.Duration(rule.SustainedSeconds)*time.Second {
+		return verdictHold
+	}
+
+	w.Fired = true
+	return verdictBreach
+}
+
+var operatorSymbols = map[string]string{
+	models.OperatorGT:  ">",
+	models.OperatorGTE: ">=",
+	models.OperatorLT:  "<",
+	models.OperatorLTE: "<=",
+}
+
+// Describe builds the human-readable alert message for a breached rule
+func Describe(rule *models.AlertRule, value float64) string {
+	var msg string
+	switch rule.Metric {
+	case models.MetricCPU:
+		msg = fmt.Sprintf("CPU usage %.1f%% %s %g%%", value, operatorSymbols[rule.Operator], rule.Threshold)
+	case models.MetricMemory:
+		msg = fmt.Sprintf("Memory usage %.1f%% %s %g%%", value, operatorSymbols[rule.Operator], rule.Threshold)
+	case models.MetricNetworkIn:
+		msg = fmt.Sprintf("Network in %.0f B/s %s %g B/s", value, operatorSymbols[rule.Operator], rule.Threshold)
+	case models.MetricNetworkOut:
+		msg = fmt.Sprintf("Network out %.0f B/s %s %g B/s", value, operatorSymbols[rule.Operator], rule.Threshold)
+	case models.MetricOffline:
+		msg = fmt.Sprintf("Offline for %.0fs %s %gs", value, operatorSymbols[rule.Operator], rule.Threshold)
+	}
+
+	if rule.SustainedSeconds > 0 {
+		msg += fmt.Sprintf(" for %ds", rule.SustainedSeconds)
+	} else if rule.SustainedSamples > 1 {
+		msg += fmt.Sprintf(" for %d samples", rule.SustainedSamples)
+	}
+
+	return fmt.Sprintf("%s: %s", rule.Name, msg)
+}
@@ -0,0 +1,175 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/google/uuid"
+)
+
+// step is one sample fed to observe, at seconds after the first, and the
+// verdict expected for it
+type step struct {
+	value   float64
+	seconds int
+	want    verdict
+}
+
+func (v verdict) String() string {
+	switch v {
+	case verdictHold:
+		return "hold"
+	case verdictBreach:
+		return "breach"
+	case verdictClear:
+		return "clear"
+	}
+	return "unknown"
+}
+
+func cpuRule(operator string, threshold, hysteresis float64, samples, seconds int) *models.AlertRule {
+	return &models.AlertRule{
+		ID:               uuid.New(),
+		Name:             "High CPU",
+		Metric:           models.MetricCPU,
+		Operator:         operator,
+		Threshold:        threshold,
+		Hysteresis:       hysteresis,
+		SustainedSamples: samples,
+		SustainedSeconds: seconds,
+	}
+}
+
+func TestObserve(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  *models.AlertRule
+		steps []step
+	}{
+		{
+			name: "fires on the first breach",
+			rule: cpuRule(models.OperatorGT, 90, 0, 1, 0),
+			steps: []step{
+				{95, 0, verdictBreach},
+				{95, 10, verdictBreach},
+				{90, 20, verdictClear},
+			},
+		},
+		{
+			name: "sustained samples",
+			rule: cpuRule(models.OperatorGT, 90, 0, 3, 0),
+			steps: []step{
+				{95, 0, verdictHold},
+				{95, 10, verdictHold},
+				{95, 20, verdictBreach},
+				{95, 30, verdictBreach},
+			},
+		},
+		{
+			name: "sustained seconds",
+			rule: cpuRule(models.OperatorGT, 90, 0, 1, 30),
+			steps: []step{
+				{95, 0, verdictHold},
+				{95, 20, verdictHold},
+				{95, 30, verdictBreach},
+			},
+		},
+		{
+			name: "samples and seconds must both be met",
+			rule: cpuRule(models.OperatorGT, 90, 0, 3, 10),
+			steps: []step{
+				{95, 0, verdictHold},
+				{95, 20, verdictHold},
+				{95, 21, verdictBreach},
+			},
+		},
+		{
+			name: "a clear restarts the window",
+			rule: cpuRule(models.OperatorGT, 90, 0, 2, 0),
+			steps: []step{
+				{95, 0, verdictHold},
+				{80, 10, verdictClear},
+				{95, 20, verdictHold},
+				{95, 30, verdictBreach},
+			},
+		},
+		{
+			name: "a dip into the hold band restarts an unfired window",
+			rule: cpuRule(models.OperatorGT, 90, 5, 3, 0),
+			steps: []step{
+				{95, 0, verdictHold},
+				{95, 10, verdictHold},
+				{88, 20, verdictHold},
+				{95, 30, verdictHold},
+				{95, 40, verdictHold},
+				{95, 50, verdictBreach},
+			},
+		},
+		{
+			name: "a fired alert survives the hold band",
+			rule: cpuRule(models.OperatorGT, 90, 5, 2, 0),
+			steps: []step{
+				{95, 0, verdictHold},
+				{95, 10, verdictBreach},
+				{88, 20, verdictHold},
+				{86, 30, verdictHold},
+				{95, 40, verdictBreach},
+				{85, 50, verdictClear},
+				{95, 60, verdictHold},
+			},
+		},
+		{
+			name: "below-threshold rules clear above the band",
+			rule: cpuRule(models.OperatorLT, 10, 5, 1, 0),
+			steps: []step{
+				{5, 0, verdictBreach},
+				{12, 10, verdictHold},
+				{14, 20, verdictHold},
+				{15, 30, verdictClear},
+			},
+		},
+		{
+			name: "inclusive operators",
+			rule: cpuRule(models.OperatorGTE, 90, 5, 1, 0),
+			steps: []step{
+				{90, 0, verdictBreach},
+				{85, 10, verdictHold},
+				{84.9, 20, verdictClear},
+			},
+		},
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			for i, s := range tt.steps {
+				at := start.Add(time.Duration(s.seconds) * time.Second)
+				if got := e.observe(tt.rule, "pc-1", s.value, at); got != s.want {
+					t.Fatalf("step %d (%g at %ds) = %s, want %s", i, s.value, s.seconds, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestObserveKeepsWindowsPerComputerAndRule(t *testing.T) {
+	e := NewEngine()
+	rule := cpuRule(models.OperatorGT, 90, 0, 2, 0)
+	other := cpuRule(models.OperatorGT, 90, 0, 2, 0)
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := e.observe(rule, "pc-1", 95, at); got != verdictHold {
+		t.Fatalf("first breach = %s, want hold", got)
+	}
+	if got := e.observe(rule, "pc-2", 95, at); got != verdictHold {
+		t.Errorf("another computer's first breach = %s, want hold", got)
+	}
+	if got := e.observe(other, "pc-1", 95, at); got != verdictHold {
+		t.Errorf("another rule's first breach = %s, want hold", got)
+	}
+	if got := e.observe(rule, "pc-1", 95, at.Add(time.Second)); got != verdictBreach {
+		t.Errorf("second breach = %s, want breach", got)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"log"
 	"os"
 
-	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/migrations"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -13,7 +13,8 @@ import (
 
 var DB *gorm.DB
 
-func InitDB() {
+// Connect opens the database connection without touching the schema
+func Connect() {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_USER"),
@@ -40,53 +41,22 @@ func InitDB() {
 
 	sqlDB.SetMaxOpenConns(10)
 	sqlDB.SetMaxIdleConns(5)
-
-	// First create the extension for UUID support
-	err = DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";").Error
-	if err != nil {
-		log.Fatal("Failed to create extension: ", err)
-	}
-
-	// Drop existing tables to start fresh
-	err = DB.Exec("DROP TABLE IF EXISTS internet_usages, alerts, resource_logs, computers, users CASCADE;").Error
-	if err != nil {
-		log.Fatal("Failed to drop tables: ", err)
-	}
-
-	// Create tables with proper references
-	createSchema()
-
-	log.Println("Database connection established successfully")
 }
 
-func createSchema() {
-	// Create User model
-	err := DB.AutoMigrate(&models.User{})
-	if err != nil {
-		log.Fatal("Failed to create User table: ", err)
-	}
-
-	// Create Computer model
-	err = DB.AutoMigrate(&models.Computer{})
-	if err != nil {
-		log.Fatal("Failed to create Computer table: ", err)
-	}
-
-	// Create ResourceLog model
-	err = DB.AutoMigrate(&models.ResourceLog{})
-	if err != nil {
-		log.Fatal("Failed to create ResourceLog table: ", err)
-	}
+// InitDB connects to the database and applies any pending migrations.
+// Set DB_AUTO_MIGRATE=false to manage the schema with the migrate subcommand instead.
+func InitDB() {
+	Connect()
 
-	// Create Alert model
-	err = DB.AutoMigrate(&models.Alert{})
-	if err != nil {
-		log.Fatal("Failed to create Alert table: ", err)
+	if os.Getenv("DB_AUTO_MIGRATE") != "false" {
+		applied, err := migrations.Up(DB)
+		if err != nil {
+			log.Fatal("Failed to apply migrations: ", err)
+		}
+		if applied > 0 {
+			log.Printf("Applied %d migration(s)", applied)
+		}
 	}
 
-	// Create InternetUsage model
-	err = DB.AutoMigrate(&models.InternetUsage{})
-	if err != nil {
-		log.Fatal("Failed to create InternetUsage table: ", err)
-	}
+	log.Println("Database connection established successfully")
 }
@@ -3,18 +3,21 @@ package controllers
 import (
 	"time"
 
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
 	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
 	"github.com/Frhnmj2004/LabMonitoring-server/models"
 	"github.com/Frhnmj2004/LabMonitoring-server/utils"
 	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // GetAlerts returns all alerts with optional filtering
 func GetAlerts(c *fiber.Ctx) error {
 	var alerts []models.Alert
-	query := config.DB.Order("timestamp desc")
+	query := middleware.ScopeFromContext(c).FilterComputers(config.DB.Order("timestamp desc"), "alerts.computer_id")
 
 	// Apply filters
 	if computerID := c.Query("computer_id"); computerID != "" {
@@ -29,6 +32,10 @@ func GetAlerts(c *fiber.Ctx) error {
 		query = query.Where("resolved = ?", resolved == "true")
 	}
 
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
 	// Add pagination
 	page := c.QueryInt("page", 1)
 	limit := c.QueryInt("limit", 50)
@@ -64,6 +71,7 @@ func GetAlerts(c *fiber.Ctx) error {
 func GetActiveAlerts(c *fiber.Ctx) error {
 	var alerts []models.Alert
 	query := config.DB.Where("resolved = ?", false).Order("timestamp desc")
+	query = middleware.ScopeFromContext(c).FilterComputers(query, "alerts.computer_id")
 
 	// Apply computer filter if provided
 	if computerID := c.Query("computer_id"); computerID != "" {
@@ -85,7 +93,7 @@ func GetActiveAlerts(c *fiber.Ctx) error {
 // GetAlertHistory returns alert history with pagination
 func GetAlertHistory(c *fiber.Ctx) error {
 	var alerts []models.Alert
-	query := config.DB.Order("timestamp desc")
+	query := middleware.ScopeFromContext(c).FilterComputers(config.DB.Order("timestamp desc"), "alerts.computer_id")
 
 	// Apply filters
 	if computerID := c.Query("computer_id"); computerID != "" {
@@ -135,11 +143,11 @@ func GetAlertHistory(c *fiber.Ctx) error {
 	})
 }
 
-// ResolveAlert marks an alert as resolved
-func ResolveAlert(c *fiber.Ctx) error {
+// findAlert loads the alert named by the :id route parameter
+func findAlert(c *fiber.Ctx) (*models.Alert, error) {
 	alertID := c.Params("id")
 	if alertID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Alert ID is required",
 		})
 	}
@@ -147,20 +155,79 @@ func ResolveAlert(c *fiber.Ctx) error {
 	// Parse UUID
 	id, err := uuid.Parse(alertID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid alert ID format",
 		})
 	}
 
 	var alert models.Alert
 	if err := config.DB.First(&alert, "id = ?", id).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Alert not found",
 		})
 	}
 
-	alert.Resolved = true
-	if err := config.DB.Save(&alert).Error; err != nil {
+	if !middleware.ScopeFromContext(c).AllowsComputer(config.DB, alert.ComputerID) {
+		return nil, outOfScope(c)
+	}
+	return &alert, nil
+}
+
+// AcknowledgeAlert marks a firing alert as acknowledged
+func AcknowledgeAlert(c *fiber.Ctx) error {
+	alert, err := findAlert(c)
+	if alert == nil {
+		return err
+	}
+
+	if alert.Status != models.AlertStatusFiring {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Only firing alerts can be acknowledged",
+		})
+	}
+
+	if err := alert.Acknowledge(config.DB, currentUsername(c)); err != nil {
+		utils.LogError("Failed to acknowledge alert: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to acknowledge alert",
+		})
+	}
+
+	// Broadcast alert acknowledgement
+	broadcastAlert(alerting.EventAcknowledged, alert)
+
+	return c.JSON(fiber.Map{
+		"message": "Alert acknowledged successfully",
+		"data":    alert,
+	})
+}
+
+// broadcastAlert tells the dashboards following the alert's computer, its lab
+// or all alerts about a change made through the API
+func broadcastAlert(eventType string, alert *models.Alert) {
+	computer, err := models.GetComputerBySystemID(config.DB, alert.ComputerID)
+	if err != nil {
+		// Still reaches computer and alert subscribers
+		utils.LogWarning("Failed to look up computer %s for alert broadcast: %v", alert.ComputerID, err)
+		computer = &models.Computer{ComputerID: alert.ComputerID}
+	}
+	websocket.BroadcastResourceUpdate(websocket.AlertEvent(eventType, computer, alert))
+}
+
+// ResolveAlert marks an alert as resolved
+func ResolveAlert(c *fiber.Ctx) error {
+	alert, err := findAlert(c)
+	if alert == nil {
+		return err
+	}
+
+	if !alert.IsOpen() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Alert is already resolved",
+		})
+	}
+
+	if err := alert.Resolve(config.DB, currentUsername(c)); err != nil {
 		utils.LogError("Failed to resolve alert: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to resolve alert",
@@ -168,10 +235,7 @@ func ResolveAlert(c *fiber.Ctx) error {
 	}
 
 	// Broadcast alert resolution
-	websocket.BroadcastResourceUpdate(fiber.Map{
-		"type": "alert_resolved",
-		"data": alert,
-	})
+	broadcastAlert(alerting.EventResolved, alert)
 
 	return c.JSON(fiber.Map{
 		"message": "Alert resolved successfully",
@@ -182,33 +246,39 @@ func ResolveAlert(c *fiber.Ctx) error {
 // GetAlertStats returns alert statistics
 func GetAlertStats(c *fiber.Ctx) error {
 	var stats struct {
-		TotalAlerts      int64 `json:"total_alerts"`
-		ActiveAlerts     int64 `json:"active_alerts"`
-		ResolvedAlerts   int64 `json:"resolved_alerts"`
-		HighCPUAlerts    int64 `json:"high_cpu_alerts"`
-		HighMemoryAlerts int64 `json:"high_memory_alerts"`
+		TotalAlerts        int64 `json:"total_alerts"`
+		ActiveAlerts       int64 `json:"active_alerts"`
+		AcknowledgedAlerts int64 `json:"acknowledged_alerts"`
+		ResolvedAlerts     int64 `json:"resolved_alerts"`
+		HighCPUAlerts      int64 `json:"high_cpu_alerts"`
+		HighMemoryAlerts   int64 `json:"high_memory_alerts"`
 	}
 
-	db := config.DB.Model(&models.Alert{})
+	// Each count starts from a fresh, scoped query so conditions don't accumulate
+	scope := middleware.ScopeFromContext(c)
+	db := func() *gorm.DB {
+		return scope.FilterComputers(config.DB.Model(&models.Alert{}), "alerts.computer_id")
+	}
 
 	// Get total alerts
-	db.Count(&stats.TotalAlerts)
+	db().Count(&stats.TotalAlerts)
 
 	// Get active alerts
-	db.Where("resolved = ?", false).Count(&stats.ActiveAlerts)
+	db().Where("resolved = ?", false).Count(&stats.ActiveAlerts)
+	db().Where("status = ?", models.AlertStatusAcknowledged).Count(&stats.AcknowledgedAlerts)
 
 	// Get resolved alerts
-	db.Where("resolved = ?", true).Count(&stats.ResolvedAlerts)
+	db().Where("resolved = ?", true).Count(&stats.ResolvedAlerts)
 
 	// Get alerts by type
-	db.Where("type = ?", "HIGH_CPU").Count(&stats.HighCPUAlerts)
-	db.Where("type = ?", "HIGH_MEMORY").Count(&stats.HighMemoryAlerts)
+	db().Where("type = ?", "HIGH_CPU").Count(&stats.HighCPUAlerts)
+	db().Where("type = ?", "HIGH_MEMORY").Count(&stats.HighMemoryAlerts)
 
 	// Add time-based statistics
 	var last24Hours struct {
 		Count int64
 	}
-	db.Where("timestamp >= ?", time.Now().Add(-24*time.Hour)).Count(&last24Hours.Count)
+	db().Where("timestamp >= ?", time.Now().Add(-24*time.Hour)).Count(&last24Hours.Count)
 
 	return c.JSON(fiber.Map{
 		"total_stats": stats,
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// AlertRuleRequest is the body for creating or updating an alert rule.
+// Omitted fields keep their current (or default) value.
+type AlertRuleRequest struct {
+	Name             *string  `json:"name"`
+	Metric           *string  `json:"metric"`
+	Operator         *string  `json:"operator"`
+	Threshold        *float64 `json:"threshold"`
+	AlertType        *string  `json:"alert_type"`
+	Severity         *string  `json:"severity"`
+	CollegeID        *string  `json:"college_id"` // "" clears the scope
+	LabID            *string  `json:"lab_id"`     // "" clears the scope
+	ComputerID       *string  `json:"computer_id"`
+	SustainedSeconds *int     `json:"sustained_seconds"`
+	SustainedSamples *int     `json:"sustained_samples"`
+	Hysteresis       *float64 `json:"hysteresis"`
+	Enabled          *bool    `json:"enabled"`
+}
+
+func (req *AlertRuleRequest) apply(rule *models.AlertRule) error {
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Metric != nil {
+		rule.Metric = *req.Metric
+	}
+	if req.Operator != nil {
+		rule.Operator = *req.Operator
+	}
+	if req.Threshold != nil {
+		rule.Threshold = *req.Threshold
+	}
+	if req.AlertType != nil {
+		rule.AlertType = *req.AlertType
+	}
+	if req.Severity != nil {
+		rule.Severity = *req.Severity
+	}
+	if req.CollegeID != nil {
+		id, err := parseScopeID(*req.CollegeID, "college_id")
+		if err != nil {
+			return err
+		}
+		rule.CollegeID = id
+	}
+	if req.LabID != nil {
+		id, err := parseScopeID(*req.LabID, "lab_id")
+		if err != nil {
+			return err
+		}
+		rule.LabID = id
+	}
+	if req.ComputerID != nil {
+		rule.ComputerID = *req.ComputerID
+	}
+	if req.SustainedSeconds != nil {
+		rule.SustainedSeconds = *req.SustainedSeconds
+	}
+	if req.SustainedSamples != nil {
+		rule.SustainedSamples = *req.SustainedSamples
+	}
+	if req.Hysteresis != nil {
+		rule.Hysteresis = *req.Hysteresis
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// parseScopeID parses an optional scope ID, where "" means no scope
+func parseScopeID(value, field string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s format", field)
+	}
+	return &id, nil
+}
+
+// validateAlertRuleScope checks that the rule's college and lab exist
+func validateAlertRuleScope(rule *models.AlertRule) error {
+	if rule.CollegeID != nil {
+		var count int64
+		if err := config.DB.Model(&models.College{}).Where("id = ?", *rule.CollegeID).Count(&count).Error; err != nil || count == 0 {
+			return errors.New("college_id does not exist")
+		}
+	}
+	if rule.LabID != nil {
+		if _, err := models.GetLabByID(config.DB, *rule.LabID); err != nil {
+			return errors.New("lab_id does not exist")
+		}
+	}
+	return nil
+}
+
+// reloadAlertRules refreshes the engine's rule cache after a change
+func reloadAlertRules() {
+	if err := alerting.Default.Reload(config.DB); err != nil {
+		utils.LogError("Failed to reload alert rules: %v", err)
+	}
+}
+
+// findAlertRule loads the rule named by the :id route parameter
+func findAlertRule(c *fiber.Ctx) (*models.AlertRule, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid alert rule ID format",
+		})
+	}
+
+	var rule models.AlertRule
+	if err := config.DB.First(&rule, "id = ?", id).Error; err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Alert rule not found",
+		})
+	}
+	return &rule, nil
+}
+
+// GetAlertRules returns all alert rules with optional filtering
+func GetAlertRules(c *fiber.Ctx) error {
+	var rules []models.AlertRule
+	query := config.DB.Order("created_at")
+
+	if metric := c.Query("metric"); metric != "" {
+		query = query.Where("metric = ?", metric)
+	}
+
+	if enabled := c.Query("enabled"); enabled != "" {
+		query = query.Where("enabled = ?", enabled == "true")
+	}
+
+	if err := query.Find(&rules).Error; err != nil {
+		utils.LogError("Failed to fetch alert rules: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch alert rules",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": rules,
+	})
+}
+
+// GetAlertRule returns a single alert rule
+func GetAlertRule(c *fiber.Ctx) error {
+	rule, err := findAlertRule(c)
+	if rule == nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"data": rule,
+	})
+}
+
+// CreateAlertRule adds a new alert rule
+func CreateAlertRule(c *fiber.Ctx) error {
+	var req AlertRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	rule := models.AlertRule{
+		Severity:         models.SeverityWarning,
+		SustainedSamples: 1,
+		Enabled:          true,
+	}
+	if err := req.apply(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := rule.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := validateAlertRuleScope(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := config.DB.Create(&rule).Error; err != nil {
+		utils.LogError("Failed to create alert rule: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create alert rule",
+		})
+	}
+	reloadAlertRules()
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Alert rule created successfully",
+		"data":    rule,
+	})
+}
+
+// UpdateAlertRule changes an existing alert rule
+func UpdateAlertRule(c *fiber.Ctx) error {
+	rule, err := findAlertRule(c)
+	if rule == nil {
+		return err
+	}
+
+	var req AlertRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := req.apply(rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := rule.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := validateAlertRuleScope(rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := config.DB.Save(rule).Error; err != nil {
+		utils.LogError("Failed to update alert rule: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update alert rule",
+		})
+	}
+	reloadAlertRules()
+
+	return c.JSON(fiber.Map{
+		"message": "Alert rule updated successfully",
+		"data":    rule,
+	})
+}
+
+// DeleteAlertRule removes an alert rule; alerts it raised are kept
+func DeleteAlertRule(c *fiber.Ctx) error {
+	rule, err := findAlertRule(c)
+	if rule == nil {
+		return err
+	}
+
+	if err := config.DB.Delete(rule).Error; err != nil {
+		utils.LogError("Failed to delete alert rule: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete alert rule",
+		})
+	}
+	reloadAlertRules()
+
+	return c.JSON(fiber.Map{
+		"message": "Alert rule deleted successfully",
+	})
+}
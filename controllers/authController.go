@@ -1,11 +1,17 @@
 package controllers
 
 import (
+	"errors"
+	"time"
+
 	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
 	"github.com/Frhnmj2004/LabMonitoring-server/models"
 	"github.com/Frhnmj2004/LabMonitoring-server/utils"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type SignupRequest struct {
@@ -17,6 +23,48 @@ type SignupRequest struct {
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Device   string `json:"device"` // optional label shown for the session
+}
+
+var errRefreshTokenReused = errors.New("refresh token already used")
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens creates an access token and a refresh token for a session. The
+// new refresh token row is created with tx so rotation can be atomic.
+func issueTokens(c *fiber.Ctx, tx *gorm.DB, user *models.User, sessionID uuid.UUID, device string) (fiber.Map, *models.RefreshToken, error) {
+	accessToken, claims, err := utils.GenerateToken(user.ID, user.Username, user.Role, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, hash, err := utils.NewRefreshToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	row := models.RefreshToken{
+		SessionID:       sessionID,
+		UserID:          user.ID,
+		TokenHash:       hash,
+		Device:          device,
+		UserAgent:       c.Get(fiber.HeaderUserAgent),
+		IPAddress:       c.IP(),
+		AccessJTI:       uuid.MustParse(claims.ID),
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:       time.Now().Add(utils.RefreshTokenTTL()),
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return fiber.Map{
+		"token":         accessToken,
+		"expires_in":    int(utils.AccessTokenTTL().Seconds()),
+		"refresh_token": refreshToken,
+	}, &row, nil
 }
 
 func Signup(c *fiber.Ctx) error {
@@ -28,12 +76,19 @@ func Signup(c *fiber.Ctx) error {
 	}
 
 	// Validate role
-	if req.Role != "admin" && req.Role != "user" {
+	if !models.IsValidRole(req.Role) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid role",
 		})
 	}
 
+	// Unauthenticated signup is only allowed for the first user, who must be an admin
+	if c.Locals("role") == nil && req.Role != models.RoleAdmin {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The first user must be an admin",
+		})
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -87,7 +142,7 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Role)
+	response, _, err := issueTokens(c, config.DB, &user, uuid.New(), req.Device)
 	if err != nil {
 		utils.LogError("Failed to generate token: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -95,12 +150,128 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
+	response["user"] = fiber.Map{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	}
+	return c.JSON(response)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Presenting a refresh token that was already used revokes the whole
+// session, since it means the token was copied.
+func Refresh(c *fiber.Ctx) error {
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "refresh_token is required",
+		})
+	}
+
+	current, err := models.GetRefreshTokenByHash(config.DB, utils.HashRefreshToken(req.RefreshToken))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	now := time.Now()
+	if current.RevokedAt != nil && current.ReplacedBy != nil {
+		utils.LogWarning("Refresh token reuse detected for session %s; revoking it", current.SessionID)
+		if err := models.RevokeSession(config.DB, current.SessionID); err != nil {
+			utils.LogError("Failed to revoke session: %v", err)
+		}
+	}
+	if !current.IsActive(now) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	// Reload the user so role changes take effect on refresh
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", current.UserID).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	var response fiber.Map
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var next *models.RefreshToken
+		var err error
+		response, next, err = issueTokens(c, tx, &user, current.SessionID, current.Device)
+		if err != nil {
+			return err
+		}
+
+		rotated, err := current.MarkRotated(tx, next.ID, now)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			// Another request rotated this token first
+			return errRefreshTokenReused
+		}
+		return nil
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		utils.LogWarning("Concurrent refresh token use for session %s; revoking it", current.SessionID)
+		if err := models.RevokeSession(config.DB, current.SessionID); err != nil {
+			utils.LogError("Failed to revoke session: %v", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+	if err != nil {
+		utils.LogError("Failed to refresh token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.JSON(response)
+}
+
+// Logout ends the caller's current session
+func Logout(c *fiber.Ctx) error {
+	claims := middleware.ClaimsFromContext(c)
+	if claims == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	// Deny the presented token directly too, in case the session has no refresh token
+	err := models.RevokeAccessToken(config.DB, uuid.MustParse(claims.ID), claims.UserID, claims.ExpiresAt.Time)
+	if err == nil {
+		err = models.RevokeSession(config.DB, claims.SessionID)
+	}
+	if err != nil {
+		utils.LogError("Failed to log out: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"token": token,
-		"user": fiber.Map{
-			"id":       user.ID,
-			"username": user.Username,
-			"role":     user.Role,
-		},
+		"message": "Logged out successfully",
+	})
+}
+
+// LogoutAll ends every session of the caller, on all devices
+func LogoutAll(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uuid.UUID)
+	if err := models.RevokeUserSessions(config.DB, userID); err != nil {
+		utils.LogError("Failed to log out all sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "All sessions logged out successfully",
 	})
 }
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CollectorConfigRequest is the body for changing a computer's collector
+// settings. Omitted fields keep their current value.
+type CollectorConfigRequest struct {
+	SampleIntervalSeconds *int  `json:"sample_interval_seconds"` // 0 reverts to the collector's own setting
+	PollIntervalSeconds   *int  `json:"poll_interval_seconds"`   // 0 reverts to the collector's own setting
+	DNSCapture            *bool `json:"dns_capture"`
+	Paused                *bool `json:"paused"`
+}
+
+func (req *CollectorConfigRequest) apply(cfg *models.CollectorConfig) {
+	if req.SampleIntervalSeconds != nil {
+		cfg.SampleIntervalSeconds = override(*req.SampleIntervalSeconds)
+	}
+	if req.PollIntervalSeconds != nil {
+		cfg.PollIntervalSeconds = override(*req.PollIntervalSeconds)
+	}
+	if req.DNSCapture != nil {
+		cfg.DNSCapture = *req.DNSCapture
+	}
+	if req.Paused != nil {
+		cfg.Paused = *req.Paused
+	}
+}
+
+// override returns an interval override, or nil for 0 so the collector
+// falls back to its local config
+func override(seconds int) *int {
+	if seconds == 0 {
+		return nil
+	}
+	return &seconds
+}
+
+// GetCollectorConfig returns the settings a computer's collector should use.
+// Collectors poll it with their device token; users may read the config of
+// computers in their labs.
+func GetCollectorConfig(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if device := middleware.DeviceFromContext(c); device != nil {
+		if device.ComputerID != computer.ComputerID {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Device token does not match computer_id",
+			})
+		}
+	} else if !middleware.ScopeFromContext(c).AllowsLab(computer.LabID) {
+		return outOfScope(c)
+	}
+
+	cfg, err := models.GetCollectorConfig(config.DB, computer.ComputerID)
+	if err != nil {
+		utils.LogError("Failed to fetch collector config: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch collector config",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": cfg,
+	})
+}
+
+// UpdateCollectorConfig changes a computer's collector settings; the
+// collector picks them up on its next poll
+func UpdateCollectorConfig(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	cfg, err := models.GetCollectorConfig(config.DB, computer.ComputerID)
+	if err != nil {
+		utils.LogError("Failed to fetch collector config: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update collector config",
+		})
+	}
+
+	var req CollectorConfigRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	req.apply(&cfg)
+
+	if !cfg.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("sample_interval_seconds must be %d-%d and poll_interval_seconds %d-%d, or 0 to unset",
+				models.MinSampleInterval, models.MaxSampleInterval, models.MinPollInterval, models.MaxPollInterval),
+		})
+	}
+
+	cfg.UpdatedBy = currentUsername(c)
+	if err := models.SaveCollectorConfig(config.DB, &cfg); err != nil {
+		utils.LogError("Failed to update collector config: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update collector config",
+		})
+	}
+
+	reloadOfflineThresholds()
+
+	return c.JSON(fiber.Map{
+		"message": "Collector config updated successfully",
+		"data":    cfg,
+	})
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CollegeRequest struct {
+	Name string `json:"name"`
+}
+
+// findCollege loads the college named by the :id route parameter
+func findCollege(c *fiber.Ctx) (*models.College, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid college ID format",
+		})
+	}
+
+	var college models.College
+	if err := config.DB.First(&college, "id = ?", id).Error; err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "College not found",
+		})
+	}
+	return &college, nil
+}
+
+// validateCollege checks a college's name, returning the status and message
+// to respond with if it is not acceptable
+func validateCollege(college *models.College) (int, string) {
+	if college.Name == "" {
+		return fiber.StatusBadRequest, "Name is required"
+	}
+
+	existing, err := models.GetCollegeByName(config.DB, college.Name)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.LogError("Failed to check college name: %v", err)
+		return fiber.StatusInternalServerError, "Failed to validate college"
+	}
+	if err == nil && existing.ID != college.ID {
+		return fiber.StatusConflict, "A college with this name already exists"
+	}
+
+	return 0, ""
+}
+
+// GetColleges returns all colleges
+func GetColleges(c *fiber.Ctx) error {
+	colleges, err := models.GetAllColleges(config.DB)
+	if err != nil {
+		utils.LogError("Failed to fetch colleges: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch colleges",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": colleges,
+	})
+}
+
+// GetCollege returns a single college
+func GetCollege(c *fiber.Ctx) error {
+	college, err := findCollege(c)
+	if college == nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"data": college,
+	})
+}
+
+// CreateCollege adds a new college
+func CreateCollege(c *fiber.Ctx) error {
+	var req CollegeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	college := models.College{Name: models.NormalizeName(req.Name)}
+	if status, msg := validateCollege(&college); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if err := config.DB.Create(&college).Error; err != nil {
+		utils.LogError("Failed to create college: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create college",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "College created successfully",
+		"data":    college,
+	})
+}
+
+// UpdateCollege renames a college
+func UpdateCollege(c *fiber.Ctx) error {
+	college, err := findCollege(c)
+	if college == nil {
+		return err
+	}
+
+	var req CollegeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	college.Name = models.NormalizeName(req.Name)
+	if status, msg := validateCollege(college); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if err := config.DB.Save(college).Error; err != nil {
+		utils.LogError("Failed to update college: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update college",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "College updated successfully",
+		"data":    college,
+	})
+}
+
+// DeleteCollege removes a college that has no labs
+func DeleteCollege(c *fiber.Ctx) error {
+	college, err := findCollege(c)
+	if college == nil {
+		return err
+	}
+
+	var labs int64
+	if err := config.DB.Model(&models.Lab{}).Where("college_id = ?", college.ID).Count(&labs).Error; err != nil {
+		utils.LogError("Failed to count labs: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete college",
+		})
+	}
+	if labs > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "College still has labs",
+		})
+	}
+
+	if err := config.DB.Delete(college).Error; err != nil {
+		utils.LogError("Failed to delete college: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete college",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "College deleted successfully",
+	})
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
 	"github.com/Frhnmj2004/LabMonitoring-server/helper"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
 	"github.com/Frhnmj2004/LabMonitoring-server/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -23,6 +25,10 @@ func GetInternetUsage(c *fiber.Ctx) error {
 		})
 	}
 
+	if !middleware.ScopeFromContext(c).AllowsComputer(config.DB, computerID) {
+		return outOfScope(c)
+	}
+
 	logs, err := helper.GetInternetUsageByComputer(compUUID, 100) // Limit to 100 logs
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -44,6 +50,21 @@ func PostInternetUsage(c *fiber.Ctx) error {
 		})
 	}
 
+	device := middleware.DeviceFromContext(c)
+	if device == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Device authentication required",
+		})
+	}
+	if usage.ComputerID == "" {
+		usage.ComputerID = device.ComputerID
+	}
+	if usage.ComputerID != device.ComputerID {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Device token does not match computer_id",
+		})
+	}
+
 	if err := helper.SaveInternetUsage(usage); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to save internet usage",
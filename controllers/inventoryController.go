@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/ingest"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// InventoryData is an inventory submitted by a collector. CollectedAt is when
+// it was taken and defaults to now.
+type InventoryData struct {
+	models.Inventory
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// PostInventory records a computer's hardware and software inventory. A new
+// version is stored when it differs from the previous one, and notable
+// differences raise an INVENTORY_CHANGED alert.
+func PostInventory(c *fiber.Ctx) error {
+	device := middleware.DeviceFromContext(c)
+	if device == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Device authentication required",
+		})
+	}
+	if c.Params("id") != device.ComputerID {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Device token does not match computer_id",
+		})
+	}
+
+	var data InventoryData
+	if err := c.BodyParser(&data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := data.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	now := time.Now()
+	if data.CollectedAt.IsZero() {
+		data.CollectedAt = now
+	}
+	if err := ingest.Default.CheckTimestamp(data.CollectedAt, now); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	snapshot, changed, err := models.RecordInventory(config.DB, device.ComputerID, data.Inventory, data.CollectedAt)
+	if err != nil {
+		utils.LogError("Failed to save inventory: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save inventory",
+		})
+	}
+
+	if changed && snapshot.Version > 1 && !device.AlertsSuppressed() {
+		if err := raiseInventoryAlert(device, snapshot); err != nil {
+			utils.LogError("Failed to raise inventory alert: %v", err)
+		}
+	}
+
+	status := fiber.StatusOK
+	message := "Inventory unchanged"
+	if changed {
+		status = fiber.StatusCreated
+		message = "Inventory saved successfully"
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"data": fiber.Map{
+			"version": snapshot.Version,
+			"changed": changed,
+			"changes": snapshot.Changes,
+		},
+	})
+}
+
+// raiseInventoryAlert reports a new inventory version's notable changes
+func raiseInventoryAlert(computer *models.Computer, snapshot *models.InventorySnapshot) error {
+	notable := snapshot.Changes.Notable()
+	if len(notable) == 0 {
+		return nil
+	}
+
+	alert, created, err := alerting.Default.Raise(config.DB, models.Alert{
+		ComputerID:  snapshot.ComputerID,
+		Type:        models.AlertInventoryChanged,
+		Message:     fmt.Sprintf("Inventory changed (version %d): %s", snapshot.Version, notable.Summary(3)),
+		Timestamp:   snapshot.ReceivedAt,
+		Severity:    notable.Severity(),
+		Fingerprint: models.AlertFingerprint(fmt.Sprintf("inventory:%d", snapshot.Version), snapshot.ComputerID),
+	})
+	if err != nil {
+		return err
+	}
+	if created {
+		websocket.BroadcastResourceUpdate(websocket.AlertEvent(alerting.EventFired, computer, alert))
+	}
+	return nil
+}
+
+// GetComputerInventory returns a computer's latest inventory, or the version
+// given by ?version=
+func GetComputerInventory(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if !middleware.ScopeFromContext(c).AllowsLab(computer.LabID) {
+		return outOfScope(c)
+	}
+
+	var snapshot *models.InventorySnapshot
+	if value := c.Query("version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil || version < 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "version must be a positive integer",
+			})
+		}
+		snapshot, err = models.GetInventoryVersion(config.DB, computer.ComputerID, version)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Inventory version not found",
+			})
+		}
+	} else {
+		snapshot, err = models.GetLatestInventory(config.DB, computer.ComputerID)
+		if err != nil {
+			utils.LogError("Failed to fetch inventory: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch inventory",
+			})
+		}
+		if snapshot == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No inventory reported yet",
+			})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"data": snapshot,
+	})
+}
+
+// GetInventoryHistory lists a computer's inventory versions and what changed
+// in each, newest first
+func GetInventoryHistory(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if !middleware.ScopeFromContext(c).AllowsLab(computer.LabID) {
+		return outOfScope(c)
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	versions, err := models.GetInventoryVersions(config.DB, computer.ComputerID, limit)
+	if err != nil {
+		utils.LogError("Failed to fetch inventory history: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch inventory history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": versions,
+	})
+}
@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/heartbeat"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// LabRequest is the body for creating or updating a lab.
+// Omitted fields keep their current value.
+type LabRequest struct {
+	CollegeID               *uuid.UUID `json:"college_id"`
+	Name                    *string    `json:"name"`
+	Room                    *string    `json:"room"`
+	Capacity                *int       `json:"capacity"`
+	ResponsibleStaff        *string    `json:"responsible_staff"`
+	OfflineThresholdSeconds *int       `json:"offline_threshold_seconds"` // 0 reverts to the server default
+}
+
+func (req *LabRequest) apply(lab *models.Lab) {
+	if req.CollegeID != nil {
+		lab.CollegeID = *req.CollegeID
+	}
+	if req.Name != nil {
+		lab.Name = models.NormalizeName(*req.Name)
+	}
+	if req.Room != nil {
+		lab.Room = *req.Room
+	}
+	if req.Capacity != nil {
+		lab.Capacity = *req.Capacity
+	}
+	if req.ResponsibleStaff != nil {
+		lab.ResponsibleStaff = *req.ResponsibleStaff
+	}
+	if req.OfflineThresholdSeconds != nil {
+		if *req.OfflineThresholdSeconds == 0 {
+			lab.OfflineThresholdSeconds = nil
+		} else {
+			seconds := *req.OfflineThresholdSeconds
+			lab.OfflineThresholdSeconds = &seconds
+		}
+	}
+}
+
+// validateLab checks a lab before it is saved, returning a message for the client
+func validateLab(lab *models.Lab) (int, string) {
+	if lab.Name == "" {
+		return fiber.StatusBadRequest, "Name is required"
+	}
+	if lab.Capacity < 0 {
+		return fiber.StatusBadRequest, "capacity cannot be negative"
+	}
+	if lab.OfflineThresholdSeconds != nil && *lab.OfflineThresholdSeconds < 0 {
+		return fiber.StatusBadRequest, "offline_threshold_seconds cannot be negative"
+	}
+
+	var college models.College
+	if err := config.DB.First(&college, "id = ?", lab.CollegeID).Error; err != nil {
+		return fiber.StatusBadRequest, "college_id does not exist"
+	}
+
+	var taken int64
+	err := config.DB.Model(&models.Lab{}).
+		Where("college_id = ? AND lower(name) = lower(?) AND id <> ?", lab.CollegeID, lab.Name, lab.ID).
+		Count(&taken).Error
+	if err != nil {
+		utils.LogError("Failed to check lab name: %v", err)
+		return fiber.StatusInternalServerError, "Failed to validate lab"
+	}
+	if taken > 0 {
+		return fiber.StatusConflict, "A lab with this name already exists in the college"
+	}
+
+	return 0, ""
+}
+
+// reloadOfflineThresholds applies lab threshold and collector config changes
+// to the running supervisor
+func reloadOfflineThresholds() {
+	if heartbeat.Default == nil {
+		return
+	}
+	if err := heartbeat.Default.ReloadThresholds(); err != nil {
+		utils.LogError("Failed to reload offline thresholds: %v", err)
+	}
+}
+
+// findLab loads the lab named by the :id route parameter
+func findLab(c *fiber.Ctx) (*models.Lab, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid lab ID format",
+		})
+	}
+
+	lab, err := models.GetLabByID(config.DB, id)
+	if err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Lab not found",
+		})
+	}
+
+	if !middleware.ScopeFromContext(c).AllowsLab(lab.ID) {
+		return nil, outOfScope(c)
+	}
+	return lab, nil
+}
+
+// GetLabs returns all labs, optionally filtered by college
+func GetLabs(c *fiber.Ctx) error {
+	var collegeID *uuid.UUID
+	if value := c.Query("college_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid college_id format",
+			})
+		}
+		collegeID = &id
+	}
+
+	scoped := middleware.ScopeFromContext(c).FilterLabs(config.DB, "labs.id")
+	labs, err := models.GetLabs(scoped, collegeID)
+	if err != nil {
+		utils.LogError("Failed to fetch labs: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch labs",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": labs,
+	})
+}
+
+// GetLab returns a single lab
+func GetLab(c *fiber.Ctx) error {
+	lab, err := findLab(c)
+	if lab == nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"data": lab,
+	})
+}
+
+// CreateLab adds a new lab to a college
+func CreateLab(c *fiber.Ctx) error {
+	var req LabRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	var lab models.Lab
+	req.apply(&lab)
+
+	if status, msg := validateLab(&lab); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if err := config.DB.Create(&lab).Error; err != nil {
+		utils.LogError("Failed to create lab: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create lab",
+		})
+	}
+	reloadOfflineThresholds()
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Lab created successfully",
+		"data":    lab,
+	})
+}
+
+// UpdateLab changes a lab's details
+func UpdateLab(c *fiber.Ctx) error {
+	lab, err := findLab(c)
+	if lab == nil {
+		return err
+	}
+
+	var req LabRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	req.apply(lab)
+
+	if status, msg := validateLab(lab); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	// Save the lab's own columns only; the preloaded college may be stale
+	lab.College = nil
+	if err := config.DB.Save(lab).Error; err != nil {
+		utils.LogError("Failed to update lab: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update lab",
+		})
+	}
+	reloadOfflineThresholds()
+
+	return c.JSON(fiber.Map{
+		"message": "Lab updated successfully",
+		"data":    lab,
+	})
+}
+
+// DeleteLab removes a lab that has no computers
+func DeleteLab(c *fiber.Ctx) error {
+	lab, err := findLab(c)
+	if lab == nil {
+		return err
+	}
+
+	var computers int64
+	if err := config.DB.Model(&models.Computer{}).Where("lab_id = ?", lab.ID).Count(&computers).Error; err != nil {
+		utils.LogError("Failed to count computers: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete lab",
+		})
+	}
+	if computers > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Lab still has computers",
+		})
+	}
+
+	if err := config.DB.Delete(&models.Lab{}, "id = ?", lab.ID).Error; err != nil {
+		utils.LogError("Failed to delete lab: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete lab",
+		})
+	}
+	reloadOfflineThresholds()
+
+	return c.JSON(fiber.Map{
+		"message": "Lab deleted successfully",
+	})
+}
+
+// GetLabComputers returns the computers in a lab with their online status
+func GetLabComputers(c *fiber.Ctx) error {
+	lab, err := findLab(c)
+	if lab == nil {
+		return err
+	}
+
+	computers, err := models.GetComputersByLab(config.DB, lab.ID)
+	if err != nil {
+		utils.LogError("Failed to fetch lab computers: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch computers",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": computerResponses(computers),
+	})
+}
+
+// GetLabSummary returns live totals for a lab: online computers, average
+// CPU and memory across online computers' latest samples, and open alerts
+func GetLabSummary(c *fiber.Ctx) error {
+	lab, err := findLab(c)
+	if lab == nil {
+		return err
+	}
+
+	computers, err := models.GetComputersByLab(config.DB, lab.ID)
+	if err != nil {
+		utils.LogError("Failed to fetch lab computers: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch lab summary",
+		})
+	}
+
+	var online []string
+	var maintenance int
+	for i := range computers {
+		if computers[i].IsOnlineWithin(heartbeat.Threshold(&computers[i])) {
+			online = append(online, computers[i].ComputerID)
+		}
+		if computers[i].Status == models.ComputerMaintenance {
+			maintenance++
+		}
+	}
+
+	latest, err := models.GetLatestResourceLogs(config.DB, online)
+	if err != nil {
+		utils.LogError("Failed to fetch latest samples: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch lab summary",
+		})
+	}
+
+	var avgCPU, avgMemory *float64
+	if len(latest) > 0 {
+		var cpu, memory float64
+		for _, log := range latest {
+			cpu += log.CPU
+			memory += log.Memory
+		}
+		cpu /= float64(len(latest))
+		memory /= float64(len(latest))
+		avgCPU, avgMemory = &cpu, &memory
+	}
+
+	var openAlerts int64
+	if err := config.DB.Model(&models.Alert{}).
+		Joins("JOIN computers ON computers.computer_id = alerts.computer_id").
+		Where("computers.lab_id = ? AND computers.archived_at IS NULL AND alerts.status <> ?", lab.ID, models.AlertStatusResolved).
+		Count(&openAlerts).Error; err != nil {
+		utils.LogError("Failed to count open alerts: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch lab summary",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": fiber.Map{
+			"lab":                   lab,
+			"total_computers":       len(computers),
+			"online_computers":      len(online),
+			"maintenance_computers": maintenance,
+			"avg_cpu":               avgCPU,
+			"avg_memory":            avgMemory,
+			"open_alerts":           openAlerts,
+		},
+	})
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetComputerProcesses returns a computer's top processes. By default it
+// returns the newest snapshot (or the newest at or before ?at=); with ?from=
+// and/or ?to= it lists snapshots in that range, newest first. ?sort=memory
+// orders each snapshot by memory instead of CPU and ?top= trims it.
+func GetComputerProcesses(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if !middleware.ScopeFromContext(c).AllowsLab(computer.LabID) {
+		return outOfScope(c)
+	}
+
+	sortBy := c.Query("sort", models.MetricCPU)
+	if sortBy != models.MetricCPU && sortBy != models.MetricMemory {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "sort must be cpu or memory",
+		})
+	}
+	top := c.QueryInt("top", models.MaxProcessesPerSnapshot)
+	if top < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "top must be positive",
+		})
+	}
+
+	times := make(map[string]time.Time)
+	for _, name := range []string{"at", "from", "to"} {
+		if value := c.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + name + ", expected RFC 3339",
+				})
+			}
+			times[name] = t
+		}
+	}
+
+	_, hasFrom := times["from"]
+	_, hasTo := times["to"]
+	if !hasFrom && !hasTo {
+		at, ok := times["at"]
+		if !ok {
+			at = time.Now()
+		}
+
+		snapshot, err := models.GetLatestProcessSnapshot(config.DB, computer.ComputerID, time.Time{}, at)
+		if err != nil {
+			utils.LogError("Failed to fetch process snapshot: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch processes",
+			})
+		}
+		if snapshot == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No process snapshot found",
+			})
+		}
+
+		snapshot.Processes = snapshot.Processes.Top(sortBy, top)
+		return c.JSON(fiber.Map{
+			"data": snapshot,
+		})
+	}
+
+	to, ok := times["to"]
+	if !ok {
+		to = time.Now()
+	}
+	from, ok := times["from"]
+	if !ok {
+		from = to.Add(-time.Hour)
+	}
+	if !from.Before(to) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "from must be before to",
+		})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	snapshots, err := models.GetProcessSnapshots(config.DB, computer.ComputerID, from, to, limit)
+	if err != nil {
+		utils.LogError("Failed to fetch process snapshots: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch processes",
+		})
+	}
+	for i := range snapshots {
+		snapshots[i].Processes = snapshots[i].Processes.Top(sortBy, top)
+	}
+
+	return c.JSON(fiber.Map{
+		"data": snapshots,
+	})
+}
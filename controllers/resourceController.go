@@ -1,25 +1,97 @@
 package controllers
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/ingest"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
 	"github.com/Frhnmj2004/LabMonitoring-server/models"
-	"github.com/Frhnmj2004/LabMonitoring-server/storage"
 	"github.com/Frhnmj2004/LabMonitoring-server/utils"
 	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
+// ResourceData is a sample submitted by a collector. Timestamp is the capture
+// time (defaults to now) and Seq an optional per-computer sequence number
+// used to detect resent samples. Metrics and Users carry the optional
+// extended host metrics, and Processes an optional top-process snapshot.
 type ResourceData struct {
-	ComputerID string  `json:"computer_id"`
-	CPU        float64 `json:"cpu"`
-	Memory     float64 `json:"memory"`
-	NetworkIn  float64 `json:"network_in"`
-	NetworkOut float64 `json:"network_out"`
+	ComputerID string             `json:"computer_id"`
+	CPU        float64            `json:"cpu"`
+	Memory     float64            `json:"memory"`
+	NetworkIn  float64            `json:"network_in"`
+	NetworkOut float64            `json:"network_out"`
+	Timestamp  time.Time          `json:"timestamp"`
+	Seq        *int64             `json:"seq"`
+	Metrics    models.Metrics     `json:"metrics"`
+	Users      []string           `json:"users"`
+	Processes  models.ProcessList `json:"processes"`
 }
 
+// valid reports whether the metric values are in range
+func (d *ResourceData) valid() bool {
+	return d.CPU >= 0 && d.CPU <= 100 && d.Memory >= 0 && d.Memory <= 100 && d.NetworkIn >= 0 && d.NetworkOut >= 0
+}
+
+// check validates a sample received at now, filling in a missing timestamp,
+// and returns what is wrong with it
+func (d *ResourceData) check(now time.Time) string {
+	if !d.valid() {
+		return "Invalid resource values"
+	}
+	if d.Seq != nil && *d.Seq < 0 {
+		return "seq must not be negative"
+	}
+	if err := d.Metrics.Validate(); err != nil {
+		return err.Error()
+	}
+	if err := models.ValidateUsers(d.Users); err != nil {
+		return err.Error()
+	}
+	if err := d.Processes.Validate(); err != nil {
+		return err.Error()
+	}
+	if d.Timestamp.IsZero() {
+		d.Timestamp = now
+	}
+	if err := ingest.Default.CheckTimestamp(d.Timestamp, now); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// toLog builds the resource log stored for a sample received at now
+func (d *ResourceData) toLog(now time.Time) models.ResourceLog {
+	return models.ResourceLog{
+		ID:         uuid.New(),
+		ComputerID: d.ComputerID,
+		CPU:        d.CPU,
+		Memory:     d.Memory,
+		NetworkIn:  d.NetworkIn,
+		NetworkOut: d.NetworkOut,
+		Timestamp:  d.Timestamp,
+		ReceivedAt: now,
+		Seq:        d.Seq,
+		Metrics:    d.Metrics,
+		Users:      d.Users,
+		Processes:  d.Processes,
+	}
+}
+
+// duplicateSample is the error for a sample whose sequence number was already stored
+const duplicateSample = "Duplicate sample"
+
 func PostResource(c *fiber.Ctx) error {
 	var data ResourceData
 	if err := c.BodyParser(&data); err != nil {
@@ -29,92 +101,280 @@ func PostResource(c *fiber.Ctx) error {
 	}
 
 	// Validate data
-	if data.CPU < 0 || data.CPU > 100 || data.Memory < 0 || data.Memory > 100 || data.NetworkIn < 0 || data.NetworkOut < 0 {
+	now := time.Now()
+	if msg := data.check(now); msg != "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid resource values",
+			"error": msg,
 		})
 	}
 
-	var computer models.Computer
-	if err := config.DB.Where("computer_id = ?", data.ComputerID).First(&computer).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Computer not found",
+	// The device token identifies the computer; a sample for any other machine is rejected
+	device := middleware.DeviceFromContext(c)
+	if device == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Device authentication required",
+		})
+	}
+	if data.ComputerID == "" {
+		data.ComputerID = device.ComputerID
+	}
+	if data.ComputerID != device.ComputerID {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Device token does not match computer_id",
 		})
 	}
+	if data.Seq != nil {
+		stored, err := models.FindStoredSamples(config.DB, []models.SampleKey{{ComputerID: data.ComputerID, Seq: *data.Seq}})
+		if err != nil {
+			utils.LogError("Failed to check for duplicate samples: %v", err)
+		} else if len(stored) > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": duplicateSample,
+			})
+		}
+	}
 
-	computer.LastSeen = time.Now()
-	if err := config.DB.Save(&computer).Error; err != nil {
-		utils.LogError("Failed to update computer last seen: %v", err)
+	// Storing, alert evaluation and broadcasting happen in the ingestion pipeline
+	sample := &ingest.Sample{
+		Log:      data.toLog(now),
+		Computer: device,
+	}
+	if err := ingest.Default.Enqueue(sample); err != nil {
+		return ingestUnavailable(c, err)
 	}
 
-	// Create resource log
-	log := &models.ResourceLog{
-		ComputerID: data.ComputerID,
-		CPU:        data.CPU,
-		Memory:     data.Memory,
-		NetworkIn:  data.NetworkIn,
-		NetworkOut: data.NetworkOut,
-		Timestamp:  time.Now(),
-		Computer:   computer,
+	return c.Status(fiber.StatusAccepted).JSON(sample.Log)
+}
+
+// ingestUnavailable responds when the pipeline cannot take the samples,
+// telling the client when to retry
+func ingestUnavailable(c *fiber.Ctx, err error) error {
+	retryAfter := int(math.Ceil(ingest.Default.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+	if errors.Is(err, ingest.ErrTooLarge) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "Batch is too large for the ingestion queue, send it in smaller parts",
+		})
+	}
+	if errors.Is(err, ingest.ErrQueueFull) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Ingestion queue is full, retry later",
+		})
 	}
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "Service temporarily unavailable",
+	})
+}
 
-	// Try to save to database
-	if err := config.DB.Create(log).Error; err != nil {
-		// If database save fails, store in buffer
-		utils.LogWarning("Failed to save to database, writing to buffer: %v", err)
-		if bufferErr := storage.WriteBuffer(log); bufferErr != nil {
-			utils.LogError("Failed to write to buffer: %v", bufferErr)
+// GetIngestStats returns the ingestion queue depth and counters
+func GetIngestStats(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"data": ingest.Default.Stats(),
+	})
+}
+
+// GetWebSocketStats returns the number of dashboard connections and the
+// delivery counters
+func GetWebSocketStats(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"data": websocket.Default.Stats(),
+	})
+}
+
+// maxBatchSamples is the largest batch accepted in one request
+const maxBatchSamples = 5000
+
+// BatchItemResult reports what happened to one sample of a batch
+type BatchItemResult struct {
+	Index  int        `json:"index"`
+	Status string     `json:"status"` // "accepted", "duplicate" or "rejected"
+	ID     *uuid.UUID `json:"id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// splitBatch returns the raw items of a JSON array or NDJSON request body
+func splitBatch(c *fiber.Ctx) ([]json.RawMessage, error) {
+	body := c.Body()
+
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), "application/x-ndjson") {
+		var items []json.RawMessage
+		err := json.Unmarshal(body, &items)
+		return items, err
+	}
+
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
 		}
-		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-			"error": "Service temporarily unavailable, data buffered",
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+	}
+	return items, scanner.Err()
+}
+
+// PostResourceBatch stores many timestamped samples in one request, as a JSON
+// array or as NDJSON (Content-Type: application/x-ndjson). Each sample is
+// validated on its own and the response reports the outcome per item. Valid
+// samples are queued together, or the whole batch is refused with 429.
+// Samples whose sequence number is already stored, or repeated within the
+// batch, are reported as duplicates and skipped.
+// Collectors may only submit their own samples; an admin token may submit for
+// any computer.
+func PostResourceBatch(c *fiber.Ctx) error {
+	items, err := splitBatch(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if len(items) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Batch is empty",
+		})
+	}
+	if len(items) > maxBatchSamples {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("Batch exceeds %d samples", maxBatchSamples),
 		})
 	}
 
-	// Check for high CPU usage
-	if data.CPU > 90 {
-		alert := &models.Alert{
-			ComputerID: data.ComputerID,
-			Type:       "HIGH_CPU",
-			Message:    "CPU usage exceeds 90%",
-			Timestamp:  time.Now(),
-			Resolved:   false,
+	device := middleware.DeviceFromContext(c)
+	now := time.Now()
+
+	results := make([]BatchItemResult, len(items))
+	samples := make([]*ResourceData, len(items))
+	reject := func(i int, msg string) {
+		results[i] = BatchItemResult{Index: i, Status: "rejected", Error: msg}
+		samples[i] = nil
+	}
+	duplicates := 0
+	duplicate := func(i int) {
+		results[i] = BatchItemResult{Index: i, Status: "duplicate", Error: duplicateSample}
+		samples[i] = nil
+		duplicates++
+	}
+
+	var computerIDs []string
+	var keys []models.SampleKey
+	seen := make(map[string]bool)
+	seenSeq := make(map[models.SampleKey]bool)
+	for i, item := range items {
+		var sample ResourceData
+		if err := json.Unmarshal(item, &sample); err != nil {
+			reject(i, "Invalid sample")
+			continue
+		}
+
+		if device != nil {
+			if sample.ComputerID == "" {
+				sample.ComputerID = device.ComputerID
+			}
+			if sample.ComputerID != device.ComputerID {
+				reject(i, "Device token does not match computer_id")
+				continue
+			}
+		} else if sample.ComputerID == "" {
+			reject(i, "computer_id is required")
+			continue
+		}
+
+		if msg := sample.check(now); msg != "" {
+			reject(i, msg)
+			continue
+		}
+		if sample.Seq != nil {
+			key := models.SampleKey{ComputerID: sample.ComputerID, Seq: *sample.Seq}
+			if seenSeq[key] {
+				duplicate(i)
+				continue
+			}
+			seenSeq[key] = true
+			keys = append(keys, key)
 		}
-		if err := config.DB.Create(alert).Error; err != nil {
-			utils.LogError("Failed to create CPU alert: %v", err)
-		} else {
-			websocket.BroadcastResourceUpdate(fiber.Map{
-				"type": "alert",
-				"data": alert,
+
+		samples[i] = &sample
+		if !seen[sample.ComputerID] {
+			seen[sample.ComputerID] = true
+			computerIDs = append(computerIDs, sample.ComputerID)
+		}
+	}
+
+	computers := make(map[string]*models.Computer)
+	if device != nil {
+		computers[device.ComputerID] = device
+	} else {
+		found, err := models.GetComputersBySystemIDs(config.DB, computerIDs)
+		if err != nil {
+			utils.LogError("Failed to load computers: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store samples",
 			})
 		}
+		for i := range found {
+			computers[found[i].ComputerID] = &found[i]
+		}
+	}
+
+	stored, err := models.FindStoredSamples(config.DB, keys)
+	if err != nil {
+		// The pipeline still skips duplicates when it stores them
+		utils.LogError("Failed to check for duplicate samples: %v", err)
 	}
 
-	// Check for high memory usage
-	if data.Memory > 90 {
-		alert := &models.Alert{
-			ComputerID: data.ComputerID,
-			Type:       "HIGH_MEMORY",
-			Message:    "Memory usage exceeds 90%",
-			Timestamp:  time.Now(),
-			Resolved:   false,
+	var queued []*ingest.Sample
+	for i, sample := range samples {
+		if sample == nil {
+			continue
 		}
-		if err := config.DB.Create(alert).Error; err != nil {
-			utils.LogError("Failed to create memory alert: %v", err)
-		} else {
-			websocket.BroadcastResourceUpdate(fiber.Map{
-				"type": "alert",
-				"data": alert,
-			})
+		computer := computers[sample.ComputerID]
+		if computer == nil {
+			reject(i, "Unknown computer_id")
+			continue
 		}
+		if sample.Seq != nil && stored[models.SampleKey{ComputerID: sample.ComputerID, Seq: *sample.Seq}] {
+			duplicate(i)
+			continue
+		}
+
+		log := sample.toLog(now)
+		queued = append(queued, &ingest.Sample{Log: log, Computer: computer})
+		results[i] = BatchItemResult{Index: i, Status: "accepted", ID: &log.ID}
+	}
+
+	rejected := len(items) - len(queued) - duplicates
+	if len(queued) == 0 {
+		// A batch that was entirely stored before is a successful resend
+		status := fiber.StatusBadRequest
+		if rejected == 0 {
+			status = fiber.StatusOK
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"accepted":   0,
+			"duplicates": duplicates,
+			"rejected":   rejected,
+			"results":    results,
+		})
 	}
 
-	// Broadcast resource update
-	websocket.BroadcastResourceUpdate(fiber.Map{
-		"type": "resource_update",
-		"data": log,
+	// Alert rules expect each computer's samples in time order
+	sort.SliceStable(queued, func(a, b int) bool {
+		return queued[a].Log.Timestamp.Before(queued[b].Log.Timestamp)
 	})
+	if err := ingest.Default.EnqueueAll(queued); err != nil {
+		// Nothing was queued; the collector keeps its backlog and retries
+		return ingestUnavailable(c, err)
+	}
 
-	return c.Status(fiber.StatusOK).JSON(log)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"accepted":   len(queued),
+		"duplicates": duplicates,
+		"rejected":   rejected,
+		"results":    results,
+	})
 }
 
 func GetHistory(c *fiber.Ctx) error {
@@ -125,6 +385,15 @@ func GetHistory(c *fiber.Ctx) error {
 		})
 	}
 
+	if !middleware.ScopeFromContext(c).AllowsComputer(config.DB, computerID) {
+		return outOfScope(c)
+	}
+
+	// A time range or resolution switches to chart mode; otherwise page raw samples
+	if c.Query("from") != "" || c.Query("to") != "" || c.Query("resolution") != "" {
+		return getHistoryRange(c, computerID)
+	}
+
 	// Parse pagination parameters
 	page, _ := strconv.Atoi(c.Query("page", "1"))
 	limit, _ := strconv.Atoi(c.Query("limit", "50"))
@@ -167,3 +436,108 @@ func GetHistory(c *fiber.Ctx) error {
 		},
 	})
 }
+
+const (
+	maxHistoryPoints  = 1000             // target points per series when picking a resolution
+	maxRawHistoryRows = 5000             // hard cap on raw samples returned for a range
+	rawSampleInterval = 10 * time.Second // collector reporting interval
+)
+
+// pickResolution returns the finest resolution that keeps a span within maxHistoryPoints
+func pickResolution(span time.Duration) string {
+	if span/rawSampleInterval <= maxHistoryPoints {
+		return models.ResolutionRaw
+	}
+	for _, r := range models.RollupResolutions {
+		if span/r.Step <= maxHistoryPoints {
+			return r.Name
+		}
+	}
+	return models.ResolutionDay
+}
+
+// getHistoryRange returns a computer's samples between from and to (RFC 3339,
+// defaulting to the last 24 hours) at the requested or automatically chosen resolution
+func getHistoryRange(c *fiber.Ctx, computerID string) error {
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid to, expected RFC 3339",
+			})
+		}
+		to = t
+	}
+
+	from := to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid from, expected RFC 3339",
+			})
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "from must be before to",
+		})
+	}
+
+	resolution := c.Query("resolution", "auto")
+	if resolution == "auto" {
+		resolution = pickResolution(to.Sub(from))
+	}
+
+	if resolution == models.ResolutionRaw {
+		var logs []models.ResourceLog
+		if err := config.DB.Where("computer_id = ? AND timestamp >= ? AND timestamp < ?", computerID, from, to).
+			Order("timestamp").
+			Limit(maxRawHistoryRows + 1).
+			Find(&logs).Error; err != nil {
+			utils.LogError("Failed to fetch resource logs: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch resource logs",
+			})
+		}
+
+		truncated := len(logs) > maxRawHistoryRows
+		if truncated {
+			logs = logs[:maxRawHistoryRows]
+		}
+
+		return c.JSON(fiber.Map{
+			"data":       logs,
+			"resolution": resolution,
+			"from":       from,
+			"to":         to,
+			"truncated":  truncated,
+		})
+	}
+
+	step, ok := models.RollupStep(resolution)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid resolution, expected auto, raw, 1m, 1h or 1d",
+		})
+	}
+
+	// Include the bucket that from falls in
+	rollups, err := models.GetResourceRollups(config.DB, resolution, computerID, from.Truncate(step), to)
+	if err != nil {
+		utils.LogError("Failed to fetch resource rollups: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch resource logs",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data":       rollups,
+		"resolution": resolution,
+		"from":       from,
+		"to":         to,
+	})
+}
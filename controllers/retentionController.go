@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/ingest"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/retention"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RetentionPolicyRequest is the body for changing a retention policy.
+// Omitted fields keep their current value.
+type RetentionPolicyRequest struct {
+	RetentionDays *int  `json:"retention_days"` // 0 keeps data forever
+	Enabled       *bool `json:"enabled"`
+}
+
+func (req *RetentionPolicyRequest) apply(policy *models.RetentionPolicy) {
+	if req.RetentionDays != nil {
+		policy.RetentionDays = *req.RetentionDays
+	}
+	if req.Enabled != nil {
+		policy.Enabled = *req.Enabled
+	}
+}
+
+// GetRetentionPolicies returns every retention policy
+func GetRetentionPolicies(c *fiber.Ctx) error {
+	policies, err := models.GetRetentionPolicies(config.DB)
+	if err != nil {
+		utils.LogError("Failed to fetch retention policies: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch retention policies",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": policies,
+	})
+}
+
+// UpdateRetentionPolicy changes how long one kind of data is kept
+func UpdateRetentionPolicy(c *fiber.Ctx) error {
+	var policy models.RetentionPolicy
+	if err := config.DB.First(&policy, "target = ?", c.Params("target")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Retention policy not found",
+		})
+	}
+
+	var req RetentionPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	req.apply(&policy)
+
+	if policy.RetentionDays < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "retention_days cannot be negative",
+		})
+	}
+
+	policy.UpdatedBy = currentUsername(c)
+	if err := config.DB.Save(&policy).Error; err != nil {
+		utils.LogError("Failed to update retention policy: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update retention policy",
+		})
+	}
+
+	// Samples near the end of raw retention are refused at ingestion
+	if policy.Target == models.RawSamplesPolicy && ingest.Default != nil {
+		if err := ingest.Default.RefreshMaxSampleAge(); err != nil {
+			utils.LogError("Failed to apply raw retention to ingestion: %v", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Retention policy updated successfully",
+		"data":    policy,
+	})
+}
+
+// RunRetention applies the retention policies now. With ?dry_run=true
+// nothing is deleted and the response reports what would be.
+func RunRetention(c *fiber.Ctx) error {
+	if retention.Default == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Retention pruner is not running",
+		})
+	}
+
+	dryRun := c.QueryBool("dry_run", false)
+	results, err := retention.Default.Run(dryRun)
+	if err != nil {
+		utils.LogError("Retention run failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Retention run failed",
+		})
+	}
+
+	message := "Retention run completed"
+	if dryRun {
+		message = "Retention dry run completed; nothing was deleted"
+	}
+	return c.JSON(fiber.Map{
+		"message": message,
+		"data":    results,
+	})
+}
@@ -4,14 +4,24 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
 	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/heartbeat"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
 	"github.com/Frhnmj2004/LabMonitoring-server/models"
 	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
+// SystemSignupRequest names the computer's lab either by ID or by college and lab name.
+// Labs must already exist so typos cannot create phantom labs.
 type SystemSignupRequest struct {
+	LabID   string `json:"lab_id"`
 	College string `json:"college"`
 	LabName string `json:"lab_name"`
 }
@@ -26,17 +36,49 @@ func SystemSignup(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate required fields
-	if req.College == "" || req.LabName == "" {
+	// Resolve the lab
+	var lab *models.Lab
+	switch {
+	case req.LabID != "":
+		labID, err := uuid.Parse(req.LabID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid lab_id format",
+			})
+		}
+		if lab, err = models.GetLabByID(config.DB, labID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unknown lab",
+			})
+		}
+	case req.College != "" && req.LabName != "":
+		var err error
+		if lab, err = models.GetLabByName(config.DB, req.College, req.LabName); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unknown lab; ask an admin to create it first",
+			})
+		}
+	default:
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "College and Lab Name are required",
+			"error": "lab_id, or College and Lab Name, are required",
 		})
 	}
 
+	// Mint the collector's device token; only its hash is stored
+	token, tokenHash, err := utils.NewDeviceToken()
+	if err != nil {
+		utils.LogError("Failed to generate device token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to register computer",
+		})
+	}
+	issuedAt := time.Now()
+
 	// Create new computer record
 	computer := &models.Computer{
-		College: req.College,
-		LabName: req.LabName,
+		LabID:           lab.ID,
+		DeviceTokenHash: tokenHash,
+		TokenIssuedAt:   &issuedAt,
 	}
 
 	// Register computer in database
@@ -48,8 +90,10 @@ func SystemSignup(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"message":   "Computer registered successfully",
-		"system_id": computer.ComputerID,
+		"message":      "Computer registered successfully",
+		"system_id":    computer.ComputerID,
+		"lab_id":       lab.ID,
+		"device_token": token,
 	})
 }
 
@@ -87,9 +131,23 @@ func DownloadCollector(c *fiber.Ctx) error {
 	return c.Download(collectorPath, filename)
 }
 
-// GetAllComputers returns a list of all registered computers
+// GetAllComputers returns a list of all registered computers. Archived
+// computers are only included with ?include_archived=true; ?status= filters
+// by lifecycle status.
 func GetAllComputers(c *fiber.Ctx) error {
-	computers, err := models.GetAllComputers(config.DB)
+	scoped := middleware.ScopeFromContext(c).FilterLabs(config.DB, "lab_id")
+	if !c.QueryBool("include_archived") {
+		scoped = scoped.Scopes(models.NotArchived)
+	}
+	if status := c.Query("status"); status != "" {
+		if !models.ValidComputerStatus(status) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "status must be one of active, maintenance, retired",
+			})
+		}
+		scoped = scoped.Where("status = ?", status)
+	}
+	computers, err := models.GetAllComputers(scoped)
 	if err != nil {
 		utils.LogError("Failed to fetch computers: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -97,21 +155,374 @@ func GetAllComputers(c *fiber.Ctx) error {
 		})
 	}
 
-	// Add online status to each computer
-	var response []fiber.Map
-	for _, computer := range computers {
-		response = append(response, fiber.Map{
-			"id":         computer.ID,
-			"system_id":  computer.ComputerID,
-			"college":    computer.College,
-			"lab_name":   computer.LabName,
-			"last_seen":  computer.LastSeen,
-			"is_online":  computer.IsOnline(),
-			"created_at": computer.CreatedAt,
+	return c.JSON(fiber.Map{
+		"data": computerResponses(computers),
+	})
+}
+
+// computerResponses adds online status to each computer for listing
+func computerResponses(computers []models.Computer) []fiber.Map {
+	response := []fiber.Map{}
+	for i := range computers {
+		computer := &computers[i]
+		response = append(response, computerResponse(computer))
+	}
+	return response
+}
+
+func computerResponse(computer *models.Computer) fiber.Map {
+	return fiber.Map{
+		"id":                computer.ID,
+		"system_id":         computer.ComputerID,
+		"name":              computer.Name,
+		"lab_id":            computer.LabID,
+		"college":           computer.CollegeName(),
+		"lab_name":          computer.LabName(),
+		"status":            computer.Status,
+		"status_changed_at": computer.StatusChangedAt,
+		"archived_at":       computer.ArchivedAt,
+		"last_seen":         computer.LastSeen,
+		"is_online":         computer.IsOnlineWithin(heartbeat.Threshold(computer)),
+		"created_at":        computer.CreatedAt,
+	}
+}
+
+// ComputerRequest is the body for updating a computer. Omitted fields keep
+// their current value.
+type ComputerRequest struct {
+	Name   *string    `json:"name"`
+	LabID  *uuid.UUID `json:"lab_id"`
+	Status *string    `json:"status"`
+}
+
+func (req *ComputerRequest) apply(computer *models.Computer) {
+	if req.Name != nil {
+		computer.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.LabID != nil {
+		computer.LabID = *req.LabID
+	}
+	if req.Status != nil {
+		computer.SetStatus(*req.Status)
+	}
+}
+
+// UpdateComputer renames a computer, moves it to another lab or changes its
+// lifecycle status. Retiring a computer archives it, revokes its device token
+// and resolves its open alerts; its history is kept.
+func UpdateComputer(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	scope := middleware.ScopeFromContext(c)
+	if !scope.AllowsLab(computer.LabID) {
+		return outOfScope(c)
+	}
+
+	var req ComputerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.Status != nil && !models.ValidComputerStatus(*req.Status) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "status must be one of active, maintenance, retired",
+		})
+	}
+	if req.Name != nil && len(*req.Name) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "name must be at most 100 characters",
+		})
+	}
+	retiring := req.Status != nil && (*req.Status == models.ComputerRetired) != (computer.Status == models.ComputerRetired)
+	moving := req.LabID != nil && *req.LabID != computer.LabID
+	if (retiring || moving) && scope.Role != models.RoleAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Admin access required to retire, restore or move a computer",
+		})
+	}
+	if moving {
+		lab, err := models.GetLabByID(config.DB, *req.LabID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unknown lab",
+			})
+		}
+		if !scope.AllowsLab(lab.ID) {
+			return outOfScope(c)
+		}
+		computer.Lab = lab
+	}
+
+	previousStatus := computer.Status
+	req.apply(computer)
+
+	if err := computer.SaveDetails(config.DB); err != nil {
+		utils.LogError("Failed to update computer: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update computer",
+		})
+	}
+
+	result := fiber.Map{
+		"message": "Computer updated successfully",
+	}
+	switch {
+	case computer.Status == models.ComputerRetired && previousStatus != models.ComputerRetired:
+		retireComputer(computer, currentUsername(c))
+	case computer.Status != models.ComputerRetired && previousStatus == models.ComputerRetired:
+		// Retiring revoked the token, so the collector needs a new one
+		if token, ok := restoreComputer(computer); ok {
+			result["device_token"] = token
+		}
+	}
+
+	response := computerResponse(computer)
+	websocket.BroadcastResourceUpdate(websocket.ComputerEvent("computer_updated", computer, response))
+
+	result["data"] = response
+	return c.JSON(result)
+}
+
+// retireComputer revokes a newly retired computer's device token and resolves
+// its open alerts. Failures are logged; the computer is already archived.
+func retireComputer(computer *models.Computer, by string) {
+	if err := computer.RevokeDeviceToken(config.DB); err != nil {
+		utils.LogError("Failed to revoke device token of retired computer: %v", err)
+	}
+
+	alerts, err := models.GetOpenAlertsForComputer(config.DB, computer.ComputerID)
+	if err != nil {
+		utils.LogError("Failed to fetch alerts of retired computer: %v", err)
+		return
+	}
+	for i := range alerts {
+		if err := alerts[i].Resolve(config.DB, by); err != nil {
+			utils.LogError("Failed to resolve alert of retired computer: %v", err)
+			continue
+		}
+		websocket.BroadcastResourceUpdate(websocket.AlertEvent(alerting.EventResolved, computer, alerts[i]))
+	}
+}
+
+// restoreComputer issues a new device token to a computer returned to service.
+// Failures are logged; the computer keeps no token until an admin rotates it.
+func restoreComputer(computer *models.Computer) (string, bool) {
+	token, tokenHash, err := utils.NewDeviceToken()
+	if err != nil {
+		utils.LogError("Failed to generate device token of restored computer: %v", err)
+		return "", false
+	}
+	if err := computer.SetDeviceToken(config.DB, tokenHash); err != nil {
+		utils.LogError("Failed to issue device token to restored computer: %v", err)
+		return "", false
+	}
+	return token, true
+}
+
+// DeleteComputer permanently removes a retired computer and all its data
+func DeleteComputer(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if computer.Status != models.ComputerRetired {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Retire the computer before deleting it",
 		})
 	}
 
+	// Samples, alerts, inventory and the rest cascade with the computer
+	if err := config.DB.Delete(&models.Computer{}, "id = ?", computer.ID).Error; err != nil {
+		utils.LogError("Failed to delete computer: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete computer",
+		})
+	}
+
+	websocket.BroadcastResourceUpdate(websocket.ComputerEvent("computer_deleted", computer, fiber.Map{
+		"system_id": computer.ComputerID,
+		"lab_id":    computer.LabID,
+	}))
+
+	return c.JSON(fiber.Map{
+		"message": "Computer deleted successfully",
+	})
+}
+
+// RotateDeviceToken issues a new device token for a computer, invalidating the old one
+func RotateDeviceToken(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	token, tokenHash, err := utils.NewDeviceToken()
+	if err != nil {
+		utils.LogError("Failed to generate device token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rotate device token",
+		})
+	}
+
+	if err := computer.SetDeviceToken(config.DB, tokenHash); err != nil {
+		utils.LogError("Failed to rotate device token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rotate device token",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":         "Device token rotated successfully",
+		"system_id":       computer.ComputerID,
+		"device_token":    token,
+		"token_issued_at": computer.TokenIssuedAt,
+	})
+}
+
+// RevokeDeviceToken revokes a computer's device token so its collector is rejected
+func RevokeDeviceToken(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if err := computer.RevokeDeviceToken(config.DB); err != nil {
+		utils.LogError("Failed to revoke device token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke device token",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "Device token revoked successfully",
+		"system_id":        computer.ComputerID,
+		"token_revoked_at": computer.TokenRevokedAt,
+	})
+}
+
+// IssueMissingDeviceTokens issues a device token to every computer that never
+// had one, for collectors registered before device tokens existed. Each token
+// is shown only once, so calling it again only covers computers added since.
+func IssueMissingDeviceTokens(c *fiber.Ctx) error {
+	computers, err := models.GetComputersWithoutDeviceToken(config.DB)
+	if err != nil {
+		utils.LogError("Failed to fetch computers without device tokens: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue device tokens",
+		})
+	}
+
+	issued := make([]fiber.Map, 0, len(computers))
+	for i := range computers {
+		computer := &computers[i]
+		token, tokenHash, err := utils.NewDeviceToken()
+		if err == nil {
+			err = computer.SetDeviceToken(config.DB, tokenHash)
+		}
+		if err != nil {
+			utils.LogError("Failed to issue device token for %s: %v", computer.ComputerID, err)
+			// The tokens already issued can't be shown again, so return them
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to issue device tokens",
+				"data":  issued,
+			})
+		}
+		issued = append(issued, fiber.Map{
+			"system_id":       computer.ComputerID,
+			"name":            computer.Name,
+			"lab_id":          computer.LabID,
+			"device_token":    token,
+			"token_issued_at": computer.TokenIssuedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("Issued %d device tokens", len(issued)),
+		"data":    issued,
+	})
+}
+
+// GetComputerUptime returns a computer's online/offline history and uptime
+// percentage over the last `hours` hours (default 24)
+func GetComputerUptime(c *fiber.Ctx) error {
+	computer, err := models.GetComputerBySystemID(config.DB, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Computer not found",
+		})
+	}
+
+	if !middleware.ScopeFromContext(c).AllowsLab(computer.LabID) {
+		return outOfScope(c)
+	}
+
+	hours := c.QueryInt("hours", 24)
+	if hours < 1 || hours > 24*90 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "hours must be between 1 and 2160",
+		})
+	}
+
+	now := time.Now()
+	since := now.Add(-time.Duration(hours) * time.Hour)
+
+	periods, err := models.GetStatusHistory(config.DB, computer.ComputerID, since)
+	if err != nil {
+		utils.LogError("Failed to fetch status history: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch status history",
+		})
+	}
+
+	// Clip each period to the window before totalling
+	var online, tracked time.Duration
+	for _, p := range periods {
+		start := p.StartedAt
+		if start.Before(since) {
+			start = since
+		}
+		end := now
+		if p.EndedAt != nil {
+			end = *p.EndedAt
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		tracked += end.Sub(start)
+		if p.Status == models.ComputerOnline {
+			online += end.Sub(start)
+		}
+	}
+
+	var uptime float64
+	if tracked > 0 {
+		uptime = float64(online) / float64(tracked) * 100
+	}
+
 	return c.JSON(fiber.Map{
-		"data": response,
+		"data": periods,
+		"summary": fiber.Map{
+			"system_id":                 computer.ComputerID,
+			"is_online":                 computer.IsOnlineWithin(heartbeat.Threshold(computer)),
+			"window_hours":              hours,
+			"online_seconds":            int64(online.Seconds()),
+			"offline_seconds":           int64((tracked - online).Seconds()),
+			"uptime_percent":            uptime,
+			"offline_threshold_seconds": int64(heartbeat.Threshold(computer).Seconds()),
+		},
 	})
 }
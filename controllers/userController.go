@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// currentUsername returns the authenticated user's name for audit fields
+func currentUsername(c *fiber.Ctx) string {
+	if username, ok := c.Locals("username").(string); ok && username != "" {
+		return username
+	}
+	return "unknown"
+}
+
+// outOfScope responds for resources in labs the caller is not assigned to
+func outOfScope(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "Access to this lab is not permitted",
+	})
+}
+
+type UserLabsRequest struct {
+	LabIDs []uuid.UUID `json:"lab_ids"`
+}
+
+// userResponse describes a user with their lab assignments
+func userResponse(user *models.User, labIDs []uuid.UUID) fiber.Map {
+	if labIDs == nil {
+		labIDs = []uuid.UUID{}
+	}
+	return fiber.Map{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"lab_ids":  labIDs,
+	}
+}
+
+// GetUsers returns all users with their lab assignments
+func GetUsers(c *fiber.Ctx) error {
+	var users []models.User
+	if err := config.DB.Order("username").Find(&users).Error; err != nil {
+		utils.LogError("Failed to fetch users: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+
+	var assignments []models.UserLab
+	if err := config.DB.Find(&assignments).Error; err != nil {
+		utils.LogError("Failed to fetch lab assignments: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+
+	labsByUser := make(map[uuid.UUID][]uuid.UUID)
+	for _, a := range assignments {
+		labsByUser[a.UserID] = append(labsByUser[a.UserID], a.LabID)
+	}
+
+	response := []fiber.Map{}
+	for i := range users {
+		response = append(response, userResponse(&users[i], labsByUser[users[i].ID]))
+	}
+
+	return c.JSON(fiber.Map{
+		"data": response,
+	})
+}
+
+// SetUserLabs replaces the labs a lab manager is responsible for
+func SetUserLabs(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if user.Role != models.RoleLabManager {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Labs can only be assigned to lab managers",
+		})
+	}
+
+	var req UserLabsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Drop duplicates and check every lab exists
+	seen := make(map[uuid.UUID]bool)
+	var labIDs []uuid.UUID
+	for _, labID := range req.LabIDs {
+		if seen[labID] {
+			continue
+		}
+		seen[labID] = true
+		labIDs = append(labIDs, labID)
+	}
+
+	var found int64
+	if len(labIDs) > 0 {
+		if err := config.DB.Model(&models.Lab{}).Where("id IN ?", labIDs).Count(&found).Error; err != nil {
+			utils.LogError("Failed to check labs: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to assign labs",
+			})
+		}
+	}
+	if int(found) != len(labIDs) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "One or more labs do not exist",
+		})
+	}
+
+	if err := models.SetUserLabs(config.DB, user.ID, labIDs); err != nil {
+		utils.LogError("Failed to assign labs: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to assign labs",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Lab assignments updated successfully",
+		"data":    userResponse(&user, labIDs),
+	})
+}
+
+// LogoutUser ends every session of a user, e.g. after a lost device
+func LogoutUser(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if err := models.RevokeUserSessions(config.DB, user.ID); err != nil {
+		utils.LogError("Failed to log out user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out user",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "User logged out of all sessions",
+	})
+}
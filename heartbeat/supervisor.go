@@ -0,0 +1,332 @@
+package heartbeat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Alert types raised by the supervisor
+const (
+	AlertComputerOffline = "COMPUTER_OFFLINE"
+	AlertComputerOnline  = "COMPUTER_ONLINE"
+)
+
+const (
+	defaultInterval  = 30 * time.Second
+	defaultThreshold = 5 * time.Minute
+)
+
+// missedSamples is how many configured sample intervals a computer may miss
+// before it counts as offline, whatever its lab's threshold
+const missedSamples = 3
+
+// Supervisor periodically checks every computer's LastSeen and records
+// online/offline transitions
+type Supervisor struct {
+	db               *gorm.DB
+	interval         time.Duration
+	defaultThreshold time.Duration
+
+	mu         sync.Mutex
+	online     map[string]bool
+	thresholds map[uuid.UUID]time.Duration
+	intervals  map[string]time.Duration // sample interval overrides by computer
+	paused     map[string]bool
+	configured map[string]time.Time // when each computer's collector config last changed
+}
+
+// Default is the process-wide supervisor, set by Start
+var Default *Supervisor
+
+// Start launches the supervisor in the background. HEARTBEAT_INTERVAL and
+// OFFLINE_THRESHOLD (Go durations, e.g. "30s", "5m") override the defaults.
+func Start(db *gorm.DB) *Supervisor {
+	s := &Supervisor{
+		db:               db,
+		interval:         utils.EnvDuration("HEARTBEAT_INTERVAL", defaultInterval),
+		defaultThreshold: utils.EnvDuration("OFFLINE_THRESHOLD", defaultThreshold),
+		online:           make(map[string]bool),
+		thresholds:       make(map[uuid.UUID]time.Duration),
+		intervals:        make(map[string]time.Duration),
+		paused:           make(map[string]bool),
+		configured:       make(map[string]time.Time),
+	}
+	Default = s
+
+	go s.run()
+	return s
+}
+
+func (s *Supervisor) run() {
+	if err := s.restore(); err != nil {
+		utils.LogError("Failed to restore computer status history: %v", err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Scan(); err != nil {
+			utils.LogError("Heartbeat scan failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// restore loads each computer's last known state so restarts don't re-announce transitions
+func (s *Supervisor) restore() error {
+	periods, err := models.GetCurrentStatusPeriods(s.db)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range periods {
+		s.online[p.ComputerID] = p.Status == models.ComputerOnline
+	}
+	return nil
+}
+
+// ReloadThresholds refreshes the per-lab offline thresholds and the
+// collector settings that affect them
+func (s *Supervisor) ReloadThresholds() error {
+	labs, err := models.GetLabs(s.db, nil)
+	if err != nil {
+		return err
+	}
+	configs, err := models.GetCollectorConfigs(s.db)
+	if err != nil {
+		return err
+	}
+
+	thresholds := make(map[uuid.UUID]time.Duration)
+	for _, lab := range labs {
+		if lab.OfflineThresholdSeconds != nil {
+			thresholds[lab.ID] = time.Duration(*lab.OfflineThresholdSeconds) * time.Second
+		}
+	}
+
+	intervals := make(map[string]time.Duration)
+	paused := make(map[string]bool)
+	configured := make(map[string]time.Time)
+	for _, cfg := range configs {
+		if cfg.UpdatedAt != nil {
+			configured[cfg.ComputerID] = *cfg.UpdatedAt
+		}
+		if cfg.SampleIntervalSeconds != nil {
+			intervals[cfg.ComputerID] = time.Duration(*cfg.SampleIntervalSeconds) * time.Second
+		}
+		if cfg.Paused {
+			paused[cfg.ComputerID] = true
+		}
+	}
+
+	s.mu.Lock()
+	s.thresholds = thresholds
+	s.intervals = intervals
+	s.paused = paused
+	s.configured = configured
+	s.mu.Unlock()
+	return nil
+}
+
+// Threshold returns how long a computer may be silent before it counts as
+// offline: its lab's threshold, stretched to cover a few sample intervals
+// if the collector was told to sample less often
+func (s *Supervisor) Threshold(computer *models.Computer) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	threshold := s.defaultThreshold
+	if d, ok := s.thresholds[computer.LabID]; ok {
+		threshold = d
+	}
+	if d := missedSamples * s.intervals[computer.ComputerID]; d > threshold {
+		threshold = d
+	}
+	return threshold
+}
+
+// Paused reports whether the computer's collector was told to stop sampling
+func (s *Supervisor) Paused(computer *models.Computer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused[computer.ComputerID]
+}
+
+// silentSince returns when the computer's silence started counting: its last
+// contact, or a later config change, so a collector that was just resumed or
+// slowed down gets a full threshold to pick the change up
+func (s *Supervisor) silentSince(computer *models.Computer) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if changed := s.configured[computer.ComputerID]; changed.After(computer.LastSeen) {
+		return changed
+	}
+	return computer.LastSeen
+}
+
+// Threshold returns the offline threshold for a computer, falling back to the
+// default if the supervisor is not running
+func Threshold(computer *models.Computer) time.Duration {
+	if Default == nil {
+		return defaultThreshold
+	}
+	return Default.Threshold(computer)
+}
+
+// Scan checks every computer once and handles any state changes
+func (s *Supervisor) Scan() error {
+	if err := s.ReloadThresholds(); err != nil {
+		return err
+	}
+
+	computers, err := models.GetAllComputers(s.db.Scopes(models.NotArchived))
+	if err != nil {
+		return err
+	}
+
+	for i := range computers {
+		computer := &computers[i]
+		if computer.LastSeen.IsZero() {
+			// Registered but never reported; nothing to track yet
+			continue
+		}
+		if s.Paused(computer) {
+			// Silent on purpose; keep the last known state until it resumes
+			continue
+		}
+
+		silence := time.Since(s.silentSince(computer))
+		s.evaluateRules(computer, silence)
+
+		online := silence <= s.Threshold(computer)
+
+		s.mu.Lock()
+		previous, known := s.online[computer.ComputerID]
+		s.online[computer.ComputerID] = online
+		s.mu.Unlock()
+
+		if known && previous == online {
+			continue
+		}
+
+		if err := s.transition(computer, online); err != nil {
+			utils.LogError("Failed to record status change for %s: %v", computer.ComputerID, err)
+			// Retry on the next scan
+			s.mu.Lock()
+			if known {
+				s.online[computer.ComputerID] = previous
+			} else {
+				delete(s.online, computer.ComputerID)
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// evaluateRules checks the offline alert rules against how long the computer
+// has been silent and notifies dashboards of the alerts they fire
+func (s *Supervisor) evaluateRules(computer *models.Computer, silence time.Duration) {
+	events, err := alerting.Default.EvaluateOffline(s.db, computer, silence, time.Now())
+	if err != nil {
+		utils.LogError("Failed to evaluate offline rules for %s: %v", computer.ComputerID, err)
+	}
+	for _, event := range events {
+		websocket.BroadcastResourceUpdate(websocket.AlertEvent(event.Type, computer, event.Alert))
+	}
+}
+
+// transition records a state change, raises or resolves the offline alert
+// and notifies dashboards
+func (s *Supervisor) transition(computer *models.Computer, online bool) error {
+	status := models.ComputerOffline
+	if online {
+		status = models.ComputerOnline
+	}
+
+	// Offline periods start from the last contact, not from when we noticed
+	closed, err := models.RecordStatusChange(s.db, computer.ComputerID, status, computer.LastSeen)
+	if err != nil {
+		return err
+	}
+
+	websocket.BroadcastResourceUpdate(websocket.ComputerEvent("computer_status", computer, fiber.Map{
+		"computer_id": computer.ComputerID,
+		"status":      status,
+		"last_seen":   computer.LastSeen,
+	}))
+
+	fingerprint := models.AlertFingerprint("offline", computer.ComputerID)
+
+	if !online {
+		if computer.AlertsSuppressed() {
+			return nil
+		}
+		alert, created, err := alerting.Default.Raise(s.db, models.Alert{
+			ComputerID:  computer.ComputerID,
+			Type:        AlertComputerOffline,
+			Message:     fmt.Sprintf("Computer has not reported since %s", computer.LastSeen.Format(time.RFC3339)),
+			Timestamp:   time.Now(),
+			Severity:    models.SeverityWarning,
+			Fingerprint: fingerprint,
+		})
+		if err != nil {
+			return err
+		}
+		if created {
+			websocket.BroadcastResourceUpdate(websocket.AlertEvent(alerting.EventFired, computer, alert))
+		}
+		return nil
+	}
+
+	resolved, err := alerting.Default.Clear(s.db, fingerprint)
+	if err != nil {
+		return err
+	}
+	if resolved == nil {
+		// Came online without having been reported offline
+		return nil
+	}
+
+	websocket.BroadcastResourceUpdate(websocket.AlertEvent(alerting.EventResolved, computer, resolved))
+
+	message := "Computer is back online"
+	if closed != nil && closed.Status == models.ComputerOffline {
+		message = fmt.Sprintf("Computer is back online after %s offline", computer.LastSeen.Sub(closed.StartedAt).Round(time.Second))
+	}
+
+	// Record the recovery as an informational alert that needs no action
+	now := time.Now()
+	recovered := models.Alert{
+		ComputerID:  computer.ComputerID,
+		Type:        AlertComputerOnline,
+		Message:     message,
+		Timestamp:   now,
+		Severity:    models.SeverityInfo,
+		Status:      models.AlertStatusResolved,
+		Resolved:    true,
+		ResolvedAt:  &now,
+		ResolvedBy:  "auto",
+		Fingerprint: models.AlertFingerprint("online", computer.ComputerID),
+	}
+	if err := s.db.Create(&recovered).Error; err != nil {
+		return err
+	}
+
+	websocket.BroadcastResourceUpdate(websocket.AlertEvent(alerting.EventFired, computer, recovered))
+	return nil
+}
@@ -0,0 +1,539 @@
+package ingest
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/rollup"
+	"github.com/Frhnmj2004/LabMonitoring-server/storage"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultQueueSize     = 10000
+	defaultWorkers       = 4
+	defaultBatchSize     = 500
+	defaultFlushInterval = 250 * time.Millisecond
+	defaultRetryAfter    = 2 * time.Second
+	defaultMaxClockSkew  = 5 * time.Minute
+	defaultMaxSampleAge  = 7 * 24 * time.Hour // if the raw retention policy can't be read
+)
+
+var (
+	// ErrQueueFull is returned when the samples don't fit in the queue; the
+	// client should retry after RetryAfter
+	ErrQueueFull = errors.New("ingestion queue is full")
+
+	// ErrTooLarge is returned for batches that could never fit in the queue
+	ErrTooLarge = errors.New("batch is larger than the ingestion queue")
+
+	// ErrClosed is returned once the pipeline is shutting down
+	ErrClosed = errors.New("ingestion pipeline is closed")
+
+	// ErrFutureTimestamp and ErrStaleTimestamp reject samples whose capture
+	// time is outside the accepted window
+	ErrFutureTimestamp = errors.New("timestamp is too far in the future")
+	ErrStaleTimestamp  = errors.New("timestamp is too old")
+)
+
+// Sample is a validated resource sample waiting to be stored. Log.ID and
+// Log.Timestamp are set before it is enqueued.
+type Sample struct {
+	Log      models.ResourceLog
+	Computer *models.Computer
+}
+
+// Stats is a snapshot of the pipeline's queue and counters
+type Stats struct {
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	Workers       int    `json:"workers"`
+	Enqueued      uint64 `json:"enqueued"`
+	Dropped       uint64 `json:"dropped"` // refused with 429 because the queue was full
+	Written       uint64 `json:"written"`
+	Duplicates    uint64 `json:"duplicates"` // skipped because the computer/seq was already stored
+	Buffered      uint64 `json:"buffered"`   // sent to the spool after a database error
+	Lost          uint64 `json:"lost"`       // could not be stored anywhere
+	Batches       uint64 `json:"batches"`
+
+	Spool storage.SpoolStats `json:"spool"`
+}
+
+// stored is a written batch with the alert events it produced
+type stored struct {
+	samples []*Sample
+	events  []alerting.Event
+}
+
+// shard owns the samples of a fixed subset of computers, so each computer's
+// samples are stored, evaluated and broadcast in order
+type shard struct {
+	queue     chan *Sample
+	evaluate  chan []*Sample
+	broadcast chan stored
+}
+
+// Pipeline stores samples in the background: handlers enqueue, writers insert
+// in batches, then alert evaluation and websocket fan-out run as later stages
+type Pipeline struct {
+	db            *gorm.DB
+	spool         *storage.Spool
+	shards        []*shard
+	capacity      int
+	batchSize     int
+	flushInterval time.Duration
+	RetryAfter    time.Duration
+	MaxClockSkew  time.Duration // how far ahead of the server a capture time may be
+
+	// maxSampleAge is how old a capture time may be; 0 accepts any age. It
+	// follows the raw retention policy unless fixedSampleAge is set.
+	maxSampleAge   atomic.Int64
+	fixedSampleAge bool
+
+	// write stores a batch and returns the samples that were stored, and
+	// evaluate runs the alert rules over one of them. Tests stub them out.
+	write    func(batch []*Sample) []*Sample
+	evaluate func(sample *Sample) ([]alerting.Event, error)
+
+	enqueueMu sync.Mutex // makes multi-sample enqueues all-or-nothing
+	closed    bool
+	wg        sync.WaitGroup
+
+	enqueued, dropped, written, duplicates, buffered, lost, batches atomic.Uint64
+}
+
+// Default is the process-wide pipeline, set by Start
+var Default *Pipeline
+
+// Start launches the pipeline. Samples that cannot be written go to spool and
+// are replayed in the background once the database accepts writes again.
+// INGEST_QUEUE_SIZE, INGEST_WORKERS, INGEST_BATCH_SIZE, INGEST_FLUSH_INTERVAL,
+// INGEST_RETRY_AFTER and INGEST_MAX_CLOCK_SKEW override the defaults;
+// INGEST_MAX_SAMPLE_AGE replaces the raw retention period as the oldest
+// capture time accepted.
+func Start(db *gorm.DB, spool *storage.Spool) *Pipeline {
+	p := newPipeline(db, spool,
+		utils.EnvInt("INGEST_WORKERS", defaultWorkers),
+		utils.EnvInt("INGEST_QUEUE_SIZE", defaultQueueSize),
+		utils.EnvInt("INGEST_BATCH_SIZE", defaultBatchSize),
+		utils.EnvDuration("INGEST_FLUSH_INTERVAL", defaultFlushInterval))
+	p.RetryAfter = utils.EnvDuration("INGEST_RETRY_AFTER", defaultRetryAfter)
+	p.MaxClockSkew = utils.EnvDuration("INGEST_MAX_CLOCK_SKEW", defaultMaxClockSkew)
+
+	// 0 is allowed here, accepting samples of any age
+	if age, ok := utils.EnvOptionalDuration("INGEST_MAX_SAMPLE_AGE"); ok {
+		p.maxSampleAge.Store(int64(age))
+		p.fixedSampleAge = true
+	} else if err := p.RefreshMaxSampleAge(); err != nil {
+		utils.LogWarning("Failed to read the raw retention policy, accepting samples up to %s old: %v", defaultMaxSampleAge, err)
+		p.maxSampleAge.Store(int64(defaultMaxSampleAge))
+	}
+
+	p.start()
+	spool.StartReplay(p.replay)
+
+	Default = p
+	return p
+}
+
+// newPipeline returns a pipeline with queueSize split over workers shards,
+// storing to db. Its stages run once start is called.
+func newPipeline(db *gorm.DB, spool *storage.Spool, workers, queueSize, batchSize int, flushInterval time.Duration) *Pipeline {
+	p := &Pipeline{
+		db:            db,
+		spool:         spool,
+		capacity:      queueSize,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		RetryAfter:    defaultRetryAfter,
+		MaxClockSkew:  defaultMaxClockSkew,
+	}
+	p.write = p.store
+	p.evaluate = func(sample *Sample) ([]alerting.Event, error) {
+		return alerting.Default.Evaluate(p.db, &sample.Log, sample.Computer)
+	}
+
+	perShard := (queueSize + workers - 1) / workers
+	for i := 0; i < workers; i++ {
+		p.shards = append(p.shards, &shard{
+			queue:     make(chan *Sample, perShard),
+			evaluate:  make(chan []*Sample, 4),
+			broadcast: make(chan stored, 4),
+		})
+	}
+	return p
+}
+
+// start launches the write, evaluate and broadcast stages of every shard
+func (p *Pipeline) start() {
+	for _, s := range p.shards {
+		p.wg.Add(3)
+		go p.writeStage(s)
+		go p.evaluateStage(s)
+		go p.broadcastStage(s)
+	}
+}
+
+func (p *Pipeline) shardFor(computerID string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(computerID))
+	return p.shards[h.Sum32()%uint32(len(p.shards))]
+}
+
+// CheckTimestamp validates a sample's capture time against the clock-skew
+// window around now
+func (p *Pipeline) CheckTimestamp(t, now time.Time) error {
+	if t.After(now.Add(p.MaxClockSkew)) {
+		return ErrFutureTimestamp
+	}
+	if age := p.MaxSampleAge(); age > 0 && t.Before(now.Add(-age)) {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+// MaxSampleAge is how old a capture time may be; 0 accepts any age
+func (p *Pipeline) MaxSampleAge() time.Duration {
+	return time.Duration(p.maxSampleAge.Load())
+}
+
+// RefreshMaxSampleAge sets the oldest capture time accepted from the raw
+// samples' retention period, leaving room for the rollups to be recomputed
+// before their raw samples are pruned (see rollup.MaxSampleAge). It does
+// nothing when INGEST_MAX_SAMPLE_AGE is set.
+func (p *Pipeline) RefreshMaxSampleAge() error {
+	if p.fixedSampleAge {
+		return nil
+	}
+	policy, err := models.GetRetentionPolicy(p.db, models.RawSamplesPolicy)
+	if err != nil {
+		return err
+	}
+
+	var age time.Duration
+	if policy.Enabled && policy.RetentionDays > 0 {
+		age = rollup.MaxSampleAge(time.Duration(policy.RetentionDays) * 24 * time.Hour)
+	}
+	p.maxSampleAge.Store(int64(age))
+	return nil
+}
+
+// Enqueue queues one sample, or returns ErrQueueFull without blocking
+func (p *Pipeline) Enqueue(sample *Sample) error {
+	return p.EnqueueAll([]*Sample{sample})
+}
+
+// EnqueueAll queues every sample or none of them. Samples of one computer
+// must be in time order.
+func (p *Pipeline) EnqueueAll(samples []*Sample) error {
+	p.enqueueMu.Lock()
+	defer p.enqueueMu.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	// Only enqueuers add to the queues and they hold the lock, so free space
+	// can only grow between this check and the sends below
+	need := make(map[*shard]int)
+	for _, sample := range samples {
+		need[p.shardFor(sample.Log.ComputerID)]++
+	}
+	for s, n := range need {
+		if n > cap(s.queue) {
+			return ErrTooLarge
+		}
+		if cap(s.queue)-len(s.queue) < n {
+			p.dropped.Add(uint64(len(samples)))
+			return ErrQueueFull
+		}
+	}
+
+	for _, sample := range samples {
+		p.shardFor(sample.Log.ComputerID).queue <- sample
+	}
+	p.enqueued.Add(uint64(len(samples)))
+	return nil
+}
+
+// Close stops accepting samples and waits for queued ones to be processed
+func (p *Pipeline) Close() {
+	p.enqueueMu.Lock()
+	if p.closed {
+		p.enqueueMu.Unlock()
+		return
+	}
+	p.closed = true
+	for _, s := range p.shards {
+		close(s.queue)
+	}
+	p.enqueueMu.Unlock()
+
+	p.wg.Wait()
+}
+
+// Stats returns the current queue depth and counters
+func (p *Pipeline) Stats() Stats {
+	stats := Stats{
+		QueueCapacity: p.capacity,
+		Workers:       len(p.shards),
+		Enqueued:      p.enqueued.Load(),
+		Dropped:       p.dropped.Load(),
+		Written:       p.written.Load(),
+		Duplicates:    p.duplicates.Load(),
+		Buffered:      p.buffered.Load(),
+		Lost:          p.lost.Load(),
+		Batches:       p.batches.Load(),
+		Spool:         p.spool.Stats(),
+	}
+	for _, s := range p.shards {
+		stats.QueueDepth += len(s.queue)
+	}
+	return stats
+}
+
+// writeStage collects samples into batches by size or age and stores them
+func (p *Pipeline) writeStage(s *shard) {
+	defer p.wg.Done()
+	defer close(s.evaluate)
+
+	timer := time.NewTimer(p.flushInterval)
+	timer.Stop()
+
+	var batch []*Sample
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if stored := p.write(batch); len(stored) > 0 {
+			s.evaluate <- stored
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case sample, ok := <-s.queue:
+			if !ok {
+				timer.Stop()
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(p.flushInterval)
+			}
+			batch = append(batch, sample)
+			if len(batch) >= p.batchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// store inserts a batch with one statement and marks its computers as seen.
+// It returns the samples that were stored: resent samples whose sequence
+// number is already stored are skipped, and if the database is unavailable
+// the samples go to the spool instead.
+func (p *Pipeline) store(batch []*Sample) []*Sample {
+	p.batches.Add(1)
+
+	logs := make([]models.ResourceLog, len(batch))
+	for i, sample := range batch {
+		logs[i] = sample.Log
+	}
+
+	result := p.db.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&logs)
+	if err := result.Error; err != nil {
+		utils.LogWarning("Failed to save %d samples to database, writing to spool: %v", len(logs), err)
+		if err := p.spool.Write(logs); err != nil {
+			utils.LogError("Failed to write to spool: %v", err)
+			p.lost.Add(uint64(len(logs)))
+			return nil
+		}
+		p.buffered.Add(uint64(len(logs)))
+		return nil
+	}
+	if result.RowsAffected < int64(len(logs)) {
+		batch = p.dropDuplicates(batch)
+	}
+	p.written.Add(uint64(len(batch)))
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// The database is reachable again; don't wait for the next replay tick
+	if p.spool.Pending() {
+		p.spool.Kick()
+	}
+
+	stored := make([]models.ResourceLog, len(batch))
+	for i, sample := range batch {
+		stored[i] = sample.Log
+	}
+	if err := models.SaveProcessSnapshots(p.db, stored); err != nil {
+		utils.LogError("Failed to save process snapshots: %v", err)
+	}
+
+	var computerIDs []string
+	seen := make(map[string]bool)
+	earliest := stored[0].Timestamp
+	for _, log := range stored {
+		if !seen[log.ComputerID] {
+			seen[log.ComputerID] = true
+			computerIDs = append(computerIDs, log.ComputerID)
+		}
+		if log.Timestamp.Before(earliest) {
+			earliest = log.Timestamp
+		}
+	}
+
+	if err := models.TouchComputers(p.db, computerIDs, time.Now()); err != nil {
+		utils.LogError("Failed to update computer last seen: %v", err)
+	}
+	if err := rollup.NoteLateSamples(p.db, earliest); err != nil {
+		utils.LogError("Failed to schedule rollup of late samples: %v", err)
+	}
+	return batch
+}
+
+// dropDuplicates returns the samples of a batch that were actually inserted;
+// the rest conflicted with an already stored computer/seq
+func (p *Pipeline) dropDuplicates(batch []*Sample) []*Sample {
+	ids := make([]uuid.UUID, len(batch))
+	for i, sample := range batch {
+		ids[i] = sample.Log.ID
+	}
+
+	var inserted []uuid.UUID
+	if err := p.db.Model(&models.ResourceLog{}).Where("id IN ?", ids).Pluck("id", &inserted).Error; err != nil {
+		utils.LogError("Failed to check for duplicate samples: %v", err)
+		return batch
+	}
+	found := make(map[uuid.UUID]bool, len(inserted))
+	for _, id := range inserted {
+		found[id] = true
+	}
+
+	var kept []*Sample
+	for _, sample := range batch {
+		if found[sample.Log.ID] {
+			kept = append(kept, sample)
+		}
+	}
+	p.duplicates.Add(uint64(len(batch) - len(kept)))
+	return kept
+}
+
+// replay stores spooled samples. Samples keep the ID and sequence number they
+// were given at ingestion, so ones already stored by an interrupted replay
+// are skipped; samples of computers deleted in the meantime are discarded.
+func (p *Pipeline) replay(logs []models.ResourceLog) (int, error) {
+	var computerIDs []string
+	seen := make(map[string]bool)
+	for _, log := range logs {
+		if !seen[log.ComputerID] {
+			seen[log.ComputerID] = true
+			computerIDs = append(computerIDs, log.ComputerID)
+		}
+	}
+
+	computers, err := models.GetComputersBySystemIDs(p.db, computerIDs)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool, len(computers))
+	for _, computer := range computers {
+		known[computer.ComputerID] = true
+	}
+
+	var keep []models.ResourceLog
+	var earliest time.Time
+	for _, log := range logs {
+		if !known[log.ComputerID] {
+			continue
+		}
+		keep = append(keep, log)
+		if earliest.IsZero() || log.Timestamp.Before(earliest) {
+			earliest = log.Timestamp
+		}
+	}
+	if len(keep) == 0 {
+		return len(logs), nil
+	}
+
+	if err := p.db.Omit(clause.Associations).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&keep).Error; err != nil {
+		return 0, err
+	}
+	if err := models.SaveProcessSnapshots(p.db, keep); err != nil {
+		return 0, err
+	}
+	if err := rollup.NoteLateSamples(p.db, earliest); err != nil {
+		utils.LogError("Failed to schedule rollup of replayed samples: %v", err)
+	}
+	return len(logs) - len(keep), nil
+}
+
+// evaluateStage runs the alert rules over stored samples in order
+func (p *Pipeline) evaluateStage(s *shard) {
+	defer p.wg.Done()
+	defer close(s.broadcast)
+
+	for batch := range s.evaluate {
+		var events []alerting.Event
+		for _, sample := range batch {
+			evs, err := p.evaluate(sample)
+			if err != nil {
+				utils.LogError("Failed to evaluate alert rules: %v", err)
+			}
+			events = append(events, evs...)
+		}
+		s.broadcast <- stored{samples: batch, events: events}
+	}
+}
+
+// broadcastStage sends alert transitions and each computer's newest sample to dashboards
+func (p *Pipeline) broadcastStage(s *shard) {
+	defer p.wg.Done()
+
+	for result := range s.broadcast {
+		computers := make(map[string]*models.Computer)
+		for _, sample := range result.samples {
+			computers[sample.Log.ComputerID] = sample.Computer
+		}
+		for _, event := range result.events {
+			websocket.BroadcastResourceUpdate(websocket.AlertEvent(event.Type, computers[event.Alert.ComputerID], event.Alert))
+		}
+
+		newest := make(map[string]*Sample)
+		var order []string
+		for _, sample := range result.samples {
+			current, ok := newest[sample.Log.ComputerID]
+			if !ok {
+				order = append(order, sample.Log.ComputerID)
+			}
+			if !ok || !sample.Log.Timestamp.Before(current.Log.Timestamp) {
+				newest[sample.Log.ComputerID] = sample
+			}
+		}
+		for _, id := range order {
+			sample := newest[id]
+			log := sample.Log
+			log.Computer = *sample.Computer
+			websocket.BroadcastResourceUpdate(websocket.ComputerEvent("resource_update", sample.Computer, log))
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/alerting"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/google/uuid"
+)
+
+// recorder stands in for the database: it keeps every batch written and the
+// order samples were evaluated in
+type recorder struct {
+	mu        sync.Mutex
+	batches   [][]*Sample
+	evaluated map[string][]time.Time
+	written   chan []*Sample
+}
+
+// testPipeline returns a pipeline whose write and evaluate stages are
+// recorded instead of touching the database. Its stages are not running
+// until start is called.
+func testPipeline(t *testing.T, workers, queueSize, batchSize int, flushInterval time.Duration) (*Pipeline, *recorder) {
+	p := newPipeline(nil, nil, workers, queueSize, batchSize, flushInterval)
+	r := &recorder{
+		evaluated: make(map[string][]time.Time),
+		written:   make(chan []*Sample, 1000),
+	}
+	p.write = func(batch []*Sample) []*Sample {
+		r.mu.Lock()
+		r.batches = append(r.batches, batch)
+		r.mu.Unlock()
+		select {
+		case r.written <- batch:
+		default: // only the flush tests wait on batches
+		}
+		return batch
+	}
+	p.evaluate = func(sample *Sample) ([]alerting.Event, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		id := sample.Log.ComputerID
+		r.evaluated[id] = append(r.evaluated[id], sample.Log.Timestamp)
+		return nil, nil
+	}
+	t.Cleanup(p.Close)
+	return p, r
+}
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testSample returns the n-th sample of a computer
+func testSample(computerID string, n int) *Sample {
+	return &Sample{
+		Log: models.ResourceLog{
+			ID:         uuid.New(),
+			ComputerID: computerID,
+			Timestamp:  epoch.Add(time.Duration(n) * time.Second),
+		},
+		Computer: &models.Computer{ComputerID: computerID, LabID: uuid.New()},
+	}
+}
+
+// spreadIDs returns n computer IDs that land on n different shards
+func spreadIDs(t *testing.T, p *Pipeline, n int) []string {
+	t.Helper()
+	var ids []string
+	used := make(map[*shard]bool)
+	for i := 0; len(ids) < n && i < 1000; i++ {
+		id := fmt.Sprintf("pc-%d", i)
+		if s := p.shardFor(id); !used[s] {
+			used[s] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < n {
+		t.Fatalf("found only %d computers on distinct shards", len(ids))
+	}
+	return ids
+}
+
+func depths(p *Pipeline) []int {
+	out := make([]int, len(p.shards))
+	for i, s := range p.shards {
+		out[i] = len(s.queue)
+	}
+	return out
+}
+
+func TestEnqueueAllIsAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name    string
+		queued  []int // samples already queued per computer
+		batch   []int // samples enqueued together, by computer index
+		want    error
+		dropped uint64
+	}{
+		{
+			name:   "fits",
+			queued: []int{1, 0},
+			batch:  []int{0, 1, 1},
+		},
+		{
+			name:    "one shard full",
+			queued:  []int{1, 0},
+			batch:   []int{1, 0, 0},
+			want:    ErrQueueFull,
+			dropped: 3,
+		},
+		{
+			name:   "larger than a shard",
+			queued: []int{0, 0},
+			batch:  []int{1, 0, 0, 0},
+			want:   ErrTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Stages aren't started, so queued samples stay queued
+			p, _ := testPipeline(t, 2, 4, 100, time.Hour)
+			ids := spreadIDs(t, p, 2)
+
+			for i, n := range tt.queued {
+				for j := 0; j < n; j++ {
+					if err := p.Enqueue(testSample(ids[i], j)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			before := depths(p)
+
+			var batch []*Sample
+			for n, i := range tt.batch {
+				batch = append(batch, testSample(ids[i], 100+n))
+			}
+			err := p.EnqueueAll(batch)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("EnqueueAll = %v, want %v", err, tt.want)
+			}
+
+			after := depths(p)
+			if tt.want != nil && fmt.Sprint(after) != fmt.Sprint(before) {
+				t.Errorf("queues changed from %v to %v on error", before, after)
+			}
+			if tt.want == nil && sum(after) != sum(before)+len(batch) {
+				t.Errorf("queued %d samples, want %d", sum(after)-sum(before), len(batch))
+			}
+			if got := p.dropped.Load(); got != tt.dropped {
+				t.Errorf("dropped = %d, want %d", got, tt.dropped)
+			}
+		})
+	}
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// nextBatch waits for the next written batch
+func nextBatch(t *testing.T, r *recorder, timeout time.Duration) []*Sample {
+	t.Helper()
+	select {
+	case batch := <-r.written:
+		return batch
+	case <-time.After(timeout):
+		t.Fatal("no batch was written")
+		return nil
+	}
+}
+
+func TestFlushOnBatchSize(t *testing.T) {
+	p, r := testPipeline(t, 1, 100, 3, time.Hour)
+	p.start()
+
+	for i := 0; i < 4; i++ {
+		if err := p.Enqueue(testSample("pc-1", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if batch := nextBatch(t, r, 5*time.Second); len(batch) != 3 {
+		t.Errorf("first batch has %d samples, want 3", len(batch))
+	}
+	select {
+	case batch := <-r.written:
+		t.Errorf("a batch of %d was written before the flush interval", len(batch))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFlushOnInterval(t *testing.T) {
+	p, r := testPipeline(t, 1, 100, 100, 20*time.Millisecond)
+	p.start()
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := p.Enqueue(testSample("pc-1", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if batch := nextBatch(t, r, 5*time.Second); len(batch) != 2 {
+		t.Errorf("batch has %d samples, want 2", len(batch))
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("batch was written after %s, before the flush interval", waited)
+	}
+}
+
+func TestSamplesOfAComputerAreEvaluatedInOrder(t *testing.T) {
+	p, r := testPipeline(t, 4, 1000, 7, time.Millisecond)
+	p.start()
+	ids := []string{"pc-1", "pc-2", "pc-3", "pc-4", "pc-5"}
+
+	const perComputer = 200
+	for n := 0; n < perComputer; n += 10 {
+		var batch []*Sample
+		for i := n; i < n+10; i++ {
+			for _, id := range ids {
+				batch = append(batch, testSample(id, i))
+			}
+		}
+		for {
+			err := p.EnqueueAll(batch)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ErrQueueFull) {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	p.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		got := r.evaluated[id]
+		if len(got) != perComputer {
+			t.Errorf("%s: evaluated %d samples, want %d", id, len(got), perComputer)
+			continue
+		}
+		for i := 1; i < len(got); i++ {
+			if !got[i].After(got[i-1]) {
+				t.Errorf("%s: sample %d evaluated after a newer one", id, i)
+				break
+			}
+		}
+	}
+}
+
+func TestCloseDrainsQueuedSamples(t *testing.T) {
+	p, r := testPipeline(t, 2, 100, 1000, time.Hour)
+	p.start()
+
+	for i := 0; i < 50; i++ {
+		if err := p.Enqueue(testSample(fmt.Sprintf("pc-%d", i%3), i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+
+	r.mu.Lock()
+	written, evaluated := 0, 0
+	for _, batch := range r.batches {
+		written += len(batch)
+	}
+	for _, times := range r.evaluated {
+		evaluated += len(times)
+	}
+	r.mu.Unlock()
+	if written != 50 || evaluated != 50 {
+		t.Errorf("after Close: %d written, %d evaluated; want 50 of each", written, evaluated)
+	}
+
+	if err := p.Enqueue(testSample("pc-0", 50)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Enqueue after Close = %v, want ErrClosed", err)
+	}
+}
@@ -3,10 +3,19 @@ package main
 import (
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/heartbeat"
+	"github.com/Frhnmj2004/LabMonitoring-server/ingest"
+	"github.com/Frhnmj2004/LabMonitoring-server/retention"
+	"github.com/Frhnmj2004/LabMonitoring-server/rollup"
 	"github.com/Frhnmj2004/LabMonitoring-server/routes"
+	"github.com/Frhnmj2004/LabMonitoring-server/storage"
 	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -14,15 +23,42 @@ import (
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout bounds how long shutdown waits for open requests
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(".env"); err != nil {
 		log.Fatal("Error loading .env file", err)
 	}
 
+	// `server migrate ...` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	// Initialize database connection
 	config.InitDB()
 
+	// Samples that can't be written while the database is down are kept here
+	spool, err := storage.Open()
+	if err != nil {
+		log.Fatal("Error opening spool: ", err)
+	}
+
+	// Store collector samples in the background
+	ingest.Start(config.DB, spool)
+
+	// Watch for computers that stop reporting
+	heartbeat.Start(config.DB)
+
+	// Aggregate raw samples for long-range history charts
+	rollup.Start(config.DB)
+
+	// Delete data older than its retention policy
+	retention.Start(config.DB)
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
@@ -47,9 +83,26 @@ func main() {
 		port = "8080"
 	}
 
+	// On SIGINT/SIGTERM stop accepting requests, then flush queued samples
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		utils.LogInfo("Shutting down")
+		// Event streams and websockets never end on their own
+		websocket.Default.Close()
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			utils.LogError("Server shutdown failed: %v", err)
+		}
+	}()
+
 	utils.LogInfo("Server starting on port %s", port)
 	if err := app.Listen(":" + port); err != nil {
 		utils.LogError("Server failed to start: %v", err)
 		os.Exit(1)
 	}
+
+	ingest.Default.Close()
+	utils.LogInfo("Server stopped")
 }
@@ -3,46 +3,138 @@ package middleware
 import (
 	"strings"
 
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
 	"github.com/Frhnmj2004/LabMonitoring-server/utils"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Missing authorization header",
+		if status, msg := authenticate(c); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": msg,
 			})
 		}
+		return c.Next()
+	}
+}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid authorization format",
-			})
-		}
+// authenticate validates the bearer token and stores the user's claims and
+// scope in the context. On failure it returns the status and message to send.
+func authenticate(c *fiber.Ctx) (int, string) {
+	authHeader := c.Get("Authorization")
+	if authHeader == "" {
+		return fiber.StatusUnauthorized, "Missing authorization header"
+	}
 
-		claims, err := utils.ValidateToken(tokenParts[1])
+	tokenParts := strings.Split(authHeader, " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return fiber.StatusUnauthorized, "Invalid authorization format"
+	}
+
+	claims, scope, status, msg := AuthenticateToken(tokenParts[1])
+	if status != 0 {
+		return status, msg
+	}
+
+	// Add claims to context for use in handlers
+	c.Locals("claims", claims)
+	c.Locals("userID", claims.UserID)
+	c.Locals("username", claims.Username)
+	c.Locals("role", claims.Role)
+	c.Locals("scope", scope)
+
+	return 0, ""
+}
+
+// AuthenticateToken validates an access token, checks it has not been
+// revoked and loads the user's scope. On failure it returns the status and
+// message to send.
+func AuthenticateToken(token string) (*utils.JWTClaims, *Scope, int, string) {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil, nil, fiber.StatusUnauthorized, "Invalid token"
+	}
+
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, nil, fiber.StatusUnauthorized, "Invalid token"
+	}
+
+	// Reject tokens revoked by logout before they expire
+	revoked, err := models.IsTokenRevoked(config.DB, jti)
+	if err != nil {
+		utils.LogError("Failed to check token revocation: %v", err)
+		return nil, nil, fiber.StatusInternalServerError, "Internal server error"
+	}
+	if revoked {
+		return nil, nil, fiber.StatusUnauthorized, "Token has been revoked"
+	}
+
+	// Lab managers are limited to the labs assigned to them
+	scope := &Scope{UserID: claims.UserID, Role: claims.Role}
+	if claims.Role == models.RoleLabManager {
+		labIDs, err := models.GetUserLabIDs(config.DB, claims.UserID)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token",
-			})
+			utils.LogError("Failed to load lab assignments: %v", err)
+			return nil, nil, fiber.StatusInternalServerError, "Internal server error"
 		}
+		scope.LabIDs = labIDs
+	}
 
-		// Add claims to context for use in handlers
-		c.Locals("userID", claims.UserID)
-		c.Locals("username", claims.Username)
-		c.Locals("role", claims.Role)
+	return claims, scope, 0, ""
+}
 
+func AdminOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role := c.Locals("role")
+		if role != models.RoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Admin access required",
+			})
+		}
 		return c.Next()
 	}
 }
 
-func AdminOnly() fiber.Handler {
+// RequireRole allows the request only if the user has one of the given roles
+func RequireRole(roles ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		role := c.Locals("role")
-		if role != "admin" {
+		role, _ := c.Locals("role").(string)
+		for _, allowed := range roles {
+			if role == allowed {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions",
+		})
+	}
+}
+
+// AdminOrBootstrap lets the first user be created without authentication so
+// a fresh install can get its first admin; afterwards only admins pass
+func AdminOrBootstrap() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var users int64
+		if err := config.DB.Model(&models.User{}).Count(&users).Error; err != nil {
+			utils.LogError("Failed to count users: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		if users == 0 {
+			return c.Next()
+		}
+
+		if status, msg := authenticate(c); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		if c.Locals("role") != models.RoleAdmin {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Admin access required",
 			})
@@ -50,3 +142,9 @@ func AdminOnly() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// ClaimsFromContext returns the access token claims set by AuthMiddleware
+func ClaimsFromContext(c *fiber.Ctx) *utils.JWTClaims {
+	claims, _ := c.Locals("claims").(*utils.JWTClaims)
+	return claims
+}
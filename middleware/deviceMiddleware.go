@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeviceAuth authenticates collector submissions using the per-computer device token
+// issued at system signup. The authenticated computer is stored in c.Locals("device").
+func DeviceAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		authHeader := c.Get("Authorization")
+		if authHeader == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Missing device token",
+			})
+		}
+
+		tokenParts := strings.Split(authHeader, " ")
+		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" || tokenParts[1] == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid authorization format",
+			})
+		}
+
+		computer, err := models.GetComputerByDeviceTokenHash(config.DB, utils.HashDeviceToken(tokenParts[1]))
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or revoked device token",
+			})
+		}
+
+		c.Locals("device", computer)
+		return c.Next()
+	}
+}
+
+// DeviceOrAdminAuth accepts either a collector's device token or an admin's
+// JWT, for endpoints that relay or import data for many computers. Only
+// DeviceFromContext is set for device tokens.
+func DeviceOrAdminAuth() fiber.Handler {
+	device := DeviceAuth()
+	return func(c *fiber.Ctx) error {
+		// JWTs are three dot-separated segments; device tokens contain no dots
+		if strings.Count(c.Get("Authorization"), ".") != 2 {
+			return device(c)
+		}
+
+		if status, msg := authenticate(c); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		if c.Locals("role") != models.RoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Admin access required",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// DeviceOrUserAuth accepts either a collector's device token or any signed-in
+// user's JWT, for endpoints read by both collectors and dashboards. Users get
+// their usual scope.
+func DeviceOrUserAuth() fiber.Handler {
+	device := DeviceAuth()
+	return func(c *fiber.Ctx) error {
+		if strings.Count(c.Get("Authorization"), ".") != 2 {
+			return device(c)
+		}
+
+		if status, msg := authenticate(c); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// DeviceFromContext returns the computer authenticated by DeviceAuth, if any
+func DeviceFromContext(c *fiber.Ctx) *models.Computer {
+	computer, _ := c.Locals("device").(*models.Computer)
+	return computer
+}
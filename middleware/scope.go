@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scope describes what the authenticated user may see and do
+type Scope struct {
+	UserID uuid.UUID
+	Role   string
+	LabIDs []uuid.UUID // labs assigned to a lab manager
+}
+
+// Restricted reports whether the user is limited to their assigned labs
+func (s *Scope) Restricted() bool {
+	return s.Role != models.RoleAdmin && s.Role != models.RoleViewer
+}
+
+// AllowsLab reports whether the user may see the given lab
+func (s *Scope) AllowsLab(labID uuid.UUID) bool {
+	if !s.Restricted() {
+		return true
+	}
+	for _, id := range s.LabIDs {
+		if id == labID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsComputer reports whether the user may see the computer with the given system ID
+func (s *Scope) AllowsComputer(db *gorm.DB, computerID string) bool {
+	if !s.Restricted() {
+		return true
+	}
+	var computer models.Computer
+	if err := db.Select("lab_id").Where("computer_id = ?", computerID).First(&computer).Error; err != nil {
+		return false
+	}
+	return s.AllowsLab(computer.LabID)
+}
+
+// FilterComputers limits a query to rows whose computer column belongs to an
+// allowed lab, e.g. scope.FilterComputers(query, "alerts.computer_id")
+func (s *Scope) FilterComputers(query *gorm.DB, column string) *gorm.DB {
+	if !s.Restricted() {
+		return query
+	}
+	if len(s.LabIDs) == 0 {
+		return query.Where("1 = 0")
+	}
+	return query.Where(column+" IN (SELECT computer_id FROM computers WHERE lab_id IN ?)", s.LabIDs)
+}
+
+// FilterLabs limits a query on labs (or computers) to allowed labs by the given column
+func (s *Scope) FilterLabs(query *gorm.DB, column string) *gorm.DB {
+	if !s.Restricted() {
+		return query
+	}
+	if len(s.LabIDs) == 0 {
+		return query.Where("1 = 0")
+	}
+	return query.Where(column+" IN ?", s.LabIDs)
+}
+
+// ScopeFromContext returns the scope set by AuthMiddleware. Requests that did
+// not pass through it get an empty, fully restricted scope.
+func ScopeFromContext(c *fiber.Ctx) *Scope {
+	if scope, ok := c.Locals("scope").(*Scope); ok {
+		return scope
+	}
+	return &Scope{}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/migrations"
+)
+
+// runMigrate implements `migrate up|down [steps]|status`
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate up|down [steps]|status")
+	}
+
+	config.Connect()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(config.DB)
+		if err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatal("Steps must be a positive integer")
+			}
+			steps = n
+		}
+		reverted, err := migrations.Down(config.DB, steps)
+		if err != nil {
+			log.Fatal("Rollback failed: ", err)
+		}
+		fmt.Printf("Reverted %d migration(s)\n", reverted)
+
+	case "status":
+		statuses, err := migrations.GetStatus(config.DB)
+		if err != nil {
+			log.Fatal("Failed to read migration status: ", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state += " (MODIFIED)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		log.Fatalf("Unknown migrate command %q", args[0])
+	}
+}
@@ -0,0 +1,181 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func sqlFS(files map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys["sql/"+name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return fsys
+}
+
+func checksum(up string) string {
+	sum := sha256.Sum256([]byte(up))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	migrations, err := load(sqlFS(map[string]string{
+		"0010_tenth.up.sql":    "CREATE TABLE tenth ();",
+		"0002_second.up.sql":   "CREATE TABLE second ();",
+		"0002_second.down.sql": "DROP TABLE second;",
+		"9_ninth.up.sql":       "CREATE TABLE ninth ();",
+		"0001_first.up.sql":    "CREATE TABLE first ();",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []int
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	want := []int{1, 2, 9, 10}
+	if len(versions) != len(want) {
+		t.Fatalf("versions = %v, want %v", versions, want)
+	}
+	for i := range want {
+		if versions[i] != want[i] {
+			t.Fatalf("versions = %v, want %v", versions, want)
+		}
+	}
+
+	second := migrations[1]
+	if second.Name != "second" || second.Up != "CREATE TABLE second ();" || second.Down != "DROP TABLE second;" {
+		t.Errorf("second = %+v", second)
+	}
+	if second.Checksum != checksum(second.Up) {
+		t.Errorf("checksum = %s, want the SHA-256 of the up script", second.Checksum)
+	}
+	if migrations[0].Down != "" {
+		t.Errorf("first has down script %q, want none", migrations[0].Down)
+	}
+}
+
+func TestLoadRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "no direction",
+			files: map[string]string{"0001_first.sql": ""},
+			want:  "must end in .up.sql or .down.sql",
+		},
+		{
+			name:  "no name",
+			files: map[string]string{"0001.up.sql": "SELECT 1;"},
+			want:  "must be named NNNN_name",
+		},
+		{
+			name:  "invalid version",
+			files: map[string]string{"first_table.up.sql": "SELECT 1;"},
+			want:  "invalid version",
+		},
+		{
+			name:  "zero version",
+			files: map[string]string{"0000_first.up.sql": "SELECT 1;"},
+			want:  "invalid version",
+		},
+		{
+			name: "conflicting names",
+			files: map[string]string{
+				"0001_first.up.sql": "SELECT 1;",
+				"0001_other.up.sql": "SELECT 2;",
+			},
+			want: "conflicting names",
+		},
+		{
+			name:  "down without up",
+			files: map[string]string{"0001_first.down.sql": "SELECT 1;"},
+			want:  "has no up script",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(sqlFS(tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "first", Checksum: checksum("CREATE TABLE first ();")},
+		{Version: 2, Name: "second", Checksum: checksum("CREATE TABLE second ();")},
+	}
+
+	tests := []struct {
+		name    string
+		done    map[int]SchemaMigration
+		wantErr string
+	}{
+		{
+			name: "nothing applied",
+			done: map[int]SchemaMigration{},
+		},
+		{
+			name: "applied unchanged",
+			done: map[int]SchemaMigration{
+				1: {Version: 1, Checksum: migrations[0].Checksum},
+				2: {Version: 2, Checksum: migrations[1].Checksum},
+			},
+		},
+		{
+			name: "applied and edited since",
+			done: map[int]SchemaMigration{
+				1: {Version: 1, Checksum: migrations[0].Checksum},
+				2: {Version: 2, Checksum: checksum("CREATE TABLE second (id int);")},
+			},
+			wantErr: "0002_second has been modified since it was applied",
+		},
+		{
+			name: "applied by a newer release",
+			done: map[int]SchemaMigration{
+				1: {Version: 1, Checksum: migrations[0].Checksum},
+				3: {Version: 3, Checksum: checksum("CREATE TABLE third ();")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(migrations, tt.done)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("verify = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verify = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrationsAreNumberedInSequence(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d has version %04d, want %04d", i, m.Version, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS internet_usages;
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS resource_logs;
+DROP TABLE IF EXISTS computers;
+DROP TABLE IF EXISTS users;
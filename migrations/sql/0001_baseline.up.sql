@@ -0,0 +1,51 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+    id            uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    username      text NOT NULL,
+    password_hash text NOT NULL,
+    role          varchar(10) NOT NULL,
+    CONSTRAINT chk_users_role CHECK (role IN ('admin', 'user'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS computers (
+    id          uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    computer_id text NOT NULL,
+    college     text NOT NULL,
+    lab_name    text NOT NULL,
+    last_seen   timestamptz,
+    created_at  timestamptz,
+    updated_at  timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_computers_computer_id ON computers (computer_id);
+
+CREATE TABLE IF NOT EXISTS resource_logs (
+    id          uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    computer_id text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    timestamp   timestamptz NOT NULL,
+    cpu         double precision NOT NULL CHECK (cpu >= 0 AND cpu <= 100),
+    memory      double precision NOT NULL CHECK (memory >= 0 AND memory <= 100),
+    network_in  double precision NOT NULL CHECK (network_in >= 0),
+    network_out double precision NOT NULL CHECK (network_out >= 0)
+);
+CREATE INDEX IF NOT EXISTS idx_resource_logs_timestamp ON resource_logs (timestamp);
+CREATE INDEX IF NOT EXISTS idx_resource_logs_computer_timestamp ON resource_logs (computer_id, timestamp DESC);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id          uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    computer_id text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    type        varchar(20) NOT NULL,
+    message     text NOT NULL,
+    timestamp   timestamptz NOT NULL,
+    resolved    boolean NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_timestamp ON alerts (timestamp);
+
+CREATE TABLE IF NOT EXISTS internet_usages (
+    id          uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    computer_id text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    domain      varchar(255) NOT NULL,
+    timestamp   timestamptz NOT NULL,
+    created_at  timestamptz
+);
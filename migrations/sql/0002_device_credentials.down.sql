@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_computers_device_token_hash;
+
+ALTER TABLE computers
+    DROP COLUMN IF EXISTS token_revoked_at,
+    DROP COLUMN IF EXISTS token_issued_at,
+    DROP COLUMN IF EXISTS device_token_hash;
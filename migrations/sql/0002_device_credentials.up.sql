@@ -0,0 +1,7 @@
+ALTER TABLE computers
+    ADD COLUMN IF NOT EXISTS device_token_hash text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS token_issued_at timestamptz,
+    ADD COLUMN IF NOT EXISTS token_revoked_at timestamptz;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_computers_device_token_hash
+    ON computers (device_token_hash) WHERE device_token_hash <> '';
@@ -0,0 +1,5 @@
+ALTER TABLE alerts
+    DROP COLUMN IF EXISTS severity,
+    DROP COLUMN IF EXISTS rule_id;
+
+DROP TABLE IF EXISTS alert_rules;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id                uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name              text NOT NULL,
+    metric            varchar(20) NOT NULL,
+    operator          varchar(3) NOT NULL,
+    threshold         double precision NOT NULL,
+    alert_type        varchar(20) NOT NULL,
+    severity          varchar(10) NOT NULL DEFAULT 'warning',
+    college           text NOT NULL DEFAULT '',
+    lab_name          text NOT NULL DEFAULT '',
+    computer_id       text NOT NULL DEFAULT '',
+    sustained_seconds integer NOT NULL DEFAULT 0 CHECK (sustained_seconds >= 0),
+    sustained_samples integer NOT NULL DEFAULT 1 CHECK (sustained_samples >= 1),
+    enabled           boolean NOT NULL DEFAULT true,
+    created_at        timestamptz,
+    updated_at        timestamptz,
+    CONSTRAINT chk_alert_rules_metric CHECK (metric IN ('cpu', 'memory', 'network_in', 'network_out', 'offline')),
+    CONSTRAINT chk_alert_rules_operator CHECK (operator IN ('gt', 'gte', 'lt', 'lte')),
+    CONSTRAINT chk_alert_rules_severity CHECK (severity IN ('info', 'warning', 'critical'))
+);
+
+ALTER TABLE alerts
+    ADD COLUMN IF NOT EXISTS rule_id uuid REFERENCES alert_rules (id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS severity varchar(10) NOT NULL DEFAULT 'warning';
+
+-- Preserve the previous hard-coded behaviour as editable default rules
+INSERT INTO alert_rules (name, metric, operator, threshold, alert_type, severity, created_at, updated_at)
+VALUES
+    ('High CPU usage', 'cpu', 'gt', 90, 'HIGH_CPU', 'warning', now(), now()),
+    ('High memory usage', 'memory', 'gt', 90, 'HIGH_MEMORY', 'warning', now(), now());
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS idx_alerts_status;
+DROP INDEX IF EXISTS idx_alerts_open_fingerprint;
+
+ALTER TABLE alerts
+    DROP CONSTRAINT IF EXISTS chk_alerts_status,
+    DROP COLUMN IF EXISTS resolved_by,
+    DROP COLUMN IF EXISTS resolved_at,
+    DROP COLUMN IF EXISTS acknowledged_by,
+    DROP COLUMN IF EXISTS acknowledged_at,
+    DROP COLUMN IF EXISTS last_seen,
+    DROP COLUMN IF EXISTS first_seen,
+    DROP COLUMN IF EXISTS occurrence_count,
+    DROP COLUMN IF EXISTS fingerprint,
+    DROP COLUMN IF EXISTS status;
+
+ALTER TABLE alert_rules DROP COLUMN IF EXISTS hysteresis;
@@ -0,0 +1,35 @@
+ALTER TABLE alert_rules
+    ADD COLUMN IF NOT EXISTS hysteresis double precision NOT NULL DEFAULT 0 CHECK (hysteresis >= 0);
+
+ALTER TABLE alerts
+    ADD COLUMN IF NOT EXISTS status varchar(12) NOT NULL DEFAULT 'firing',
+    ADD COLUMN IF NOT EXISTS fingerprint text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS occurrence_count integer NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS first_seen timestamptz,
+    ADD COLUMN IF NOT EXISTS last_seen timestamptz,
+    ADD COLUMN IF NOT EXISTS acknowledged_at timestamptz,
+    ADD COLUMN IF NOT EXISTS acknowledged_by text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS resolved_at timestamptz,
+    ADD COLUMN IF NOT EXISTS resolved_by text NOT NULL DEFAULT '';
+
+UPDATE alerts SET
+    status = CASE WHEN resolved THEN 'resolved' ELSE 'firing' END,
+    fingerprint = COALESCE(rule_id::text, type) || ':' || computer_id,
+    first_seen = timestamp,
+    last_seen = timestamp,
+    resolved_at = CASE WHEN resolved THEN timestamp END;
+
+-- Collapse duplicate open alerts left by per-sample alerting, keeping the newest
+UPDATE alerts a SET status = 'resolved', resolved = true, resolved_at = now(), resolved_by = 'migration'
+WHERE a.status <> 'resolved' AND EXISTS (
+    SELECT 1 FROM alerts b
+    WHERE b.fingerprint = a.fingerprint AND b.status <> 'resolved'
+      AND (b.timestamp, b.id) > (a.timestamp, a.id)
+);
+
+ALTER TABLE alerts
+    ADD CONSTRAINT chk_alerts_status CHECK (status IN ('firing', 'acknowledged', 'resolved'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_open_fingerprint
+    ON alerts (fingerprint) WHERE status <> 'resolved';
+CREATE INDEX IF NOT EXISTS idx_alerts_status ON alerts (status);
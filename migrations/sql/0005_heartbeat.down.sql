@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS lab_settings;
+DROP TABLE IF EXISTS computer_status_history;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS computer_status_history (
+    id          uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    computer_id text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    status      varchar(8) NOT NULL CHECK (status IN ('online', 'offline')),
+    started_at  timestamptz NOT NULL,
+    ended_at    timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_computer_status_history_computer_started
+    ON computer_status_history (computer_id, started_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_computer_status_history_open
+    ON computer_status_history (computer_id) WHERE ended_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS lab_settings (
+    college                   text NOT NULL,
+    lab_name                  text NOT NULL,
+    offline_threshold_seconds integer NOT NULL CHECK (offline_threshold_seconds > 0),
+    updated_at                timestamptz,
+    PRIMARY KEY (college, lab_name)
+);
@@ -0,0 +1,45 @@
+ALTER TABLE alert_rules
+    ADD COLUMN IF NOT EXISTS college text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS lab_name text NOT NULL DEFAULT '';
+
+UPDATE alert_rules r SET college = co.name
+FROM colleges co WHERE co.id = r.college_id;
+
+UPDATE alert_rules r SET lab_name = l.name, college = co.name
+FROM labs l JOIN colleges co ON co.id = l.college_id
+WHERE l.id = r.lab_id;
+
+ALTER TABLE alert_rules
+    DROP COLUMN lab_id,
+    DROP COLUMN college_id;
+
+CREATE TABLE IF NOT EXISTS lab_settings (
+    college                   text NOT NULL,
+    lab_name                  text NOT NULL,
+    offline_threshold_seconds integer NOT NULL CHECK (offline_threshold_seconds > 0),
+    updated_at                timestamptz,
+    PRIMARY KEY (college, lab_name)
+);
+
+INSERT INTO lab_settings (college, lab_name, offline_threshold_seconds, updated_at)
+SELECT co.name, l.name, l.offline_threshold_seconds, now()
+FROM labs l JOIN colleges co ON co.id = l.college_id
+WHERE l.offline_threshold_seconds IS NOT NULL;
+
+ALTER TABLE computers
+    ADD COLUMN IF NOT EXISTS college text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS lab_name text NOT NULL DEFAULT '';
+
+UPDATE computers c SET college = co.name, lab_name = l.name
+FROM labs l JOIN colleges co ON co.id = l.college_id
+WHERE l.id = c.lab_id;
+
+ALTER TABLE computers
+    ALTER COLUMN college DROP DEFAULT,
+    ALTER COLUMN lab_name DROP DEFAULT;
+
+DROP INDEX IF EXISTS idx_computers_lab_id;
+ALTER TABLE computers DROP COLUMN lab_id;
+
+DROP TABLE IF EXISTS labs;
+DROP TABLE IF EXISTS colleges;
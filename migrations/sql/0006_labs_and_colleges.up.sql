@@ -0,0 +1,87 @@
+CREATE TABLE IF NOT EXISTS colleges (
+    id         uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name       text NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_colleges_name ON colleges (lower(name));
+
+CREATE TABLE IF NOT EXISTS labs (
+    id                        uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    college_id                uuid NOT NULL REFERENCES colleges (id) ON DELETE RESTRICT,
+    name                      text NOT NULL,
+    room                      text NOT NULL DEFAULT '',
+    capacity                  integer NOT NULL DEFAULT 0 CHECK (capacity >= 0),
+    responsible_staff         text NOT NULL DEFAULT '',
+    offline_threshold_seconds integer CHECK (offline_threshold_seconds > 0),
+    created_at                timestamptz,
+    updated_at                timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_labs_college_name ON labs (college_id, lower(name));
+
+-- Normalise the free-text strings: trim, collapse whitespace, and treat
+-- names that differ only in case as the same college or lab
+CREATE OR REPLACE FUNCTION pg_temp.normalize_name(value text) RETURNS text AS $$
+    SELECT btrim(regexp_replace(value, '\s+', ' ', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
+
+INSERT INTO colleges (name, created_at, updated_at)
+SELECT min(pg_temp.normalize_name(college)), now(), now()
+FROM computers
+GROUP BY lower(pg_temp.normalize_name(college))
+ON CONFLICT DO NOTHING;
+
+INSERT INTO labs (college_id, name, created_at, updated_at)
+SELECT co.id, min(pg_temp.normalize_name(c.lab_name)), now(), now()
+FROM computers c
+JOIN colleges co ON lower(co.name) = lower(pg_temp.normalize_name(c.college))
+GROUP BY co.id, lower(pg_temp.normalize_name(c.lab_name))
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE computers ADD COLUMN IF NOT EXISTS lab_id uuid REFERENCES labs (id) ON DELETE RESTRICT;
+
+UPDATE computers c SET lab_id = l.id
+FROM labs l
+JOIN colleges co ON co.id = l.college_id
+WHERE lower(co.name) = lower(pg_temp.normalize_name(c.college))
+  AND lower(l.name) = lower(pg_temp.normalize_name(c.lab_name));
+
+ALTER TABLE computers
+    ALTER COLUMN lab_id SET NOT NULL,
+    DROP COLUMN college,
+    DROP COLUMN lab_name;
+CREATE INDEX IF NOT EXISTS idx_computers_lab_id ON computers (lab_id);
+
+-- Per-lab offline thresholds move onto the lab itself
+UPDATE labs l SET offline_threshold_seconds = s.offline_threshold_seconds
+FROM lab_settings s
+JOIN colleges co ON lower(co.name) = lower(pg_temp.normalize_name(s.college))
+WHERE l.college_id = co.id
+  AND lower(l.name) = lower(pg_temp.normalize_name(s.lab_name));
+
+DROP TABLE lab_settings;
+
+-- Alert rule scopes reference colleges and labs by ID
+ALTER TABLE alert_rules
+    ADD COLUMN IF NOT EXISTS college_id uuid REFERENCES colleges (id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS lab_id uuid REFERENCES labs (id) ON DELETE CASCADE;
+
+UPDATE alert_rules r SET college_id = co.id
+FROM colleges co
+WHERE r.college <> '' AND lower(co.name) = lower(pg_temp.normalize_name(r.college));
+
+UPDATE alert_rules r SET lab_id = l.id
+FROM labs l
+JOIN colleges co ON co.id = l.college_id
+WHERE r.lab_name <> ''
+  AND lower(l.name) = lower(pg_temp.normalize_name(r.lab_name))
+  AND (r.college = '' OR lower(co.name) = lower(pg_temp.normalize_name(r.college)));
+
+-- A scope that names no existing college or lab must not silently widen to everything
+UPDATE alert_rules SET enabled = false
+WHERE (college <> '' AND college_id IS NULL)
+   OR (lab_name <> '' AND lab_id IS NULL);
+
+ALTER TABLE alert_rules
+    DROP COLUMN college,
+    DROP COLUMN lab_name;
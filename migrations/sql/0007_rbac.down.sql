@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS user_labs;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_role;
+
+UPDATE users SET role = 'user' WHERE role IN ('lab_manager', 'viewer');
+
+ALTER TABLE users ALTER COLUMN role TYPE varchar(10);
+ALTER TABLE users
+    ADD CONSTRAINT chk_users_role CHECK (role IN ('admin', 'user'));
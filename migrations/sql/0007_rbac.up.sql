@@ -0,0 +1,14 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_role;
+ALTER TABLE users ALTER COLUMN role TYPE varchar(20);
+
+UPDATE users SET role = 'viewer' WHERE role = 'user';
+
+ALTER TABLE users
+    ADD CONSTRAINT chk_users_role CHECK (role IN ('admin', 'lab_manager', 'viewer'));
+
+CREATE TABLE IF NOT EXISTS user_labs (
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    lab_id  uuid NOT NULL REFERENCES labs (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, lab_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_labs_lab_id ON user_labs (lab_id);
@@ -0,0 +1,28 @@
+-- Refresh tokens rotate on every use; all tokens of one login share a session_id
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id                uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    session_id        uuid NOT NULL,
+    user_id           uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash        text NOT NULL,
+    device            text NOT NULL DEFAULT '',
+    user_agent        text NOT NULL DEFAULT '',
+    ip_address        text NOT NULL DEFAULT '',
+    access_jti        uuid NOT NULL,
+    access_expires_at timestamptz NOT NULL,
+    created_at        timestamptz,
+    expires_at        timestamptz NOT NULL,
+    revoked_at        timestamptz,
+    replaced_by       uuid
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- Access tokens revoked before they expire, keyed by JWT ID
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        uuid PRIMARY KEY,
+    user_id    uuid NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
@@ -0,0 +1,31 @@
+-- Aggregated samples per computer at 1-minute, 1-hour and 1-day resolution
+CREATE TABLE IF NOT EXISTS resource_rollups (
+    resolution      varchar(4) NOT NULL CHECK (resolution IN ('1m', '1h', '1d')),
+    computer_id     text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    bucket          timestamptz NOT NULL,
+    samples         integer NOT NULL,
+    cpu_min         double precision NOT NULL,
+    cpu_avg         double precision NOT NULL,
+    cpu_max         double precision NOT NULL,
+    cpu_p95         double precision NOT NULL,
+    memory_min      double precision NOT NULL,
+    memory_avg      double precision NOT NULL,
+    memory_max      double precision NOT NULL,
+    memory_p95      double precision NOT NULL,
+    network_in_min  double precision NOT NULL,
+    network_in_avg  double precision NOT NULL,
+    network_in_max  double precision NOT NULL,
+    network_in_p95  double precision NOT NULL,
+    network_out_min double precision NOT NULL,
+    network_out_avg double precision NOT NULL,
+    network_out_max double precision NOT NULL,
+    network_out_p95 double precision NOT NULL,
+    PRIMARY KEY (resolution, computer_id, bucket)
+);
+CREATE INDEX IF NOT EXISTS idx_resource_rollups_bucket ON resource_rollups (resolution, bucket);
+
+-- How far each resolution has been aggregated
+CREATE TABLE IF NOT EXISTS rollup_state (
+    resolution        varchar(4) PRIMARY KEY,
+    completed_through timestamptz NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_computer_status_history_ended_at;
+DROP INDEX IF EXISTS idx_alerts_resolved_at;
+DROP INDEX IF EXISTS idx_internet_usages_timestamp;
+DROP TABLE IF EXISTS retention_policies;
@@ -0,0 +1,25 @@
+-- How long each kind of data is kept; retention_days = 0 keeps data forever
+CREATE TABLE IF NOT EXISTS retention_policies (
+    target         varchar(40) PRIMARY KEY,
+    retention_days integer NOT NULL CHECK (retention_days >= 0),
+    enabled        boolean NOT NULL DEFAULT true,
+    updated_at     timestamptz,
+    updated_by     text NOT NULL DEFAULT '',
+    last_run_at    timestamptz,
+    last_deleted   bigint NOT NULL DEFAULT 0
+);
+
+INSERT INTO retention_policies (target, retention_days, updated_at, updated_by) VALUES
+    ('resource_logs', 7, now(), 'migration'),
+    ('resource_rollups_1m', 30, now(), 'migration'),
+    ('resource_rollups_1h', 365, now(), 'migration'),
+    ('resource_rollups_1d', 365, now(), 'migration'),
+    ('internet_usages', 30, now(), 'migration'),
+    ('alerts', 180, now(), 'migration'),
+    ('computer_status_history', 365, now(), 'migration')
+ON CONFLICT (target) DO NOTHING;
+
+-- Pruning scans by age
+CREATE INDEX IF NOT EXISTS idx_internet_usages_timestamp ON internet_usages (timestamp);
+CREATE INDEX IF NOT EXISTS idx_alerts_resolved_at ON alerts (resolved_at) WHERE status = 'resolved';
+CREATE INDEX IF NOT EXISTS idx_computer_status_history_ended_at ON computer_status_history (ended_at);
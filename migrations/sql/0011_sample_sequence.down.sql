@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_resource_logs_computer_seq;
+ALTER TABLE resource_logs DROP COLUMN IF EXISTS seq;
+ALTER TABLE resource_logs DROP COLUMN IF EXISTS received_at;
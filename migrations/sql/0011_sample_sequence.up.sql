@@ -0,0 +1,12 @@
+-- Samples carry the collector's capture time (timestamp) and the time the
+-- server received them; existing rows were stamped on arrival
+ALTER TABLE resource_logs ADD COLUMN IF NOT EXISTS received_at timestamptz;
+UPDATE resource_logs SET received_at = timestamp WHERE received_at IS NULL;
+ALTER TABLE resource_logs
+    ALTER COLUMN received_at SET DEFAULT now(),
+    ALTER COLUMN received_at SET NOT NULL;
+
+-- Per-computer sequence number from the collector, used to drop resent samples
+ALTER TABLE resource_logs ADD COLUMN IF NOT EXISTS seq bigint CHECK (seq >= 0);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_resource_logs_computer_seq
+    ON resource_logs (computer_id, seq) WHERE seq IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Settings each collector pulls from the server; computers without a row use
+-- the defaults
+CREATE TABLE IF NOT EXISTS collector_configs (
+    computer_id             text PRIMARY KEY REFERENCES computers (computer_id) ON DELETE CASCADE,
+    sample_interval_seconds integer NOT NULL DEFAULT 10 CHECK (sample_interval_seconds BETWEEN 1 AND 3600),
+    poll_interval_seconds   integer NOT NULL DEFAULT 60 CHECK (poll_interval_seconds BETWEEN 10 AND 86400),
+    dns_capture             boolean NOT NULL DEFAULT true,
+    paused                  boolean NOT NULL DEFAULT false,
+    updated_at              timestamptz,
+    updated_by              text NOT NULL DEFAULT ''
+);
@@ -0,0 +1,2 @@
+ALTER TABLE resource_logs DROP COLUMN IF EXISTS users;
+ALTER TABLE resource_logs DROP COLUMN IF EXISTS metrics;
@@ -0,0 +1,6 @@
+-- Extra host metrics keyed by name (e.g. "cpu.core.0", "disk./.used_percent"),
+-- so collectors can add metrics without a schema change
+ALTER TABLE resource_logs ADD COLUMN IF NOT EXISTS metrics jsonb NOT NULL DEFAULT '{}';
+
+-- Users logged in when the sample was taken
+ALTER TABLE resource_logs ADD COLUMN IF NOT EXISTS users jsonb NOT NULL DEFAULT '[]';
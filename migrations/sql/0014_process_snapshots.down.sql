@@ -0,0 +1,3 @@
+DELETE FROM retention_policies WHERE target = 'process_snapshots';
+ALTER TABLE alerts DROP COLUMN IF EXISTS process_snapshot_id;
+DROP TABLE IF EXISTS process_snapshots;
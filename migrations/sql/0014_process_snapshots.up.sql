@@ -0,0 +1,18 @@
+-- Top processes reported with a resource sample
+CREATE TABLE IF NOT EXISTS process_snapshots (
+    resource_log_id uuid PRIMARY KEY REFERENCES resource_logs (id) ON DELETE CASCADE,
+    computer_id     text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    taken_at        timestamptz NOT NULL,
+    processes       jsonb NOT NULL DEFAULT '[]'
+);
+CREATE INDEX IF NOT EXISTS idx_process_snapshots_computer_taken
+    ON process_snapshots (computer_id, taken_at DESC);
+CREATE INDEX IF NOT EXISTS idx_process_snapshots_taken ON process_snapshots (taken_at);
+
+-- The processes that were running when an alert fired
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS process_snapshot_id uuid
+    REFERENCES process_snapshots (resource_log_id) ON DELETE SET NULL;
+
+INSERT INTO retention_policies (target, retention_days, updated_at, updated_by) VALUES
+    ('process_snapshots', 7, now(), 'migration')
+ON CONFLICT (target) DO NOTHING;
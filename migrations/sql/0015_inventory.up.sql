@@ -0,0 +1,24 @@
+-- Hardware and software inventory reported by collectors. A new version is
+-- stored only when the inventory changes; changes holds the diff against the
+-- previous version.
+CREATE TABLE IF NOT EXISTS inventory_snapshots (
+    id               uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    computer_id      text NOT NULL REFERENCES computers (computer_id) ON DELETE CASCADE,
+    version          integer NOT NULL,
+    content_hash     text NOT NULL,
+    hostname         text NOT NULL DEFAULT '',
+    os               text NOT NULL DEFAULT '',
+    os_version       text NOT NULL DEFAULT '',
+    kernel_version   text NOT NULL DEFAULT '',
+    cpu_model        text NOT NULL DEFAULT '',
+    cpu_cores        integer NOT NULL DEFAULT 0,
+    memory_bytes     bigint NOT NULL DEFAULT 0,
+    disks            jsonb NOT NULL DEFAULT '[]',
+    interfaces       jsonb NOT NULL DEFAULT '[]',
+    software         jsonb NOT NULL DEFAULT '[]',
+    changes          jsonb NOT NULL DEFAULT '[]',
+    collected_at     timestamptz NOT NULL,
+    received_at      timestamptz NOT NULL DEFAULT now(),
+    last_reported_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (computer_id, version)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_computers_lab_active;
+ALTER TABLE computers DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE computers DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE computers DROP COLUMN IF EXISTS status;
+ALTER TABLE computers DROP COLUMN IF EXISTS name;
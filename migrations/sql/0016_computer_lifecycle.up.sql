@@ -0,0 +1,8 @@
+-- Lifecycle of a computer: a display name, whether it is in service, under
+-- maintenance (alerts suppressed) or retired, and when it was archived
+ALTER TABLE computers ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+ALTER TABLE computers ADD COLUMN IF NOT EXISTS status varchar(12) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'maintenance', 'retired'));
+ALTER TABLE computers ADD COLUMN IF NOT EXISTS status_changed_at timestamptz;
+ALTER TABLE computers ADD COLUMN IF NOT EXISTS archived_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_computers_lab_active ON computers (lab_id) WHERE archived_at IS NULL;
@@ -0,0 +1,6 @@
+UPDATE collector_configs SET sample_interval_seconds = 10 WHERE sample_interval_seconds IS NULL;
+UPDATE collector_configs SET poll_interval_seconds = 60 WHERE poll_interval_seconds IS NULL;
+ALTER TABLE collector_configs ALTER COLUMN sample_interval_seconds SET DEFAULT 10;
+ALTER TABLE collector_configs ALTER COLUMN sample_interval_seconds SET NOT NULL;
+ALTER TABLE collector_configs ALTER COLUMN poll_interval_seconds SET DEFAULT 60;
+ALTER TABLE collector_configs ALTER COLUMN poll_interval_seconds SET NOT NULL;
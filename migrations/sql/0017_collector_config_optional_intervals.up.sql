@@ -0,0 +1,6 @@
+-- A NULL interval leaves the collector on its own collector.json value, so
+-- only intervals an admin actually set override the machine's config
+ALTER TABLE collector_configs ALTER COLUMN sample_interval_seconds DROP NOT NULL;
+ALTER TABLE collector_configs ALTER COLUMN sample_interval_seconds DROP DEFAULT;
+ALTER TABLE collector_configs ALTER COLUMN poll_interval_seconds DROP NOT NULL;
+ALTER TABLE collector_configs ALTER COLUMN poll_interval_seconds DROP DEFAULT;
@@ -7,14 +7,37 @@ import (
 	"gorm.io/gorm"
 )
 
+// Alert lifecycle states
+const (
+	AlertStatusFiring       = "firing"
+	AlertStatusAcknowledged = "acknowledged"
+	AlertStatusResolved     = "resolved"
+)
+
 type Alert struct {
-	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	ComputerID string    `gorm:"not null" json:"computer_id"`
-	Type       string    `gorm:"type:varchar(20);not null" json:"type"`
-	Message    string    `gorm:"type:text;not null" json:"message"`
-	Timestamp  time.Time `gorm:"not null;index" json:"timestamp"`
-	Resolved   bool      `gorm:"not null;default:false" json:"resolved"`
-	Computer   Computer  `gorm:"foreignKey:ComputerID" json:"computer,omitempty"`
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	ComputerID      string     `gorm:"not null" json:"computer_id"`
+	Type            string     `gorm:"type:varchar(20);not null" json:"type"`
+	Message         string     `gorm:"type:text;not null" json:"message"`
+	Timestamp       time.Time  `gorm:"not null;index" json:"timestamp"`
+	Resolved        bool       `gorm:"not null;default:false" json:"resolved"`
+	Severity        string     `gorm:"type:varchar(10);not null;default:warning" json:"severity"`
+	RuleID          *uuid.UUID `gorm:"type:uuid" json:"rule_id,omitempty"`
+	Status          string     `gorm:"type:varchar(12);not null;default:firing" json:"status"`
+	Fingerprint     string     `gorm:"not null;default:''" json:"fingerprint"`
+	OccurrenceCount int        `gorm:"not null;default:1" json:"occurrence_count"`
+	FirstSeen       time.Time  `json:"first_seen"`
+	LastSeen        time.Time  `json:"last_seen"`
+	AcknowledgedAt  *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy  string     `gorm:"not null;default:''" json:"acknowledged_by,omitempty"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy      string     `gorm:"not null;default:''" json:"resolved_by,omitempty"`
+	Computer        Computer   `gorm:"foreignKey:ComputerID;references:ComputerID" json:"computer,omitempty"`
+
+	// Processes running when the alert fired; Processes holds the likely
+	// culprits and is only filled in for the websocket broadcast
+	ProcessSnapshotID *uuid.UUID  `gorm:"type:uuid" json:"process_snapshot_id,omitempty"`
+	Processes         ProcessList `gorm:"-" json:"processes,omitempty"`
 }
 
 func (a *Alert) BeforeCreate(tx *gorm.DB) error {
@@ -24,5 +47,89 @@ func (a *Alert) BeforeCreate(tx *gorm.DB) error {
 	if a.Timestamp.IsZero() {
 		a.Timestamp = time.Now()
 	}
+	if a.Status == "" {
+		a.Status = AlertStatusFiring
+	}
+	if a.FirstSeen.IsZero() {
+		a.FirstSeen = a.Timestamp
+	}
+	if a.LastSeen.IsZero() {
+		a.LastSeen = a.Timestamp
+	}
+	if a.OccurrenceCount == 0 {
+		a.OccurrenceCount = 1
+	}
 	return nil
 }
+
+// AlertFingerprint identifies repeated breaches of the same condition on one computer.
+// source is the rule ID for rule-based alerts or a fixed name for built-in alerts.
+func AlertFingerprint(source, computerID string) string {
+	return source + ":" + computerID
+}
+
+// IsOpen reports whether the alert has not been resolved yet
+func (a *Alert) IsOpen() bool {
+	return a.Status != AlertStatusResolved
+}
+
+// RecordOccurrence bumps an open alert for another breach of the same condition
+func (a *Alert) RecordOccurrence(db *gorm.DB, message string, at time.Time) error {
+	a.OccurrenceCount++
+	a.LastSeen = at
+	a.Message = message
+	return db.Model(a).Updates(map[string]interface{}{
+		"occurrence_count": gorm.Expr("occurrence_count + 1"),
+		"last_seen":        a.LastSeen,
+		"message":          a.Message,
+	}).Error
+}
+
+// Acknowledge marks a firing alert as seen by an operator
+func (a *Alert) Acknowledge(db *gorm.DB, by string) error {
+	now := time.Now()
+	a.Status = AlertStatusAcknowledged
+	a.AcknowledgedAt = &now
+	a.AcknowledgedBy = by
+	return db.Model(a).Updates(map[string]interface{}{
+		"status":          a.Status,
+		"acknowledged_at": a.AcknowledgedAt,
+		"acknowledged_by": a.AcknowledgedBy,
+	}).Error
+}
+
+// Resolve closes the alert; by is the resolving user, or "auto" for automatic resolution
+func (a *Alert) Resolve(db *gorm.DB, by string) error {
+	now := time.Now()
+	a.Status = AlertStatusResolved
+	a.Resolved = true
+	a.ResolvedAt = &now
+	a.ResolvedBy = by
+	return db.Model(a).Updates(map[string]interface{}{
+		"status":      a.Status,
+		"resolved":    true,
+		"resolved_at": a.ResolvedAt,
+		"resolved_by": a.ResolvedBy,
+	}).Error
+}
+
+// GetOpenAlertByFingerprint finds the unresolved alert for a fingerprint
+func GetOpenAlertByFingerprint(db *gorm.DB, fingerprint string) (*Alert, error) {
+	var alert Alert
+	err := db.Where("fingerprint = ? AND status <> ?", fingerprint, AlertStatusResolved).First(&alert).Error
+	return &alert, err
+}
+
+// GetOpenAlertFingerprints returns the fingerprints of all unresolved alerts
+func GetOpenAlertFingerprints(db *gorm.DB) ([]string, error) {
+	var fingerprints []string
+	err := db.Model(&Alert{}).Where("status <> ?", AlertStatusResolved).Pluck("fingerprint", &fingerprints).Error
+	return fingerprints, err
+}
+
+// GetOpenAlertsForComputer returns a computer's unresolved alerts
+func GetOpenAlertsForComputer(db *gorm.DB, computerID string) ([]Alert, error) {
+	var alerts []Alert
+	err := db.Where("computer_id = ? AND status <> ?", computerID, AlertStatusResolved).Find(&alerts).Error
+	return alerts, err
+}
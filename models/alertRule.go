@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Metrics an alert rule can watch
+const (
+	MetricCPU        = "cpu"
+	MetricMemory     = "memory"
+	MetricNetworkIn  = "network_in"
+	MetricNetworkOut = "network_out"
+	MetricOffline    = "offline" // seconds since the computer was last seen
+)
+
+// Comparison operators for alert rules
+const (
+	OperatorGT  = "gt"
+	OperatorGTE = "gte"
+	OperatorLT  = "lt"
+	OperatorLTE = "lte"
+)
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AlertRule describes when a metric should raise an alert. Empty scope fields match any computer.
+type AlertRule struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	Name             string     `gorm:"not null" json:"name"`
+	Metric           string     `gorm:"type:varchar(20);not null" json:"metric"`
+	Operator         string     `gorm:"type:varchar(3);not null" json:"operator"`
+	Threshold        float64    `gorm:"not null" json:"threshold"`
+	AlertType        string     `gorm:"type:varchar(20);not null" json:"alert_type"`
+	Severity         string     `gorm:"type:varchar(10);not null;default:warning" json:"severity"`
+	CollegeID        *uuid.UUID `gorm:"type:uuid" json:"college_id"`
+	LabID            *uuid.UUID `gorm:"type:uuid" json:"lab_id"`
+	ComputerID       string     `gorm:"not null;default:''" json:"computer_id"`
+	SustainedSeconds int        `gorm:"not null;default:0" json:"sustained_seconds"`
+	SustainedSamples int        `gorm:"not null;default:1" json:"sustained_samples"`
+	Hysteresis       float64    `gorm:"not null;default:0" json:"hysteresis"`
+	Enabled          bool       `gorm:"not null;default:true" json:"enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (r *AlertRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// Validate checks the rule's metric, operator, severity and window settings
+func (r *AlertRule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+
+	switch r.Metric {
+	case MetricCPU, MetricMemory, MetricNetworkIn, MetricNetworkOut, MetricOffline:
+	default:
+		return errors.New("metric must be one of cpu, memory, network_in, network_out, offline")
+	}
+
+	switch r.Operator {
+	case OperatorGT, OperatorGTE, OperatorLT, OperatorLTE:
+	default:
+		return errors.New("operator must be one of gt, gte, lt, lte")
+	}
+
+	switch r.Severity {
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return errors.New("severity must be one of info, warning, critical")
+	}
+
+	if r.AlertType == "" || len(r.AlertType) > 20 {
+		return errors.New("alert_type is required and must be at most 20 characters")
+	}
+
+	if (r.Metric == MetricCPU || r.Metric == MetricMemory) && (r.Threshold < 0 || r.Threshold > 100) {
+		return errors.New("threshold for cpu and memory must be between 0 and 100")
+	}
+
+	if r.SustainedSeconds < 0 {
+		return errors.New("sustained_seconds cannot be negative")
+	}
+
+	if r.SustainedSamples < 1 {
+		return errors.New("sustained_samples must be at least 1")
+	}
+
+	if r.Hysteresis < 0 {
+		return errors.New("hysteresis cannot be negative")
+	}
+
+	return nil
+}
+
+// Compare reports whether value breaches the rule's threshold
+func (r *AlertRule) Compare(value float64) bool {
+	switch r.Operator {
+	case OperatorGT:
+		return value > r.Threshold
+	case OperatorGTE:
+		return value >= r.Threshold
+	case OperatorLT:
+		return value < r.Threshold
+	case OperatorLTE:
+		return value <= r.Threshold
+	}
+	return false
+}
+
+// Clears reports whether value has recovered past the threshold by at least
+// the rule's hysteresis margin, so an open alert can be resolved automatically
+func (r *AlertRule) Clears(value float64) bool {
+	switch r.Operator {
+	case OperatorGT:
+		return value <= r.Threshold-r.Hysteresis
+	case OperatorGTE:
+		return value < r.Threshold-r.Hysteresis
+	case OperatorLT:
+		return value >= r.Threshold+r.Hysteresis
+	case OperatorLTE:
+		return value > r.Threshold+r.Hysteresis
+	}
+	return false
+}
+
+// Matches reports whether the rule's scope covers the given computer
+func (r *AlertRule) Matches(computer *Computer) bool {
+	if r.ComputerID != "" && r.ComputerID != computer.ComputerID {
+		return false
+	}
+	if r.LabID != nil && *r.LabID != computer.LabID {
+		return false
+	}
+	if r.CollegeID != nil && (computer.Lab == nil || *r.CollegeID != computer.Lab.CollegeID) {
+		return false
+	}
+	return true
+}
+
+// GetEnabledAlertRules returns every enabled alert rule
+func GetEnabledAlertRules(db *gorm.DB) ([]AlertRule, error) {
+	var rules []AlertRule
+	err := db.Where("enabled = ?", true).Order("created_at").Find(&rules).Error
+	return rules, err
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits on the collector intervals an admin may set
+const (
+	MinSampleInterval = 1
+	MaxSampleInterval = 3600
+	MinPollInterval   = 10
+	MaxPollInterval   = 86400
+)
+
+// CollectorConfig holds the settings a computer's collector pulls from the
+// server, so sampling can be tuned or paused without touching the machine
+type CollectorConfig struct {
+	ComputerID            string     `gorm:"primaryKey" json:"computer_id"`
+	SampleIntervalSeconds *int       `json:"sample_interval_seconds"` // nil keeps the collector's own setting
+	PollIntervalSeconds   *int       `json:"poll_interval_seconds"`   // how often the collector pulls this config; nil keeps its own
+	DNSCapture            bool       `gorm:"column:dns_capture;not null" json:"dns_capture"`
+	Paused                bool       `gorm:"not null" json:"paused"` // stops sampling and DNS capture
+	UpdatedAt             *time.Time `json:"updated_at"`
+	UpdatedBy             string     `gorm:"not null;default:''" json:"updated_by"`
+}
+
+// DefaultCollectorConfig returns the settings of a computer with no stored
+// config. The intervals are left unset so collectors keep their local values.
+func DefaultCollectorConfig(computerID string) CollectorConfig {
+	return CollectorConfig{
+		ComputerID: computerID,
+		DNSCapture: true,
+	}
+}
+
+// Valid reports whether the intervals that are set are within the allowed limits
+func (cfg *CollectorConfig) Valid() bool {
+	return within(cfg.SampleIntervalSeconds, MinSampleInterval, MaxSampleInterval) &&
+		within(cfg.PollIntervalSeconds, MinPollInterval, MaxPollInterval)
+}
+
+func within(value *int, min, max int) bool {
+	return value == nil || (*value >= min && *value <= max)
+}
+
+// GetCollectorConfig returns a computer's collector settings, or the defaults
+// if none were stored
+func GetCollectorConfig(db *gorm.DB, computerID string) (CollectorConfig, error) {
+	cfg := DefaultCollectorConfig(computerID)
+	err := db.Where("computer_id = ?", computerID).Limit(1).Find(&cfg).Error
+	return cfg, err
+}
+
+// GetCollectorConfigs returns every stored collector config
+func GetCollectorConfigs(db *gorm.DB) ([]CollectorConfig, error) {
+	var configs []CollectorConfig
+	err := db.Find(&configs).Error
+	return configs, err
+}
+
+// SaveCollectorConfig stores a computer's collector settings
+func SaveCollectorConfig(db *gorm.DB, cfg *CollectorConfig) error {
+	now := time.Now()
+	cfg.UpdatedAt = &now
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(cfg).Error
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type College struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *College) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// NormalizeName trims a college or lab name and collapses internal whitespace
+func NormalizeName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// GetAllColleges returns every college ordered by name
+func GetAllColleges(db *gorm.DB) ([]College, error) {
+	var colleges []College
+	err := db.Order("name").Find(&colleges).Error
+	return colleges, err
+}
+
+// GetCollegeByName finds a college by name, ignoring case and extra whitespace
+func GetCollegeByName(db *gorm.DB, name string) (*College, error) {
+	var college College
+	err := db.Where("lower(name) = lower(?)", NormalizeName(name)).First(&college).Error
+	return &college, err
+}
@@ -7,14 +7,42 @@ import (
 	"gorm.io/gorm"
 )
 
+// Computer lifecycle statuses
+const (
+	ComputerActive      = "active"
+	ComputerMaintenance = "maintenance" // still monitored, but raises no alerts
+	ComputerRetired     = "retired"     // decommissioned; archived with its history
+)
+
+// ValidComputerStatus reports whether status is a lifecycle status
+func ValidComputerStatus(status string) bool {
+	switch status {
+	case ComputerActive, ComputerMaintenance, ComputerRetired:
+		return true
+	}
+	return false
+}
+
 type Computer struct {
 	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
 	ComputerID string    `json:"computer_id" gorm:"uniqueIndex;not null;column:computer_id"`
-	College    string    `json:"college" gorm:"not null"`
-	LabName    string    `json:"lab_name" gorm:"not null"`
+	Name       string    `json:"name" gorm:"not null;default:''"`
+	LabID      uuid.UUID `json:"lab_id" gorm:"type:uuid;not null"`
+	Lab        *Lab      `json:"lab,omitempty" gorm:"foreignKey:LabID"`
 	LastSeen   time.Time `json:"last_seen"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Lifecycle; archived computers keep their history but are hidden from
+	// listings, dashboards and the heartbeat supervisor
+	Status          string     `json:"status" gorm:"type:varchar(12);not null"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	ArchivedAt      *time.Time `json:"archived_at,omitempty"`
+
+	// Collector credentials; only the SHA-256 of the device token is stored
+	DeviceTokenHash string     `json:"-" gorm:"not null;default:''"`
+	TokenIssuedAt   *time.Time `json:"token_issued_at,omitempty"`
+	TokenRevokedAt  *time.Time `json:"token_revoked_at,omitempty"`
 }
 
 // BeforeCreate is a GORM hook that runs before creating a new computer
@@ -22,9 +50,20 @@ func (c *Computer) BeforeCreate(tx *gorm.DB) error {
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
+	if c.ComputerID == "" {
+		c.ComputerID = c.ID.String()
+	}
+	if c.Status == "" {
+		c.Status = ComputerActive
+	}
 	return nil
 }
 
+// NotArchived is a query scope that leaves out archived computers
+func NotArchived(db *gorm.DB) *gorm.DB {
+	return db.Where("computers.archived_at IS NULL")
+}
+
 // RegisterComputer creates a new computer record in the database
 func (c *Computer) RegisterComputer(db *gorm.DB) error {
 	return db.Create(c).Error
@@ -33,17 +72,132 @@ func (c *Computer) RegisterComputer(db *gorm.DB) error {
 // GetAllComputers returns all registered computers
 func GetAllComputers(db *gorm.DB) ([]Computer, error) {
 	var computers []Computer
-	err := db.Order("created_at desc").Find(&computers).Error
+	err := db.Preload("Lab.College").Order("created_at desc").Find(&computers).Error
+	return computers, err
+}
+
+// GetComputersByLab returns the computers in a lab that are not archived
+func GetComputersByLab(db *gorm.DB, labID uuid.UUID) ([]Computer, error) {
+	var computers []Computer
+	err := db.Scopes(NotArchived).Preload("Lab.College").Where("lab_id = ?", labID).Order("created_at desc").Find(&computers).Error
 	return computers, err
 }
 
 // GetComputerBySystemID finds a computer by its SystemID
 func GetComputerBySystemID(db *gorm.DB, computerID string) (*Computer, error) {
 	var computer Computer
-	err := db.Where("computer_id = ?", computerID).First(&computer).Error
+	err := db.Preload("Lab.College").Where("computer_id = ?", computerID).First(&computer).Error
 	return &computer, err
 }
 
+// GetComputersBySystemIDs returns the computers with the given system IDs
+func GetComputersBySystemIDs(db *gorm.DB, computerIDs []string) ([]Computer, error) {
+	var computers []Computer
+	if len(computerIDs) == 0 {
+		return computers, nil
+	}
+	err := db.Preload("Lab").Where("computer_id IN ?", computerIDs).Find(&computers).Error
+	return computers, err
+}
+
+// TouchComputers sets LastSeen for several computers in one statement
+func TouchComputers(db *gorm.DB, computerIDs []string, at time.Time) error {
+	if len(computerIDs) == 0 {
+		return nil
+	}
+	return db.Model(&Computer{}).Where("computer_id IN ?", computerIDs).Update("last_seen", at).Error
+}
+
+// GetComputerByDeviceTokenHash finds the computer holding an active device token
+func GetComputerByDeviceTokenHash(db *gorm.DB, hash string) (*Computer, error) {
+	var computer Computer
+	err := db.Preload("Lab").Where("device_token_hash = ? AND device_token_hash <> ''", hash).First(&computer).Error
+	return &computer, err
+}
+
+// GetComputersWithoutDeviceToken returns the computers that were never issued
+// a device token, such as those registered before device tokens existed.
+// Revoked tokens stay revoked.
+func GetComputersWithoutDeviceToken(db *gorm.DB) ([]Computer, error) {
+	var computers []Computer
+	err := db.Scopes(NotArchived).Where("device_token_hash = '' AND token_issued_at IS NULL").
+		Order("created_at").Find(&computers).Error
+	return computers, err
+}
+
+// SetDeviceToken replaces the computer's device token hash, invalidating any previous token
+func (c *Computer) SetDeviceToken(db *gorm.DB, hash string) error {
+	now := time.Now()
+	c.DeviceTokenHash = hash
+	c.TokenIssuedAt = &now
+	c.TokenRevokedAt = nil
+	return db.Model(c).Updates(map[string]interface{}{
+		"device_token_hash": c.DeviceTokenHash,
+		"token_issued_at":   c.TokenIssuedAt,
+		"token_revoked_at":  nil,
+	}).Error
+}
+
+// RevokeDeviceToken clears the computer's device token so its collector can no longer submit data
+func (c *Computer) RevokeDeviceToken(db *gorm.DB) error {
+	now := time.Now()
+	c.DeviceTokenHash = ""
+	c.TokenRevokedAt = &now
+	return db.Model(c).Updates(map[string]interface{}{
+		"device_token_hash": "",
+		"token_revoked_at":  c.TokenRevokedAt,
+	}).Error
+}
+
+// AlertsSuppressed reports whether new alerts for the computer should be
+// dropped, because it is under maintenance or retired
+func (c *Computer) AlertsSuppressed() bool {
+	return c.Status == ComputerMaintenance || c.Status == ComputerRetired
+}
+
+// SetStatus moves the computer to a lifecycle status. Retiring archives it;
+// returning it to service unarchives it.
+func (c *Computer) SetStatus(status string) {
+	if status == c.Status {
+		return
+	}
+	now := time.Now()
+	c.Status = status
+	c.StatusChangedAt = &now
+	if status == ComputerRetired {
+		c.ArchivedAt = &now
+	} else {
+		c.ArchivedAt = nil
+	}
+}
+
+// SaveDetails stores the computer's name, lab and lifecycle status
+func (c *Computer) SaveDetails(db *gorm.DB) error {
+	return db.Model(c).Updates(map[string]interface{}{
+		"name":              c.Name,
+		"lab_id":            c.LabID,
+		"status":            c.Status,
+		"status_changed_at": c.StatusChangedAt,
+		"archived_at":       c.ArchivedAt,
+	}).Error
+}
+
+// CollegeName returns the name of the computer's college, if its lab is loaded
+func (c *Computer) CollegeName() string {
+	if c.Lab == nil || c.Lab.College == nil {
+		return ""
+	}
+	return c.Lab.College.Name
+}
+
+// LabName returns the name of the computer's lab, if loaded
+func (c *Computer) LabName() string {
+	if c.Lab == nil {
+		return ""
+	}
+	return c.Lab.Name
+}
+
 // UpdateLastSeen updates the LastSeen timestamp for a computer
 func (c *Computer) UpdateLastSeen(db *gorm.DB) error {
 	c.LastSeen = time.Now()
@@ -52,5 +206,10 @@ func (c *Computer) UpdateLastSeen(db *gorm.DB) error {
 
 // IsOnline returns true if the computer has been seen in the last 5 minutes
 func (c *Computer) IsOnline() bool {
-	return time.Since(c.LastSeen) <= 5*time.Minute
+	return c.IsOnlineWithin(5 * time.Minute)
+}
+
+// IsOnlineWithin returns true if the computer has been seen within the given threshold
+func (c *Computer) IsOnlineWithin(threshold time.Duration) bool {
+	return !c.LastSeen.IsZero() && time.Since(c.LastSeen) <= threshold
 }
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Computer connectivity states recorded in the status history
+const (
+	ComputerOnline  = "online"
+	ComputerOffline = "offline"
+)
+
+// ComputerStatusPeriod is one continuous online or offline stretch for a computer.
+// EndedAt is nil for the current period.
+type ComputerStatusPeriod struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	ComputerID string     `gorm:"not null" json:"computer_id"`
+	Status     string     `gorm:"type:varchar(8);not null" json:"status"`
+	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
+	EndedAt    *time.Time `json:"ended_at"`
+}
+
+func (ComputerStatusPeriod) TableName() string {
+	return "computer_status_history"
+}
+
+func (p *ComputerStatusPeriod) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// Duration returns how long the period lasted, up to now for the current period
+func (p *ComputerStatusPeriod) Duration() time.Duration {
+	if p.EndedAt != nil {
+		return p.EndedAt.Sub(p.StartedAt)
+	}
+	return time.Since(p.StartedAt)
+}
+
+// GetCurrentStatusPeriods returns the open period of every computer that has one
+func GetCurrentStatusPeriods(db *gorm.DB) ([]ComputerStatusPeriod, error) {
+	var periods []ComputerStatusPeriod
+	err := db.Where("ended_at IS NULL").Find(&periods).Error
+	return periods, err
+}
+
+// RecordStatusChange closes the computer's current period and opens a new one
+// at the given time. It returns the period that was closed, if there was one.
+func RecordStatusChange(db *gorm.DB, computerID, status string, at time.Time) (*ComputerStatusPeriod, error) {
+	var closed *ComputerStatusPeriod
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current ComputerStatusPeriod
+		err := tx.Where("computer_id = ? AND ended_at IS NULL", computerID).Limit(1).Find(&current).Error
+		if err != nil {
+			return err
+		}
+
+		if current.ID != uuid.Nil {
+			current.EndedAt = &at
+			if err := tx.Model(&current).Update("ended_at", at).Error; err != nil {
+				return err
+			}
+			closed = &current
+		}
+
+		return tx.Create(&ComputerStatusPeriod{
+			ComputerID: computerID,
+			Status:     status,
+			StartedAt:  at,
+		}).Error
+	})
+	return closed, err
+}
+
+// GetStatusHistory returns the periods overlapping [since, now], oldest first
+func GetStatusHistory(db *gorm.DB, computerID string, since time.Time) ([]ComputerStatusPeriod, error) {
+	var periods []ComputerStatusPeriod
+	err := db.Where("computer_id = ? AND (ended_at IS NULL OR ended_at >= ?)", computerID, since).
+		Order("started_at").
+		Find(&periods).Error
+	return periods, err
+}
@@ -9,10 +9,10 @@ import (
 
 type InternetUsage struct {
 	ID         uuid.UUID `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
-	ComputerID string    `gorm:"not null" json:"computer_id"`              // Foreign key to Computer
-	Computer   Computer  `gorm:"foreignKey:ComputerID" json:"computer"`    // Relationship with Computer
-	Domain     string    `gorm:"type:varchar(255);not null" json:"domain"` // e.g., "google.com"
-	Timestamp  time.Time `gorm:"not null" json:"timestamp"`                // When the domain was accessed
+	ComputerID string    `gorm:"not null" json:"computer_id"`                                 // Foreign key to Computer
+	Computer   Computer  `gorm:"foreignKey:ComputerID;references:ComputerID" json:"computer"` // Relationship with Computer
+	Domain     string    `gorm:"type:varchar(255);not null" json:"domain"`                    // e.g., "google.com"
+	Timestamp  time.Time `gorm:"not null" json:"timestamp"`                                   // When the domain was accessed
 	CreatedAt  time.Time `json:"created_at"`
 }
 
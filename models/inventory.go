@@ -0,0 +1,510 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AlertInventoryChanged is raised when a computer's hardware or installed
+// software changes
+const AlertInventoryChanged = "INVENTORY_CHANGED"
+
+// Limits on what a collector may report in one inventory
+const (
+	MaxInventoryDisks      = 128
+	MaxInventoryInterfaces = 128
+	MaxInventorySoftware   = 10000
+	MaxInventoryField      = 256 // bytes in any single text field
+)
+
+// Disk is a mounted filesystem
+type Disk struct {
+	Device     string `json:"device"`
+	Mountpoint string `json:"mountpoint"`
+	Fstype     string `json:"fstype"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// NetworkInterface is a network adapter with its MAC and IP addresses
+type NetworkInterface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac"`
+	Addresses []string `json:"addresses"`
+}
+
+// Software is an installed package or program
+type Software struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// DiskList is a list of disks stored as a jsonb array
+type DiskList []Disk
+
+func (l DiskList) Value() (driver.Value, error) { return jsonArray(l, len(l)) }
+
+func (l *DiskList) Scan(value interface{}) error { return scanJSON(value, l) }
+
+// InterfaceList is a list of network interfaces stored as a jsonb array
+type InterfaceList []NetworkInterface
+
+func (l InterfaceList) Value() (driver.Value, error) { return jsonArray(l, len(l)) }
+
+func (l *InterfaceList) Scan(value interface{}) error { return scanJSON(value, l) }
+
+// SoftwareList is a list of installed software stored as a jsonb array
+type SoftwareList []Software
+
+func (l SoftwareList) Value() (driver.Value, error) { return jsonArray(l, len(l)) }
+
+func (l *SoftwareList) Scan(value interface{}) error { return scanJSON(value, l) }
+
+// jsonArray encodes a list for a jsonb column, storing nil as an empty array
+func jsonArray(v interface{}, n int) (driver.Value, error) {
+	if n == 0 {
+		return "[]", nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Inventory is the hardware and software of a computer as reported by its collector
+type Inventory struct {
+	Hostname      string        `gorm:"not null;default:''" json:"hostname"`
+	OS            string        `gorm:"column:os;not null;default:''" json:"os"`
+	OSVersion     string        `gorm:"column:os_version;not null;default:''" json:"os_version"`
+	KernelVersion string        `gorm:"not null;default:''" json:"kernel_version"`
+	CPUModel      string        `gorm:"column:cpu_model;not null;default:''" json:"cpu_model"`
+	CPUCores      int           `gorm:"column:cpu_cores;not null" json:"cpu_cores"`
+	MemoryBytes   int64         `gorm:"not null" json:"memory_bytes"`
+	Disks         DiskList      `gorm:"type:jsonb;not null" json:"disks"`
+	Interfaces    InterfaceList `gorm:"type:jsonb;not null" json:"interfaces"`
+	Software      SoftwareList  `gorm:"type:jsonb;not null" json:"software"`
+}
+
+// Normalize trims the text fields and sorts the lists, so the same
+// inventory always hashes the same however the collector ordered it
+func (inv *Inventory) Normalize() {
+	inv.Hostname = strings.TrimSpace(inv.Hostname)
+	inv.OS = strings.TrimSpace(inv.OS)
+	inv.OSVersion = strings.TrimSpace(inv.OSVersion)
+	inv.KernelVersion = strings.TrimSpace(inv.KernelVersion)
+	inv.CPUModel = strings.TrimSpace(inv.CPUModel)
+	if inv.Disks == nil {
+		inv.Disks = DiskList{}
+	}
+	if inv.Interfaces == nil {
+		inv.Interfaces = InterfaceList{}
+	}
+	if inv.Software == nil {
+		inv.Software = SoftwareList{}
+	}
+
+	sort.Slice(inv.Disks, func(i, j int) bool { return inv.Disks[i].Mountpoint < inv.Disks[j].Mountpoint })
+	for i := range inv.Interfaces {
+		inv.Interfaces[i].MAC = strings.ToLower(inv.Interfaces[i].MAC)
+		if inv.Interfaces[i].Addresses == nil {
+			inv.Interfaces[i].Addresses = []string{}
+		}
+		sort.Strings(inv.Interfaces[i].Addresses)
+	}
+	sort.Slice(inv.Interfaces, func(i, j int) bool { return inv.Interfaces[i].Name < inv.Interfaces[j].Name })
+
+	for i := range inv.Software {
+		inv.Software[i].Name = strings.TrimSpace(inv.Software[i].Name)
+		inv.Software[i].Version = strings.TrimSpace(inv.Software[i].Version)
+	}
+	sort.Slice(inv.Software, func(i, j int) bool {
+		if inv.Software[i].Name != inv.Software[j].Name {
+			return inv.Software[i].Name < inv.Software[j].Name
+		}
+		return inv.Software[i].Version < inv.Software[j].Version
+	})
+}
+
+// Validate checks the inventory's sizes and values
+func (inv *Inventory) Validate() error {
+	if len(inv.Disks) > MaxInventoryDisks {
+		return fmt.Errorf("at most %d disks are allowed", MaxInventoryDisks)
+	}
+	if len(inv.Interfaces) > MaxInventoryInterfaces {
+		return fmt.Errorf("at most %d network interfaces are allowed", MaxInventoryInterfaces)
+	}
+	if len(inv.Software) > MaxInventorySoftware {
+		return fmt.Errorf("at most %d software entries are allowed", MaxInventorySoftware)
+	}
+	if inv.CPUCores < 0 || inv.MemoryBytes < 0 {
+		return errors.New("cpu_cores and memory_bytes must not be negative")
+	}
+
+	fields := []string{inv.Hostname, inv.OS, inv.OSVersion, inv.KernelVersion, inv.CPUModel}
+	for _, d := range inv.Disks {
+		if d.Mountpoint == "" || d.TotalBytes < 0 {
+			return fmt.Errorf("disk %q: mountpoint is required and total_bytes must not be negative", d.Device)
+		}
+		fields = append(fields, d.Device, d.Mountpoint, d.Fstype)
+	}
+	for _, n := range inv.Interfaces {
+		if n.Name == "" {
+			return errors.New("network interface name is required")
+		}
+		if len(n.Addresses) > MaxInventoryInterfaces {
+			return fmt.Errorf("interface %q: too many addresses", n.Name)
+		}
+		fields = append(fields, n.Name, n.MAC)
+		fields = append(fields, n.Addresses...)
+	}
+	for _, s := range inv.Software {
+		if err := validName(s.Name, MaxInventoryField); err != nil {
+			return fmt.Errorf("software %q: %v", s.Name, err)
+		}
+		fields = append(fields, s.Version)
+	}
+	for _, field := range fields {
+		if len(field) > MaxInventoryField {
+			return fmt.Errorf("fields must be at most %d bytes", MaxInventoryField)
+		}
+	}
+	return nil
+}
+
+// Hash identifies the inventory's content; call Normalize first
+func (inv *Inventory) Hash() string {
+	data, _ := json.Marshal(inv)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Inventory change kinds
+const (
+	InventoryAdded   = "added"
+	InventoryRemoved = "removed"
+	InventoryChanged = "changed"
+)
+
+// Inventory change categories
+const (
+	InventoryHostname  = "hostname"
+	InventoryOS        = "os"
+	InventoryKernel    = "kernel"
+	InventoryCPU       = "cpu"
+	InventoryMemory    = "memory"
+	InventoryDisk      = "disk"
+	InventoryInterface = "interface"
+	InventorySoftware  = "software"
+)
+
+var inventoryLabels = map[string]string{
+	InventoryHostname:  "Hostname",
+	InventoryOS:        "OS",
+	InventoryKernel:    "Kernel",
+	InventoryCPU:       "CPU",
+	InventoryMemory:    "Memory",
+	InventoryDisk:      "Disk",
+	InventoryInterface: "Network interface",
+	InventorySoftware:  "Software",
+}
+
+// InventoryChange is one difference between two versions of an inventory.
+// Memory that shrank is reported as removed and memory that grew as added.
+type InventoryChange struct {
+	Kind     string `json:"kind"`
+	Category string `json:"category"`
+	Item     string `json:"item,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+}
+
+func (c InventoryChange) String() string {
+	label := inventoryLabels[c.Category]
+	if c.Item == "" {
+		switch c.Kind {
+		case InventoryAdded:
+			return fmt.Sprintf("%s increased from %s to %s", label, c.From, c.To)
+		case InventoryRemoved:
+			return fmt.Sprintf("%s decreased from %s to %s", label, c.From, c.To)
+		}
+		return fmt.Sprintf("%s changed from %q to %q", label, c.From, c.To)
+	}
+	switch c.Kind {
+	case InventoryAdded:
+		return fmt.Sprintf("%s added: %s %s", label, c.Item, c.To)
+	case InventoryRemoved:
+		return fmt.Sprintf("%s removed: %s %s", label, c.Item, c.From)
+	}
+	return fmt.Sprintf("%s %s changed from %s to %s", label, c.Item, c.From, c.To)
+}
+
+// Notable reports whether the change is worth an alert. Software updates and
+// new IP addresses happen all the time, so they are only recorded.
+func (c InventoryChange) Notable() bool {
+	return !(c.Kind == InventoryChanged && (c.Category == InventorySoftware || c.Category == InventoryInterface))
+}
+
+// InventoryChanges is a list of changes stored as a jsonb array
+type InventoryChanges []InventoryChange
+
+func (l InventoryChanges) Value() (driver.Value, error) { return jsonArray(l, len(l)) }
+
+func (l *InventoryChanges) Scan(value interface{}) error { return scanJSON(value, l) }
+
+// Notable returns the changes worth an alert
+func (l InventoryChanges) Notable() InventoryChanges {
+	var notable InventoryChanges
+	for _, c := range l {
+		if c.Notable() {
+			notable = append(notable, c)
+		}
+	}
+	return notable
+}
+
+// Severity is warning when hardware was removed or the CPU changed, since
+// that can mean parts went missing, and info otherwise
+func (l InventoryChanges) Severity() string {
+	for _, c := range l {
+		if c.Category == InventoryCPU || (c.Kind == InventoryRemoved && c.Category != InventorySoftware) {
+			return SeverityWarning
+		}
+	}
+	return SeverityInfo
+}
+
+// Summary describes the first few changes for an alert message
+func (l InventoryChanges) Summary(max int) string {
+	var parts []string
+	for i, c := range l {
+		if i == max {
+			parts = append(parts, fmt.Sprintf("and %d more", len(l)-max))
+			break
+		}
+		parts = append(parts, c.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// DiffInventory lists what changed from before to after; both must be normalized
+func DiffInventory(before, after *Inventory) InventoryChanges {
+	var changes InventoryChanges
+	scalar := func(category, from, to string) {
+		if from != to {
+			changes = append(changes, InventoryChange{Kind: InventoryChanged, Category: category, From: from, To: to})
+		}
+	}
+	scalar(InventoryHostname, before.Hostname, after.Hostname)
+	scalar(InventoryOS, strings.TrimSpace(before.OS+" "+before.OSVersion), strings.TrimSpace(after.OS+" "+after.OSVersion))
+	scalar(InventoryKernel, before.KernelVersion, after.KernelVersion)
+	scalar(InventoryCPU, cpuDescription(before), cpuDescription(after))
+
+	if before.MemoryBytes != after.MemoryBytes {
+		kind := InventoryAdded
+		if after.MemoryBytes < before.MemoryBytes {
+			kind = InventoryRemoved
+		}
+		changes = append(changes, InventoryChange{
+			Kind:     kind,
+			Category: InventoryMemory,
+			From:     formatBytes(before.MemoryBytes),
+			To:       formatBytes(after.MemoryBytes),
+		})
+	}
+
+	changes = append(changes, diffItems(InventoryDisk, diskItems(before.Disks), diskItems(after.Disks))...)
+	changes = append(changes, diffItems(InventoryInterface, interfaceItems(before.Interfaces), interfaceItems(after.Interfaces))...)
+	changes = append(changes, diffItems(InventorySoftware, softwareItems(before.Software), softwareItems(after.Software))...)
+	return changes
+}
+
+// diffItems compares two sets of named items, each described by a string
+func diffItems(category string, before, after map[string]string) InventoryChanges {
+	var changes InventoryChanges
+	for _, item := range sortedKeys(before) {
+		to, ok := after[item]
+		switch {
+		case !ok:
+			changes = append(changes, InventoryChange{Kind: InventoryRemoved, Category: category, Item: item, From: before[item]})
+		case to != before[item]:
+			changes = append(changes, InventoryChange{Kind: InventoryChanged, Category: category, Item: item, From: before[item], To: to})
+		}
+	}
+	for _, item := range sortedKeys(after) {
+		if _, ok := before[item]; !ok {
+			changes = append(changes, InventoryChange{Kind: InventoryAdded, Category: category, Item: item, To: after[item]})
+		}
+	}
+	return changes
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func cpuDescription(inv *Inventory) string {
+	if inv.CPUModel == "" && inv.CPUCores == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s (%d cores)", inv.CPUModel, inv.CPUCores)
+}
+
+func diskItems(disks DiskList) map[string]string {
+	items := make(map[string]string, len(disks))
+	for _, d := range disks {
+		items[d.Mountpoint] = fmt.Sprintf("(%s, %s)", d.Device, formatBytes(d.TotalBytes))
+	}
+	return items
+}
+
+// interfaceItems keys adapters by MAC, since names can change between boots
+func interfaceItems(interfaces InterfaceList) map[string]string {
+	items := make(map[string]string, len(interfaces))
+	for _, n := range interfaces {
+		key := n.MAC
+		if key == "" {
+			key = n.Name
+		}
+		items[key] = fmt.Sprintf("%s [%s]", n.Name, strings.Join(n.Addresses, ", "))
+	}
+	return items
+}
+
+// softwareItems keys software by name; several installed versions of one
+// package are listed together
+func softwareItems(software SoftwareList) map[string]string {
+	items := make(map[string]string, len(software))
+	for _, s := range software {
+		if versions, ok := items[s.Name]; ok {
+			items[s.Name] = versions + ", " + s.Version
+		} else {
+			items[s.Name] = s.Version
+		}
+	}
+	return items
+}
+
+func formatBytes(n int64) string {
+	const gib = 1 << 30
+	if n >= gib {
+		return strconv.FormatFloat(float64(n)/gib, 'f', 1, 64) + " GiB"
+	}
+	return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + " MiB"
+}
+
+// InventorySnapshot is one version of a computer's inventory
+type InventorySnapshot struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	ComputerID  string    `gorm:"not null" json:"computer_id"`
+	Version     int       `gorm:"not null" json:"version"`
+	ContentHash string    `gorm:"not null" json:"-"`
+	Inventory   `gorm:"embedded"`
+	Changes     InventoryChanges `gorm:"type:jsonb;not null" json:"changes"` // against the previous version
+	// CollectedAt is when the collector took the version; LastReportedAt
+	// when it last reported the same inventory again
+	CollectedAt    time.Time `gorm:"not null" json:"collected_at"`
+	ReceivedAt     time.Time `gorm:"not null" json:"received_at"`
+	LastReportedAt time.Time `gorm:"not null" json:"last_reported_at"`
+}
+
+func (s *InventorySnapshot) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// InventoryVersion summarises a stored version without its lists
+type InventoryVersion struct {
+	ID             uuid.UUID        `json:"id"`
+	Version        int              `json:"version"`
+	Changes        InventoryChanges `json:"changes"`
+	CollectedAt    time.Time        `json:"collected_at"`
+	ReceivedAt     time.Time        `json:"received_at"`
+	LastReportedAt time.Time        `json:"last_reported_at"`
+}
+
+// RecordInventory stores a reported inventory as a new version if it differs
+// from the latest one; otherwise it only notes that it was reported again.
+// changed is false for repeats and for reports older than the latest version.
+func RecordInventory(db *gorm.DB, computerID string, inv Inventory, collectedAt time.Time) (snapshot *InventorySnapshot, changed bool, err error) {
+	inv.Normalize()
+	hash := inv.Hash()
+	now := time.Now()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		latest, err := GetLatestInventory(tx, computerID)
+		if err != nil {
+			return err
+		}
+
+		if latest != nil && (latest.ContentHash == hash || collectedAt.Before(latest.CollectedAt)) {
+			snapshot = latest
+			if latest.ContentHash != hash {
+				return nil
+			}
+			latest.LastReportedAt = now
+			return tx.Model(latest).Update("last_reported_at", now).Error
+		}
+
+		snapshot = &InventorySnapshot{
+			ComputerID:     computerID,
+			Version:        1,
+			ContentHash:    hash,
+			Inventory:      inv,
+			CollectedAt:    collectedAt,
+			ReceivedAt:     now,
+			LastReportedAt: now,
+		}
+		if latest != nil {
+			snapshot.Version = latest.Version + 1
+			snapshot.Changes = DiffInventory(&latest.Inventory, &inv)
+		}
+		changed = true
+		return tx.Create(snapshot).Error
+	})
+	return snapshot, changed, err
+}
+
+// GetLatestInventory returns a computer's newest inventory version, or nil if
+// it has never reported one
+func GetLatestInventory(db *gorm.DB, computerID string) (*InventorySnapshot, error) {
+	var snapshots []InventorySnapshot
+	err := db.Where("computer_id = ?", computerID).Order("version DESC").Limit(1).Find(&snapshots).Error
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return &snapshots[0], nil
+}
+
+// GetInventoryVersion returns one version of a computer's inventory
+func GetInventoryVersion(db *gorm.DB, computerID string, version int) (*InventorySnapshot, error) {
+	var snapshot InventorySnapshot
+	err := db.Where("computer_id = ? AND version = ?", computerID, version).First(&snapshot).Error
+	return &snapshot, err
+}
+
+// GetInventoryVersions lists a computer's inventory versions, newest first
+func GetInventoryVersions(db *gorm.DB, computerID string, limit int) ([]InventoryVersion, error) {
+	var versions []InventoryVersion
+	err := db.Model(&InventorySnapshot{}).
+		Select("id, version, changes, collected_at, received_at, last_reported_at").
+		Where("computer_id = ?", computerID).
+		Order("version DESC").
+		Limit(limit).
+		Scan(&versions).Error
+	return versions, err
+}
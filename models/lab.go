@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Lab struct {
+	ID                      uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	CollegeID               uuid.UUID `gorm:"type:uuid;not null" json:"college_id"`
+	Name                    string    `gorm:"not null" json:"name"`
+	Room                    string    `gorm:"not null;default:''" json:"room"`
+	Capacity                int       `gorm:"not null;default:0" json:"capacity"`
+	ResponsibleStaff        string    `gorm:"not null;default:''" json:"responsible_staff"`
+	OfflineThresholdSeconds *int      `json:"offline_threshold_seconds"` // nil uses the server default
+	CreatedAt               time.Time `json:"created_at"`
+	UpdatedAt               time.Time `json:"updated_at"`
+	College                 *College  `gorm:"foreignKey:CollegeID" json:"college,omitempty"`
+}
+
+func (l *Lab) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// GetLabByID finds a lab with its college
+func GetLabByID(db *gorm.DB, id uuid.UUID) (*Lab, error) {
+	var lab Lab
+	err := db.Preload("College").First(&lab, "id = ?", id).Error
+	return &lab, err
+}
+
+// GetLabByName finds a lab by college and lab name, ignoring case and extra whitespace
+func GetLabByName(db *gorm.DB, collegeName, labName string) (*Lab, error) {
+	var lab Lab
+	err := db.Preload("College").
+		Joins("JOIN colleges ON colleges.id = labs.college_id").
+		Where("lower(colleges.name) = lower(?) AND lower(labs.name) = lower(?)",
+			NormalizeName(collegeName), NormalizeName(labName)).
+		First(&lab).Error
+	return &lab, err
+}
+
+// GetLabs returns all labs, optionally limited to one college
+func GetLabs(db *gorm.DB, collegeID *uuid.UUID) ([]Lab, error) {
+	var labs []Lab
+	query := db.Preload("College").Order("name")
+	if collegeID != nil {
+		query = query.Where("college_id = ?", *collegeID)
+	}
+	err := query.Find(&labs).Error
+	return labs, err
+}
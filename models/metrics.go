@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode"
+)
+
+// Limits on the extended metrics of one sample
+const (
+	MaxMetricsPerSample = 512
+	MaxMetricNameLength = 128
+	MaxUsersPerSample   = 100
+)
+
+// Metrics holds a sample's extended host metrics by name, stored as jsonb.
+// Names are dotted paths with the instance in the middle, e.g. "cpu.core.0",
+// "load.1", "disk./home.used_percent" or "net.eth0.bytes_recv_per_sec".
+type Metrics map[string]float64
+
+// Validate checks the number of metrics, their names and values
+func (m Metrics) Validate() error {
+	if len(m) > MaxMetricsPerSample {
+		return fmt.Errorf("at most %d metrics are allowed per sample", MaxMetricsPerSample)
+	}
+	for name, value := range m {
+		if err := validName(name, MaxMetricNameLength); err != nil {
+			return fmt.Errorf("metric %q: %v", name, err)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("metric %q: value must be finite", name)
+		}
+	}
+	return nil
+}
+
+func (m Metrics) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func (m *Metrics) Scan(value interface{}) error {
+	return scanJSON(value, m)
+}
+
+// StringList is a list of strings stored as a jsonb array
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	return scanJSON(value, l)
+}
+
+// ValidateUsers checks the logged-in user names reported with a sample
+func ValidateUsers(users []string) error {
+	if len(users) > MaxUsersPerSample {
+		return fmt.Errorf("at most %d users are allowed per sample", MaxUsersPerSample)
+	}
+	for _, user := range users {
+		if err := validName(user, MaxMetricNameLength); err != nil {
+			return fmt.Errorf("user %q: %v", user, err)
+		}
+	}
+	return nil
+}
+
+func validName(name string, maxLength int) error {
+	if name == "" {
+		return errors.New("name is empty")
+	}
+	if len(name) > maxLength {
+		return fmt.Errorf("name is longer than %d bytes", maxLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return errors.New("name contains control characters")
+		}
+	}
+	return nil
+}
+
+func scanJSON(value interface{}, dest interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	}
+	return fmt.Errorf("cannot scan %T into %T", value, dest)
+}
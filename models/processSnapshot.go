@@ -0,0 +1,132 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxProcessesPerSnapshot limits how many processes a sample may report
+const MaxProcessesPerSnapshot = 100
+
+// ProcessInfo is one process in a snapshot. CPU is percent of one core (so
+// it can exceed 100 on multi-core machines); Memory is percent of RAM.
+type ProcessInfo struct {
+	PID         int32   `json:"pid"`
+	Name        string  `json:"name"`
+	User        string  `json:"user"`
+	CPU         float64 `json:"cpu"`
+	Memory      float64 `json:"memory"`
+	RSS         uint64  `json:"rss"`
+	CmdlineHash string  `json:"cmdline_hash"` // SHA-256 of the command line, so arguments aren't stored
+}
+
+// ProcessList is a list of processes stored as a jsonb array
+type ProcessList []ProcessInfo
+
+func (l ProcessList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+func (l *ProcessList) Scan(value interface{}) error {
+	return scanJSON(value, l)
+}
+
+// Validate checks the size of the list and each process's fields
+func (l ProcessList) Validate() error {
+	if len(l) > MaxProcessesPerSnapshot {
+		return fmt.Errorf("at most %d processes are allowed per sample", MaxProcessesPerSnapshot)
+	}
+	for _, p := range l {
+		if err := validName(p.Name, MaxMetricNameLength); err != nil {
+			return fmt.Errorf("process %d: %v", p.PID, err)
+		}
+		if len(p.User) > MaxMetricNameLength || len(p.CmdlineHash) > 64 {
+			return fmt.Errorf("process %d: field too long", p.PID)
+		}
+		if p.CPU < 0 || p.Memory < 0 || p.Memory > 100 || math.IsNaN(p.CPU) || math.IsNaN(p.Memory) {
+			return fmt.Errorf("process %d: invalid usage values", p.PID)
+		}
+	}
+	return nil
+}
+
+// Top returns up to n processes with the highest usage of metric ("memory",
+// otherwise CPU)
+func (l ProcessList) Top(metric string, n int) ProcessList {
+	top := append(ProcessList(nil), l...)
+	sort.SliceStable(top, func(i, j int) bool {
+		if metric == MetricMemory {
+			return top[i].Memory > top[j].Memory
+		}
+		return top[i].CPU > top[j].CPU
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// ProcessSnapshot is the list of top processes reported with one resource sample
+type ProcessSnapshot struct {
+	ResourceLogID uuid.UUID   `gorm:"type:uuid;primaryKey" json:"resource_log_id"`
+	ComputerID    string      `gorm:"not null" json:"computer_id"`
+	TakenAt       time.Time   `gorm:"not null" json:"taken_at"`
+	Processes     ProcessList `gorm:"type:jsonb;not null" json:"processes"`
+}
+
+// SaveProcessSnapshots stores the process lists carried by newly stored
+// samples. Snapshots already stored are left alone, so replays are safe.
+func SaveProcessSnapshots(db *gorm.DB, logs []ResourceLog) error {
+	var snapshots []ProcessSnapshot
+	for _, log := range logs {
+		if len(log.Processes) == 0 {
+			continue
+		}
+		snapshots = append(snapshots, ProcessSnapshot{
+			ResourceLogID: log.ID,
+			ComputerID:    log.ComputerID,
+			TakenAt:       log.Timestamp,
+			Processes:     log.Processes,
+		})
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&snapshots).Error
+}
+
+// GetLatestProcessSnapshot returns a computer's newest snapshot taken in
+// [since, until], or nil if there is none
+func GetLatestProcessSnapshot(db *gorm.DB, computerID string, since, until time.Time) (*ProcessSnapshot, error) {
+	var snapshots []ProcessSnapshot
+	err := db.Where("computer_id = ? AND taken_at >= ? AND taken_at <= ?", computerID, since, until).
+		Order("taken_at DESC").
+		Limit(1).
+		Find(&snapshots).Error
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return &snapshots[0], nil
+}
+
+// GetProcessSnapshots returns a computer's snapshots in [from, to], newest first
+func GetProcessSnapshots(db *gorm.DB, computerID string, from, to time.Time, limit int) ([]ProcessSnapshot, error) {
+	var snapshots []ProcessSnapshot
+	err := db.Where("computer_id = ? AND taken_at >= ? AND taken_at <= ?", computerID, from, to).
+		Order("taken_at DESC").
+		Limit(limit).
+		Find(&snapshots).Error
+	return snapshots, err
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is one link in a login session's chain of refresh tokens.
+// Each refresh revokes the presented token and issues its replacement; only
+// the SHA-256 of the token is stored.
+type RefreshToken struct {
+	ID              uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	SessionID       uuid.UUID  `json:"session_id" gorm:"type:uuid;not null;index"`
+	UserID          uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TokenHash       string     `json:"-" gorm:"uniqueIndex;not null"`
+	Device          string     `json:"device"`
+	UserAgent       string     `json:"user_agent"`
+	IPAddress       string     `json:"ip_address"`
+	AccessJTI       uuid.UUID  `json:"-" gorm:"column:access_jti;type:uuid;not null"`
+	AccessExpiresAt time.Time  `json:"-" gorm:"not null"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy      *uuid.UUID `json:"-" gorm:"type:uuid"`
+}
+
+// BeforeCreate is a GORM hook that runs before creating a new refresh token
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsActive reports whether the token can still be exchanged
+func (t *RefreshToken) IsActive(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// GetRefreshTokenByHash finds a refresh token by the hash of its value
+func GetRefreshTokenByHash(db *gorm.DB, hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRotated revokes the token in favour of its replacement. It returns false
+// if the token was already revoked, e.g. by a concurrent refresh.
+func (t *RefreshToken) MarkRotated(db *gorm.DB, replacement uuid.UUID, at time.Time) (bool, error) {
+	result := db.Model(&RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", t.ID).
+		Updates(map[string]interface{}{"revoked_at": at, "replaced_by": replacement})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeSession ends one login session: its refresh tokens stop working and
+// any access tokens issued for it that have not expired are denylisted
+func RevokeSession(db *gorm.DB, sessionID uuid.UUID) error {
+	return revokeRefreshTokens(db, "session_id = ?", sessionID)
+}
+
+// RevokeUserSessions ends every login session of a user
+func RevokeUserSessions(db *gorm.DB, userID uuid.UUID) error {
+	return revokeRefreshTokens(db, "user_id = ?", userID)
+}
+
+func revokeRefreshTokens(db *gorm.DB, query string, arg interface{}) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Rotated tokens are already revoked, but the access tokens issued
+		// with them may still be live
+		var live []RefreshToken
+		if err := tx.Where(query, arg).Where("access_expires_at > ?", now).Find(&live).Error; err != nil {
+			return err
+		}
+		for _, t := range live {
+			if err := RevokeAccessToken(tx, t.AccessJTI, t.UserID, t.AccessExpiresAt); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&RefreshToken{}).
+			Where(query, arg).
+			Where("revoked_at IS NULL").
+			Update("revoked_at", now).Error
+	})
+}
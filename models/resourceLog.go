@@ -8,14 +8,19 @@ import (
 )
 
 type ResourceLog struct {
-	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	ComputerID string    `gorm:"not null" json:"computer_id"`
-	Timestamp  time.Time `gorm:"not null;index" json:"timestamp"`
-	CPU        float64   `gorm:"not null;check:cpu >= 0 AND cpu <= 100" json:"cpu"`
-	Memory     float64   `gorm:"not null;check:memory >= 0 AND memory <= 100" json:"memory"`
-	NetworkIn  float64   `gorm:"not null;check:network_in >= 0" json:"network_in"`
-	NetworkOut float64   `gorm:"not null;check:network_out >= 0" json:"network_out"`
-	Computer   Computer  `gorm:"foreignKey:ComputerID" json:"computer,omitempty"`
+	ID         uuid.UUID   `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	ComputerID string      `gorm:"not null" json:"computer_id"`
+	Timestamp  time.Time   `gorm:"not null;index" json:"timestamp"` // capture time reported by the collector
+	ReceivedAt time.Time   `gorm:"not null;default:now()" json:"received_at"`
+	Seq        *int64      `json:"seq,omitempty"` // collector sequence number, unique per computer
+	CPU        float64     `gorm:"not null;check:cpu >= 0 AND cpu <= 100" json:"cpu"`
+	Memory     float64     `gorm:"not null;check:memory >= 0 AND memory <= 100" json:"memory"`
+	NetworkIn  float64     `gorm:"not null;check:network_in >= 0" json:"network_in"`
+	NetworkOut float64     `gorm:"not null;check:network_out >= 0" json:"network_out"`
+	Metrics    Metrics     `gorm:"type:jsonb;not null" json:"metrics,omitempty"` // extended host metrics by name
+	Users      StringList  `gorm:"type:jsonb;not null" json:"users,omitempty"`   // logged-in users
+	Processes  ProcessList `gorm:"-" json:"processes,omitempty"`                 // top processes, stored as a ProcessSnapshot
+	Computer   Computer    `gorm:"foreignKey:ComputerID;references:ComputerID" json:"computer,omitempty"`
 }
 
 func (r *ResourceLog) BeforeCreate(tx *gorm.DB) error {
@@ -24,3 +29,43 @@ func (r *ResourceLog) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// SampleKey identifies a sample by its collector sequence number
+type SampleKey struct {
+	ComputerID string
+	Seq        int64
+}
+
+// FindStoredSamples returns which of the given samples are already stored
+func FindStoredSamples(db *gorm.DB, keys []SampleKey) (map[SampleKey]bool, error) {
+	stored := make(map[SampleKey]bool)
+	if len(keys) == 0 {
+		return stored, nil
+	}
+
+	tuples := make([][]interface{}, len(keys))
+	for i, key := range keys {
+		tuples[i] = []interface{}{key.ComputerID, key.Seq}
+	}
+
+	var found []SampleKey
+	err := db.Model(&ResourceLog{}).Select("computer_id, seq").
+		Where("(computer_id, seq) IN ?", tuples).
+		Scan(&found).Error
+	for _, key := range found {
+		stored[key] = true
+	}
+	return stored, err
+}
+
+// GetLatestResourceLogs returns the most recent sample of each given computer
+func GetLatestResourceLogs(db *gorm.DB, computerIDs []string) ([]ResourceLog, error) {
+	var logs []ResourceLog
+	if len(computerIDs) == 0 {
+		return logs, nil
+	}
+	err := db.Raw(`SELECT DISTINCT ON (computer_id) * FROM resource_logs
+		WHERE computer_id IN ? ORDER BY computer_id, timestamp DESC`, computerIDs).
+		Scan(&logs).Error
+	return logs, err
+}
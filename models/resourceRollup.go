@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Rollup resolutions, from finest to coarsest
+const (
+	ResolutionRaw    = "raw"
+	ResolutionMinute = "1m"
+	ResolutionHour   = "1h"
+	ResolutionDay    = "1d"
+)
+
+// RollupResolutions lists the aggregated resolutions and their bucket sizes
+var RollupResolutions = []struct {
+	Name string
+	Step time.Duration
+}{
+	{ResolutionMinute, time.Minute},
+	{ResolutionHour, time.Hour},
+	{ResolutionDay, 24 * time.Hour},
+}
+
+// RollupStep returns the bucket size of a rollup resolution
+func RollupStep(resolution string) (time.Duration, bool) {
+	for _, r := range RollupResolutions {
+		if r.Name == resolution {
+			return r.Step, true
+		}
+	}
+	return 0, false
+}
+
+// ResourceRollup holds min/avg/max/p95 of a computer's samples over one bucket
+type ResourceRollup struct {
+	Resolution    string    `gorm:"primaryKey;type:varchar(4)" json:"-"`
+	ComputerID    string    `gorm:"primaryKey" json:"computer_id"`
+	Bucket        time.Time `gorm:"primaryKey" json:"bucket"`
+	Samples       int       `gorm:"not null" json:"samples"`
+	CPUMin        float64   `gorm:"column:cpu_min" json:"cpu_min"`
+	CPUAvg        float64   `gorm:"column:cpu_avg" json:"cpu_avg"`
+	CPUMax        float64   `gorm:"column:cpu_max" json:"cpu_max"`
+	CPUP95        float64   `gorm:"column:cpu_p95" json:"cpu_p95"`
+	MemoryMin     float64   `json:"memory_min"`
+	MemoryAvg     float64   `json:"memory_avg"`
+	MemoryMax     float64   `json:"memory_max"`
+	MemoryP95     float64   `gorm:"column:memory_p95" json:"memory_p95"`
+	NetworkInMin  float64   `json:"network_in_min"`
+	NetworkInAvg  float64   `json:"network_in_avg"`
+	NetworkInMax  float64   `json:"network_in_max"`
+	NetworkInP95  float64   `gorm:"column:network_in_p95" json:"network_in_p95"`
+	NetworkOutMin float64   `json:"network_out_min"`
+	NetworkOutAvg float64   `json:"network_out_avg"`
+	NetworkOutMax float64   `json:"network_out_max"`
+	NetworkOutP95 float64   `gorm:"column:network_out_p95" json:"network_out_p95"`
+}
+
+// RollupState records how far a resolution has been aggregated
+type RollupState struct {
+	Resolution       string    `gorm:"primaryKey;type:varchar(4)"`
+	CompletedThrough time.Time `gorm:"not null"`
+}
+
+func (RollupState) TableName() string {
+	return "rollup_state"
+}
+
+// GetRollupWatermark returns how far a resolution has been aggregated, or the
+// zero time if it never has
+func GetRollupWatermark(db *gorm.DB, resolution string) (time.Time, error) {
+	var state RollupState
+	err := db.Where("resolution = ?", resolution).Limit(1).Find(&state).Error
+	return state.CompletedThrough, err
+}
+
+// SetRollupWatermark records how far a resolution has been aggregated
+func SetRollupWatermark(db *gorm.DB, resolution string, through time.Time) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&RollupState{Resolution: resolution, CompletedThrough: through}).Error
+}
+
+// RewindRollupWatermark moves a resolution's watermark back to at, so buckets
+// from then on are recomputed to include samples that arrived late
+func RewindRollupWatermark(db *gorm.DB, resolution string, at time.Time) error {
+	return db.Model(&RollupState{}).Where("resolution = ? AND completed_through > ?", resolution, at).
+		Update("completed_through", at).Error
+}
+
+// metric columns aggregated into every rollup
+var rollupMetrics = []string{"cpu", "memory", "network_in", "network_out"}
+
+// AggregateResourceLogs (re)computes the rollup buckets of one resolution
+// covering raw samples in [from, to). Existing buckets are replaced, so the
+// same range can safely be aggregated again when late samples arrive.
+func AggregateResourceLogs(db *gorm.DB, resolution string, from, to time.Time) error {
+	step, ok := RollupStep(resolution)
+	if !ok {
+		return fmt.Errorf("unknown resolution %q", resolution)
+	}
+
+	columns := "resolution, computer_id, bucket, samples"
+	selects := "?, computer_id, to_timestamp(floor(extract(epoch FROM timestamp) / ?) * ?) AS bucket, count(*)"
+	updates := "samples = EXCLUDED.samples"
+	for _, m := range rollupMetrics {
+		columns += fmt.Sprintf(", %[1]s_min, %[1]s_avg, %[1]s_max, %[1]s_p95", m)
+		selects += fmt.Sprintf(", min(%[1]s), avg(%[1]s), max(%[1]s), percentile_cont(0.95) WITHIN GROUP (ORDER BY %[1]s)", m)
+		for _, agg := range []string{"min", "avg", "max", "p95"} {
+			updates += fmt.Sprintf(", %[1]s_%[2]s = EXCLUDED.%[1]s_%[2]s", m, agg)
+		}
+	}
+
+	seconds := step.Seconds()
+	return db.Exec(`INSERT INTO resource_rollups (`+columns+`)
+		SELECT `+selects+`
+		FROM resource_logs
+		WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY computer_id, bucket
+		ON CONFLICT (resolution, computer_id, bucket) DO UPDATE SET `+updates,
+		resolution, seconds, seconds, from, to).Error
+}
+
+// GetResourceRollups returns a computer's buckets of one resolution in [from, to)
+func GetResourceRollups(db *gorm.DB, resolution, computerID string, from, to time.Time) ([]ResourceRollup, error) {
+	var rollups []ResourceRollup
+	err := db.Where("resolution = ? AND computer_id = ? AND bucket >= ? AND bucket < ?", resolution, computerID, from, to).
+		Order("bucket").
+		Find(&rollups).Error
+	return rollups, err
+}
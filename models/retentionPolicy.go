@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RawSamplesPolicy is the target of the policy for raw resource samples
+const RawSamplesPolicy = "resource_logs"
+
+// RetentionPolicy sets how long one kind of data is kept before the pruner
+// deletes it. RetentionDays = 0 keeps data forever.
+type RetentionPolicy struct {
+	Target        string     `gorm:"primaryKey;type:varchar(40)" json:"target"`
+	RetentionDays int        `gorm:"not null" json:"retention_days"`
+	Enabled       bool       `gorm:"not null;default:true" json:"enabled"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	UpdatedBy     string     `gorm:"not null;default:''" json:"updated_by"`
+	LastRunAt     *time.Time `json:"last_run_at"`
+	LastDeleted   int64      `gorm:"not null;default:0" json:"last_deleted"`
+}
+
+// Cutoff returns the time before which data is expired, and false if the
+// policy keeps data forever or is disabled
+func (p *RetentionPolicy) Cutoff(now time.Time) (time.Time, bool) {
+	if !p.Enabled || p.RetentionDays <= 0 {
+		return time.Time{}, false
+	}
+	return now.AddDate(0, 0, -p.RetentionDays), true
+}
+
+// GetRetentionPolicies returns every retention policy
+func GetRetentionPolicies(db *gorm.DB) ([]RetentionPolicy, error) {
+	var policies []RetentionPolicy
+	err := db.Order("target").Find(&policies).Error
+	return policies, err
+}
+
+// GetRetentionPolicy returns the policy for one target
+func GetRetentionPolicy(db *gorm.DB, target string) (*RetentionPolicy, error) {
+	var policy RetentionPolicy
+	err := db.First(&policy, "target = ?", target).Error
+	return &policy, err
+}
+
+// RecordPruneRun stores the outcome of a pruning pass for a policy
+func (p *RetentionPolicy) RecordPruneRun(db *gorm.DB, at time.Time, deleted int64) error {
+	p.LastRunAt = &at
+	p.LastDeleted = deleted
+	return db.Model(&RetentionPolicy{}).Where("target = ?", p.Target).
+		UpdateColumns(map[string]interface{}{"last_run_at": at, "last_deleted": deleted}).Error
+}
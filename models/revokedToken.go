@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokedToken denylists an access token by its JWT ID until it expires
+type RevokedToken struct {
+	JTI       uuid.UUID `json:"jti" gorm:"column:jti;type:uuid;primary_key"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	RevokedAt time.Time `json:"revoked_at" gorm:"not null"`
+}
+
+// RevokeAccessToken denylists an access token. Entries for tokens that have
+// since expired are pruned at the same time.
+func RevokeAccessToken(db *gorm.DB, jti, userID uuid.UUID, expiresAt time.Time) error {
+	now := time.Now()
+	if err := db.Where("expires_at <= ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if !expiresAt.After(now) {
+		return nil
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		RevokedAt: now,
+	}).Error
+}
+
+// IsTokenRevoked reports whether an access token has been denylisted
+func IsTokenRevoked(db *gorm.DB, jti uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
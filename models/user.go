@@ -5,11 +5,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// User roles
+const (
+	RoleAdmin      = "admin"       // full access
+	RoleLabManager = "lab_manager" // read and act on alerts in assigned labs only
+	RoleViewer     = "viewer"      // read-only access to everything
+)
+
 type User struct {
 	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
 	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
 	PasswordHash string    `gorm:"not null" json:"-"`
-	Role         string    `gorm:"type:varchar(10);check:role IN ('admin', 'user');not null" json:"role"`
+	Role         string    `gorm:"type:varchar(20);check:role IN ('admin', 'lab_manager', 'viewer');not null" json:"role"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
@@ -18,3 +25,36 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// IsValidRole reports whether role is a known user role
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleLabManager || role == RoleViewer
+}
+
+// UserLab assigns a lab to a lab manager
+type UserLab struct {
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	LabID  uuid.UUID `gorm:"type:uuid;primaryKey" json:"lab_id"`
+}
+
+// GetUserLabIDs returns the labs assigned to a user
+func GetUserLabIDs(db *gorm.DB, userID uuid.UUID) ([]uuid.UUID, error) {
+	var labIDs []uuid.UUID
+	err := db.Model(&UserLab{}).Where("user_id = ?", userID).Pluck("lab_id", &labIDs).Error
+	return labIDs, err
+}
+
+// SetUserLabs replaces a user's lab assignments
+func SetUserLabs(db *gorm.DB, userID uuid.UUID, labIDs []uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserLab{}).Error; err != nil {
+			return err
+		}
+		for _, labID := range labIDs {
+			if err := tx.Create(&UserLab{UserID: userID, LabID: labID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package retention
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/rollup"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultInterval  = time.Hour
+	defaultBatchSize = 5000
+	batchPause       = 100 * time.Millisecond // gives ingestion room between batches
+)
+
+// target describes where a policy's data lives. condition selects expired
+// rows and takes the cutoff as its only argument.
+type target struct {
+	table     string
+	condition string
+}
+
+// targets maps policy names to the data they prune
+var targets = map[string]target{
+	"resource_logs":           {"resource_logs", "timestamp < ?"},
+	"resource_rollups_1m":     {"resource_rollups", "resolution = '1m' AND bucket < ?"},
+	"resource_rollups_1h":     {"resource_rollups", "resolution = '1h' AND bucket < ?"},
+	"resource_rollups_1d":     {"resource_rollups", "resolution = '1d' AND bucket < ?"},
+	"internet_usages":         {"internet_usages", "timestamp < ?"},
+	"alerts":                  {"alerts", "status = 'resolved' AND resolved_at < ?"},
+	"computer_status_history": {"computer_status_history", "ended_at IS NOT NULL AND ended_at < ?"},
+	"process_snapshots":       {"process_snapshots", "taken_at < ?"},
+}
+
+// Result reports one policy's pruning pass. Rows is what was deleted, or
+// what would be in a dry run.
+type Result struct {
+	Target string     `json:"target"`
+	Cutoff *time.Time `json:"cutoff"`
+	Rows   int64      `json:"rows"`
+	DryRun bool       `json:"dry_run"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Pruner periodically deletes data older than its retention policy
+type Pruner struct {
+	db        *gorm.DB
+	interval  time.Duration
+	batchSize int
+	dryRun    bool
+
+	mu sync.Mutex // one pass at a time
+}
+
+// Default is the process-wide pruner, set by Start
+var Default *Pruner
+
+// Start launches the pruner in the background. RETENTION_INTERVAL sets how
+// often it runs, RETENTION_BATCH_SIZE how many rows each delete removes, and
+// RETENTION_DRY_RUN=true makes it only log what it would delete.
+func Start(db *gorm.DB) *Pruner {
+	p := &Pruner{
+		db:        db,
+		interval:  utils.EnvDuration("RETENTION_INTERVAL", defaultInterval),
+		batchSize: utils.EnvInt("RETENTION_BATCH_SIZE", defaultBatchSize),
+		dryRun:    utils.EnvBool("RETENTION_DRY_RUN"),
+	}
+	Default = p
+
+	go p.run()
+	return p
+}
+
+func (p *Pruner) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := p.Run(p.dryRun)
+		if err != nil {
+			utils.LogError("Retention pass failed: %v", err)
+		}
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				utils.LogError("Failed to prune %s: %s", r.Target, r.Error)
+			case r.DryRun && r.Rows > 0:
+				utils.LogInfo("Retention dry run: would delete %d rows from %s", r.Rows, r.Target)
+			case r.Rows > 0:
+				utils.LogInfo("Retention: deleted %d rows from %s", r.Rows, r.Target)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Run applies every policy once. With dryRun nothing is deleted and Rows
+// reports how many rows would be.
+func (p *Pruner) Run(dryRun bool) ([]Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	policies, err := models.GetRetentionPolicies(p.db)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var results []Result
+	for i := range policies {
+		policy := &policies[i]
+		t, ok := targets[policy.Target]
+		if !ok {
+			continue
+		}
+
+		result := Result{Target: policy.Target, DryRun: dryRun}
+		cutoff, ok := policy.Cutoff(now)
+		if ok && policy.Target == models.RawSamplesPolicy {
+			cutoff, ok, err = p.capRawCutoff(cutoff)
+			if err != nil {
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+		}
+		if !ok {
+			results = append(results, result)
+			continue
+		}
+		result.Cutoff = &cutoff
+
+		if dryRun {
+			err = p.db.Table(t.table).Where(t.condition, cutoff).Count(&result.Rows).Error
+		} else {
+			result.Rows, err = p.deleteBatched(t, cutoff)
+			if recordErr := policy.RecordPruneRun(p.db, now, result.Rows); recordErr != nil && err == nil {
+				err = recordErr
+			}
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// capRawCutoff keeps raw samples that have not been rolled up into every
+// resolution yet, so pruning never loses data the rollups still need
+func (p *Pruner) capRawCutoff(cutoff time.Time) (time.Time, bool, error) {
+	for _, r := range models.RollupResolutions {
+		watermark, err := models.GetRollupWatermark(p.db, r.Name)
+		if err != nil {
+			return cutoff, false, err
+		}
+		if watermark.IsZero() {
+			// Never aggregated; keep everything until it has been
+			return cutoff, false, nil
+		}
+		// Every refresh recomputes from the bucket holding watermark minus
+		// the late window, so those buckets still need their raw samples
+		if start := watermark.Add(-rollup.LateWindow()).Truncate(r.Step); start.Before(cutoff) {
+			cutoff = start
+		}
+	}
+	return cutoff, true, nil
+}
+
+// deleteBatched removes expired rows a batch at a time so no single statement
+// holds locks on a large part of the table
+func (p *Pruner) deleteBatched(t target, cutoff time.Time) (int64, error) {
+	query := fmt.Sprintf(`DELETE FROM %[1]s WHERE ctid IN (
+		SELECT ctid FROM %[1]s WHERE %[2]s LIMIT ?)`, t.table, t.condition)
+
+	var total int64
+	for {
+		result := p.db.Exec(query, cutoff, p.batchSize)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(p.batchSize) {
+			return total, nil
+		}
+		time.Sleep(batchPause)
+	}
+}
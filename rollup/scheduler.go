@@ -0,0 +1,185 @@
+package rollup
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultInterval   = time.Minute
+	defaultLateWindow = time.Hour
+)
+
+// tier is one rollup resolution and how it is scheduled
+type tier struct {
+	resolution string
+	step       time.Duration
+	every      time.Duration // how often the tier is refreshed
+	maxSpan    time.Duration // most raw data aggregated per run, so backfills catch up gradually
+}
+
+var tiers = []tier{
+	{models.ResolutionMinute, time.Minute, time.Minute, 6 * time.Hour},
+	{models.ResolutionHour, time.Hour, 5 * time.Minute, 7 * 24 * time.Hour},
+	{models.ResolutionDay, 24 * time.Hour, time.Hour, 30 * 24 * time.Hour},
+}
+
+// Scheduler periodically aggregates raw resource samples into rollups
+type Scheduler struct {
+	db         *gorm.DB
+	interval   time.Duration
+	lateWindow time.Duration
+	lastRun    map[string]time.Time
+}
+
+// Default is the process-wide scheduler, set by Start
+var Default *Scheduler
+
+// Start launches the scheduler in the background. ROLLUP_INTERVAL sets how
+// often it wakes up and ROLLUP_LATE_WINDOW how far back buckets are
+// recomputed to pick up samples that arrive late (Go durations).
+func Start(db *gorm.DB) *Scheduler {
+	s := &Scheduler{
+		db:         db,
+		interval:   utils.EnvDuration("ROLLUP_INTERVAL", defaultInterval),
+		lateWindow: utils.EnvDuration("ROLLUP_LATE_WINDOW", defaultLateWindow),
+		lastRun:    make(map[string]time.Time),
+	}
+	Default = s
+
+	go s.run()
+	return s
+}
+
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(time.Now())
+		<-ticker.C
+	}
+}
+
+// RunDue refreshes every tier whose schedule has come round
+func (s *Scheduler) RunDue(now time.Time) {
+	for _, t := range tiers {
+		if last, ok := s.lastRun[t.resolution]; ok && now.Sub(last) < t.every {
+			continue
+		}
+		if err := s.Refresh(t, now); err != nil {
+			utils.LogError("Failed to roll up %s resource samples: %v", t.resolution, err)
+			continue
+		}
+		s.lastRun[t.resolution] = now
+	}
+}
+
+// Refresh aggregates one tier from its watermark (less the late window) up to
+// now. The current, still-filling bucket is included and recomputed on the
+// next run.
+func (s *Scheduler) Refresh(t tier, now time.Time) error {
+	watermark, err := models.GetRollupWatermark(s.db, t.resolution)
+	if err != nil {
+		return err
+	}
+
+	var from time.Time
+	if watermark.IsZero() {
+		// First run: backfill from the oldest sample
+		var oldest sql.NullTime
+		if err := s.db.Model(&models.ResourceLog{}).Select("min(timestamp)").Row().Scan(&oldest); err != nil {
+			return err
+		}
+		if !oldest.Valid {
+			return nil
+		}
+		from = oldest.Time
+	} else {
+		from = watermark.Add(-s.lateWindow)
+	}
+	from = from.Truncate(t.step)
+
+	// Catch up a bounded amount per run; the watermark only advances to
+	// complete buckets so the open one is revisited
+	to := now
+	caughtUp := true
+	if to.Sub(from) > t.maxSpan {
+		to = from.Add(t.maxSpan)
+		caughtUp = false
+	}
+
+	if err := models.AggregateResourceLogs(s.db, t.resolution, from, to); err != nil {
+		return err
+	}
+
+	through := to.Truncate(t.step)
+	if !caughtUp {
+		through = to
+	}
+	if through.Before(watermark) {
+		return nil
+	}
+	return models.SetRollupWatermark(s.db, t.resolution, through)
+}
+
+// LateWindow is how far behind its watermark each refresh recomputes buckets
+func LateWindow() time.Duration {
+	if Default != nil {
+		return Default.lateWindow
+	}
+	return defaultLateWindow
+}
+
+// MaxSampleAge is how old a sample may be and still be rolled up into every
+// tier when raw samples are kept for retention: the day bucket its refresh
+// starts from, a late window earlier, must not have been pruned yet
+func MaxSampleAge(retention time.Duration) time.Duration {
+	age := retention - tiers[len(tiers)-1].step - LateWindow()
+	if age < LateWindow() {
+		// Samples inside the late window are rolled up without a rewind
+		return LateWindow()
+	}
+	return age
+}
+
+// NoteLateSamples makes sure samples stored with timestamps as old as earliest
+// are rolled up, rewinding the watermarks if they fall before the late window.
+// Tiers whose rebuild would start in a bucket that may already have lost raw
+// samples to pruning are left alone, so a complete rollup is never replaced
+// by a partial one; the late samples are then missing from those tiers.
+func NoteLateSamples(db *gorm.DB, earliest time.Time) error {
+	now := time.Now()
+	if now.Sub(earliest) <= LateWindow() {
+		return nil
+	}
+
+	policy, err := models.GetRetentionPolicy(db, models.RawSamplesPolicy)
+	if err != nil {
+		return err
+	}
+	rawCutoff, _ := policy.Cutoff(now)
+
+	for _, t := range tiers {
+		if !rebuildable(t, earliest, rawCutoff, LateWindow()) {
+			utils.LogWarning("Samples from %s are too old to roll up into %s buckets without losing pruned data; skipping them", earliest.Format(time.RFC3339), t.resolution)
+			continue
+		}
+		if err := models.RewindRollupWatermark(db, t.resolution, earliest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildable reports whether a tier can be rewound to earliest: its next
+// refresh recomputes from the bucket holding earliest less the late window,
+// and every raw sample of that bucket must still be stored. A zero rawCutoff
+// means raw samples are never pruned.
+func rebuildable(t tier, earliest, rawCutoff time.Time, lateWindow time.Duration) bool {
+	return rawCutoff.IsZero() || !earliest.Add(-lateWindow).Truncate(t.step).Before(rawCutoff)
+}
@@ -0,0 +1,69 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRebuildable(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	rawCutoff := now.AddDate(0, 0, -30) // 2026-03-01 12:00, mid-way through a day bucket
+
+	tests := []struct {
+		name      string
+		earliest  time.Time
+		rawCutoff time.Time
+		want      []bool // minute, hour and day tiers
+	}{
+		{
+			name:      "day bucket partly pruned",
+			earliest:  rawCutoff.Add(3 * time.Hour),
+			rawCutoff: rawCutoff,
+			want:      []bool{true, true, false},
+		},
+		{
+			name:      "late window reaches a pruned hour",
+			earliest:  rawCutoff.Add(30 * time.Minute),
+			rawCutoff: rawCutoff,
+			want:      []bool{false, false, false},
+		},
+		{
+			name:      "whole day still stored",
+			earliest:  rawCutoff.Add(13 * time.Hour),
+			rawCutoff: rawCutoff,
+			want:      []bool{true, true, true},
+		},
+		{
+			name:     "raw samples kept forever",
+			earliest: now.AddDate(-1, 0, 0),
+			want:     []bool{true, true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, tier := range tiers {
+				if got := rebuildable(tier, tt.earliest, tt.rawCutoff, time.Hour); got != tt.want[i] {
+					t.Errorf("%s: rebuildable = %v, want %v", tier.resolution, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMaxSampleAgeKeepsDayBucketsStored(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	for _, days := range []int{2, 7, 30, 365} {
+		retention := time.Duration(days) * 24 * time.Hour
+
+		// The oldest sample accepted must not rebuild a day whose raw
+		// samples were partly pruned, whatever time of day it is
+		for hour := 0; hour < 24; hour++ {
+			at := now.Add(time.Duration(hour) * time.Hour)
+			earliest := at.Add(-MaxSampleAge(retention))
+			if !rebuildable(tiers[len(tiers)-1], earliest, at.Add(-retention), LateWindow()) {
+				t.Errorf("%d days at %s: a sample from %s would rebuild a partly pruned day", days, at.Format("15:04"), earliest)
+			}
+		}
+	}
+}
@@ -2,7 +2,8 @@ package routes
 
 import (
 	"github.com/Frhnmj2004/LabMonitoring-server/controllers"
-	//"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
 	"github.com/Frhnmj2004/LabMonitoring-server/websocket"
 	"github.com/gofiber/fiber/v2"
 	fiberwebsocket "github.com/gofiber/websocket/v2"
@@ -17,43 +18,104 @@ func SetupRoutes(app *fiber.App) {
 	api.Post("/system-signup", controllers.SystemSignup)
 	api.Get("/download-collector", controllers.DownloadCollector)
 
-	// Protected routes
-	//protected := api.Use(middleware.AuthMiddleware())
+	// Protected routes: any signed-in user; lab managers only see their labs
+	auth := middleware.AuthMiddleware()
+	admin := middleware.AdminOnly()
+	alertManager := middleware.RequireRole(models.RoleAdmin, models.RoleLabManager)
 
-	// Admin-only routes
-	//admin := protected.Use(middleware.AdminOnly())
-	api.Post("/signup", controllers.Signup)
+	// Session routes
+	api.Post("/auth/refresh", controllers.Refresh)
+	api.Post("/auth/logout", auth, controllers.Logout)
+	api.Post("/auth/logout-all", auth, controllers.LogoutAll)
+
+	// Account creation (admin only, except for the very first user)
+	api.Post("/signup", middleware.AdminOrBootstrap(), controllers.Signup)
+
+	// User management routes (admin only)
+	userGroup := api.Group("/users", auth, admin)
+	userGroup.Get("/", controllers.GetUsers)
+	userGroup.Put("/:id/labs", controllers.SetUserLabs)
+	userGroup.Post("/:id/logout", controllers.LogoutUser)
+
+	// Collector ingestion routes (device token required)
+	device := middleware.DeviceAuth()
 
 	// Resource routes
-	api.Post("/resource", controllers.PostResource)
-	api.Get("/resources/history", controllers.GetHistory)
+	api.Post("/resource", device, controllers.PostResource)
+	api.Post("/resources/batch", middleware.DeviceOrAdminAuth(), controllers.PostResourceBatch)
+	api.Get("/ingest/stats", auth, admin, controllers.GetIngestStats)
+	api.Get("/websocket/stats", auth, admin, controllers.GetWebSocketStats)
+	api.Get("/resources/history", auth, controllers.GetHistory)
 
-	// Computer management routes (admin only)
-	api.Get("/computers", controllers.GetAllComputers)
+	// Computer routes
+	api.Get("/computers", auth, controllers.GetAllComputers)
+	api.Patch("/computers/:id", auth, alertManager, controllers.UpdateComputer)
+	api.Delete("/computers/:id", auth, admin, controllers.DeleteComputer)
+	api.Get("/computers/:id/uptime", auth, controllers.GetComputerUptime)
+	api.Get("/computers/:id/processes", auth, controllers.GetComputerProcesses)
+	api.Post("/computers/:id/inventory", device, controllers.PostInventory)
+	api.Get("/computers/:id/inventory", auth, controllers.GetComputerInventory)
+	api.Get("/computers/:id/inventory/history", auth, controllers.GetInventoryHistory)
+	api.Post("/computers/device-tokens/issue", auth, admin, controllers.IssueMissingDeviceTokens)
+	api.Post("/computers/:id/device-token/rotate", auth, admin, controllers.RotateDeviceToken)
+	api.Delete("/computers/:id/device-token", auth, admin, controllers.RevokeDeviceToken)
+	api.Get("/computers/:id/config", middleware.DeviceOrUserAuth(), controllers.GetCollectorConfig)
+	api.Put("/computers/:id/config", auth, admin, controllers.UpdateCollectorConfig)
 
-	// Alert routes (admin only)
-	alertGroup := api.Group("/alerts")
+	// Alert routes (viewers are read-only)
+	alertGroup := api.Group("/alerts", auth)
 	alertGroup.Get("/", controllers.GetAlerts)
 	alertGroup.Get("/active", controllers.GetActiveAlerts)
 	alertGroup.Get("/history", controllers.GetAlertHistory)
 	alertGroup.Get("/stats", controllers.GetAlertStats)
-	alertGroup.Put("/:id/resolve", controllers.ResolveAlert)
+	alertGroup.Put("/:id/acknowledge", alertManager, controllers.AcknowledgeAlert)
+	alertGroup.Put("/:id/resolve", alertManager, controllers.ResolveAlert)
+
+	// Alert rule routes (changes are admin only)
+	ruleGroup := api.Group("/alert-rules", auth)
+	ruleGroup.Get("/", controllers.GetAlertRules)
+	ruleGroup.Post("/", admin, controllers.CreateAlertRule)
+	ruleGroup.Get("/:id", controllers.GetAlertRule)
+	ruleGroup.Put("/:id", admin, controllers.UpdateAlertRule)
+	ruleGroup.Delete("/:id", admin, controllers.DeleteAlertRule)
+
+	// College routes (changes are admin only)
+	collegeGroup := api.Group("/colleges", auth)
+	collegeGroup.Get("/", controllers.GetColleges)
+	collegeGroup.Post("/", admin, controllers.CreateCollege)
+	collegeGroup.Get("/:id", controllers.GetCollege)
+	collegeGroup.Put("/:id", admin, controllers.UpdateCollege)
+	collegeGroup.Delete("/:id", admin, controllers.DeleteCollege)
+
+	// Lab routes (changes are admin only)
+	labGroup := api.Group("/labs", auth)
+	labGroup.Get("/", controllers.GetLabs)
+	labGroup.Post("/", admin, controllers.CreateLab)
+	labGroup.Get("/:id", controllers.GetLab)
+	labGroup.Put("/:id", admin, controllers.UpdateLab)
+	labGroup.Delete("/:id", admin, controllers.DeleteLab)
+	labGroup.Get("/:id/computers", controllers.GetLabComputers)
+	labGroup.Get("/:id/summary", controllers.GetLabSummary)
+
+	// Data retention routes (admin only)
+	retentionGroup := api.Group("/retention", auth, admin)
+	retentionGroup.Get("/", controllers.GetRetentionPolicies)
+	retentionGroup.Put("/:target", controllers.UpdateRetentionPolicy)
+	retentionGroup.Post("/run", controllers.RunRetention)
 
 	// Internet usage routes
-	api.Post("/internet-usage", controllers.PostInternetUsage)
-	api.Get("/internet-usage", controllers.GetInternetUsage)
-
-	// WebSocket setup
-	app.Use("/ws", func(c *fiber.Ctx) error {
-		if fiberwebsocket.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
+	api.Post("/internet-usage", device, controllers.PostInternetUsage)
+	api.Get("/internet-usage", auth, controllers.GetInternetUsage)
+
+	// WebSocket setup; tokens given on the URL or in a header are checked here
+	app.Use("/ws", websocket.CheckUpgrade)
 
 	// WebSocket endpoint for real-time resource updates
 	app.Get("/ws/resources", fiberwebsocket.New(websocket.HandleWebSocket, fiberwebsocket.Config{
 		EnableCompression: true,
 	}))
+
+	// The same events as server-sent events, for clients that can't use
+	// websockets; authenticates by header or ?token=
+	api.Get("/stream", websocket.HandleStream)
 }
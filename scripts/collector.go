@@ -8,6 +8,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	//"runtime"
 	"sync"
 	"time"
@@ -21,66 +24,181 @@ import (
 )
 
 const (
-	serverURL      = "http://localhost:8080/api/v1" // Change this to your server URL in production
-	submitPath     = "/resource"
-	dnsSubmitPath  = "/internet-usage"
-	retryInterval  = 5 * time.Second
-	updateInterval = 10 * time.Second
-	snapLen        = 1600
-	promiscuous    = false
-	timeout        = pcap.BlockForever
+	batchPath     = "/resources/batch"
+	dnsSubmitPath = "/internet-usage"
+	sendBatchSize = 500 // samples per batch upload
+	snapLen       = 1600
+	promiscuous   = false
+	timeout       = pcap.BlockForever
+)
+
+var (
+	// serverURL is the API base URL, e.g. "https://monitor.example.edu/api/v1"
+	serverURL string
+
+	// deviceToken authenticates every submission to the server
+	deviceToken string
+
+	// client sends every request to the server
+	client *http.Client
 )
 
 type ResourceData struct {
-	ComputerID    string  `json:"computer_id"`
-	CPU         float64 `json:"cpu"`
-	Memory      float64 `json:"memory"`
-	NetworkIn   float64 `json:"network_in"`
-	NetworkOut  float64 `json:"network_out"`
+	ComputerID string             `json:"computer_id"`
+	CPU        float64            `json:"cpu"`
+	Memory     float64            `json:"memory"`
+	NetworkIn  float64            `json:"network_in"`
+	NetworkOut float64            `json:"network_out"`
+	Timestamp  time.Time          `json:"timestamp"`           // capture time, kept while queued offline
+	Seq        int64              `json:"seq"`                 // lets the server drop samples it already has
+	Metrics    map[string]float64 `json:"metrics,omitempty"`   // extended host metrics, see hostMetrics
+	Users      []string           `json:"users,omitempty"`     // logged-in users
+	Processes  []ProcessInfo      `json:"processes,omitempty"` // top processes, every process interval
 }
 
 type DNSData struct {
-	ComputerID  string    `json:"computer_id"`
-	Domain    string    `json:"domain"`
-	Timestamp time.Time `json:"timestamp"`
+	ComputerID string    `json:"computer_id"`
+	Domain     string    `json:"domain"`
+	Timestamp  time.Time `json:"timestamp"`
 }
 
 func main() {
-	// Parse command line arguments
+	// Parse command line arguments; flags override the config file
+	configPath := flag.String("config", "collector.json", "Path to the JSON config file")
 	computerID := flag.String("systemID", "", "System ID for this computer")
+	token := flag.String("token", os.Getenv("LAB_DEVICE_TOKEN"), "Device token issued at system signup")
+	server := flag.String("server", "", "Server API base URL")
+	outboxDir := flag.String("outboxDir", "", "Directory for submissions waiting to reach the server")
+	outboxMaxMB := flag.Int64("outboxMaxMB", 0, "Disk space each outbox may use before the oldest data is dropped")
 	flag.Parse()
 
-	if *computerID == "" {
-		log.Fatal("System ID is required. Use --systemID flag")
+	configSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configSet = true
+		}
+	})
+	cfg, err := loadConfig(*configPath, configSet)
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
+	}
+	if *computerID != "" {
+		cfg.SystemID = *computerID
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+	if *server != "" {
+		cfg.ServerURL = strings.TrimRight(*server, "/")
+	}
+	if *outboxDir != "" {
+		cfg.OutboxDir = *outboxDir
+	}
+	if *outboxMaxMB > 0 {
+		cfg.OutboxMaxMB = *outboxMaxMB
+	}
+
+	if cfg.SystemID == "" {
+		log.Fatal("System ID is required. Use --systemID flag or system_id in the config file")
+	}
+	if cfg.Token == "" {
+		log.Fatal("Device token is required. Use --token flag, LAB_DEVICE_TOKEN or token in the config file")
+	}
+	serverURL = cfg.ServerURL
+	deviceToken = cfg.Token
+	if client, err = cfg.httpClient(); err != nil {
+		log.Fatal("Failed to set up TLS: ", err)
 	}
 
 	// Display privacy notice
 	fmt.Println("NOTICE: This system monitors resource and internet usage for lab management purposes.")
-	fmt.Printf("System ID: %s\n", *computerID)
+	fmt.Printf("System ID: %s\n", cfg.SystemID)
 	fmt.Println("Press Ctrl+C to stop monitoring.")
 
 	// Create log file
 	logFile := setupLogging()
 	defer logFile.Close()
 
+	// Everything is queued on disk first, then sent in order as the server allows
+	resources, err := openOutbox(cfg.OutboxDir, "resources", cfg.OutboxMaxMB<<20)
+	if err != nil {
+		log.Fatal("Failed to open resource outbox:", err)
+	}
+	dnsQueries, err := openOutbox(cfg.OutboxDir, "dns", cfg.OutboxMaxMB<<20)
+	if err != nil {
+		log.Fatal("Failed to open DNS outbox:", err)
+	}
+	inventory, err := openOutbox(cfg.OutboxDir, "inventory", cfg.OutboxMaxMB<<20)
+	if err != nil {
+		log.Fatal("Failed to open inventory outbox:", err)
+	}
+	go drain(resources, sendBatchSize, submitData)
+	go drain(dnsQueries, 1, submitDNSData)
+	go drain(inventory, 1, func(records []json.RawMessage) error {
+		return postJSON("/computers/"+cfg.SystemID+"/inventory", records[0])
+	})
+
+	// Admins can change intervals, toggle DNS capture or pause this machine
+	current := newSettings(cfg)
+	go pollConfig(cfg.SystemID, current)
+
+	if cfg.ProbeEnabled(probeInventory) {
+		go reportInventory(inventory, time.Duration(cfg.InventoryInterval), current)
+	}
+
 	// Start DNS monitoring in a separate goroutine
-	go monitorDNS(*computerID)
+	if cfg.ProbeEnabled(probeDNS) {
+		go monitorDNS(cfg.SystemID, dnsQueries, current)
+	}
 
+	if cfg.ProbeEnabled(probeResources) {
+		seq := openSequence(filepath.Join(cfg.OutboxDir, "resources.seq"))
+		monitorResources(cfg.SystemID, resources, seq, current, cfg)
+	}
+	select {}
+}
+
+// monitorResources samples CPU, memory and network usage every sample
+// interval, plus the extended host metrics and top processes if enabled
+func monitorResources(computerID string, queue *outbox, seq *sequence, current *settings, cfg Config) {
 	// Previous network stats for calculating rate
 	var prevNetStats []net.IOCountersStat
+	var prevNetTime time.Time
+	counters := newRates()
+	extended := cfg.ProbeEnabled(probeMetrics)
+
+	var processes *processTracker
+	var lastProcesses time.Time
+	if cfg.ProbeEnabled(probeProcesses) {
+		processes = newProcessTracker()
+		// The first scan only primes the CPU counters
+		processes.top(cfg.ProcessTopN)
+	}
 
-	// Main monitoring loop for system resources
 	for {
+		if current.Paused() {
+			prevNetStats = nil
+			counters.reset()
+			time.Sleep(current.SampleInterval())
+			continue
+		}
+
 		data := ResourceData{
-			ComputerID: *computerID,
+			ComputerID: computerID,
+			Timestamp:  time.Now().UTC(),
+			Seq:        seq.Next(),
 		}
 
-		// Get CPU usage
-		cpuPercent, err := cpu.Percent(time.Second, false)
+		// Get CPU usage; the overall figure is the mean of the cores
+		perCore, err := cpu.Percent(time.Second, true)
 		if err != nil {
 			log.Printf("Error getting CPU usage: %v", err)
-		} else if len(cpuPercent) > 0 {
-			data.CPU = cpuPercent[0]
+		} else if len(perCore) > 0 {
+			var total float64
+			for _, percent := range perCore {
+				total += percent
+			}
+			data.CPU = total / float64(len(perCore))
 		}
 
 		// Get memory usage
@@ -96,27 +214,65 @@ func main() {
 		if err != nil {
 			log.Printf("Error getting network stats: %v", err)
 		} else if len(netStats) > 0 {
+			now := time.Now()
 			if prevNetStats != nil {
 				// Calculate network rate (bytes per second)
-				timeDiff := updateInterval.Seconds()
+				timeDiff := now.Sub(prevNetTime).Seconds()
 				data.NetworkIn = float64(netStats[0].BytesRecv-prevNetStats[0].BytesRecv) / timeDiff
 				data.NetworkOut = float64(netStats[0].BytesSent-prevNetStats[0].BytesSent) / timeDiff
 			}
 			prevNetStats = netStats
+			prevNetTime = now
 		}
 
-		// Submit data to server
-		if err := submitData(data); err != nil {
-			log.Printf("Error submitting data: %v", err)
-			time.Sleep(retryInterval)
-			continue
+		if extended {
+			data.Metrics, data.Users = hostMetrics(perCore, counters, time.Now())
+		}
+
+		if processes != nil && time.Since(lastProcesses) >= time.Duration(cfg.ProcessInterval) {
+			data.Processes = processes.top(cfg.ProcessTopN)
+			lastProcesses = time.Now()
+		}
+
+		// Queue data for the server
+		if err := queue.Push(data); err != nil {
+			log.Printf("Error queueing data: %v", err)
 		}
 
-		time.Sleep(updateInterval)
+		time.Sleep(current.SampleInterval())
 	}
 }
 
-func monitorDNS(computerID string) {
+// sequence numbers samples; the next number is saved so numbering continues
+// after a restart
+type sequence struct {
+	path string
+	next int64
+}
+
+func openSequence(path string) *sequence {
+	// Without a saved number, start from the clock so a reinstalled collector
+	// doesn't reuse numbers the server has already stored
+	s := &sequence{path: path, next: time.Now().UnixMicro()}
+	if data, err := os.ReadFile(path); err == nil {
+		if next, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			s.next = next
+		}
+	}
+	return s
+}
+
+// Next returns the next sequence number
+func (s *sequence) Next() int64 {
+	n := s.next
+	s.next++
+	if err := os.WriteFile(s.path, []byte(strconv.FormatInt(s.next, 10)), 0644); err != nil {
+		log.Printf("Error saving sequence number: %v", err)
+	}
+	return n
+}
+
+func monitorDNS(computerID string, queue *outbox, current *settings) {
 	// Find all network devices
 	devices, err := pcap.FindAllDevs()
 	if err != nil {
@@ -158,16 +314,16 @@ func monitorDNS(computerID string) {
 				if !dns.QR { // Only process DNS queries, not responses
 					for _, question := range dns.Questions {
 						domain := string(question.Name)
-						if domain != "" {
+						if domain != "" && current.CaptureDNS() {
 							dnsData := DNSData{
-								ComputerID:  computerID,
-								Domain:    domain,
-								Timestamp: time.Now(),
+								ComputerID: computerID,
+								Domain:     domain,
+								Timestamp:  time.Now().UTC(),
 							}
-							
-							// Submit DNS data to server
-							if err := submitDNSData(dnsData); err != nil {
-								log.Printf("Error submitting DNS data: %v", err)
+
+							// Queue DNS data for the server
+							if err := queue.Push(dnsData); err != nil {
+								log.Printf("Error queueing DNS data: %v", err)
 							}
 						}
 					}
@@ -179,42 +335,43 @@ func monitorDNS(computerID string) {
 	wg.Wait()
 }
 
-func submitData(data ResourceData) error {
-	jsonData, err := json.Marshal(data)
+// submitData uploads queued samples in one batch; their capture timestamps
+// are sent along so late samples land at the right time
+func submitData(records []json.RawMessage) error {
+	jsonData, err := json.Marshal(records)
 	if err != nil {
 		return fmt.Errorf("error marshaling data: %v", err)
 	}
 
-	resp, err := http.Post(serverURL+submitPath, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
+	return postJSON(batchPath, jsonData)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+// submitDNSData sends one queued DNS query; the server has no batch endpoint for them
+func submitDNSData(records []json.RawMessage) error {
+	for _, record := range records {
+		if err := postJSON(dnsSubmitPath, record); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
-func submitDNSData(data DNSData) error {
-	jsonData, err := json.Marshal(data)
+// postJSON sends an authenticated submission to the server
+func postJSON(path string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, serverURL+path, bytes.NewBuffer(body))
 	if err != nil {
-		return fmt.Errorf("error marshaling DNS data: %v", err)
+		return fmt.Errorf("error creating request: %v", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+deviceToken)
 
-	resp, err := http.Post(serverURL+dnsSubmitPath, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending DNS data: %v", err)
+		return fmt.Errorf("error sending request: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %d", resp.StatusCode)
-	}
-
-	return nil
+	return checkResponse(resp)
 }
 
 func setupLogging() *os.File {
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probes the collector can run; the config file's "probes" list enables them
+const (
+	probeResources = "resources"
+	probeMetrics   = "metrics" // extended host metrics sent with each resource sample
+	probeDNS       = "dns"
+	probeProcesses = "processes" // top processes sent with a resource sample every process interval
+	probeInventory = "inventory" // hardware and software, on startup and every inventory interval
+)
+
+// maxProcessTopN keeps the merged CPU and memory lists within the server's
+// limit of 100 processes per sample
+const maxProcessTopN = 50
+
+// duration is a time.Duration written as a string such as "10s" in JSON
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// Config is the collector's local configuration, read from a JSON file.
+// Command line flags override it.
+type Config struct {
+	ServerURL      string   `json:"server_url"`
+	SystemID       string   `json:"system_id"`
+	Token          string   `json:"token"`
+	SampleInterval duration `json:"sample_interval"`      // until the server says otherwise
+	PollInterval   duration `json:"config_poll_interval"` // until the server says otherwise
+	Probes         []string `json:"probes"`
+	OutboxDir      string   `json:"outbox_dir"`
+	OutboxMaxMB    int64    `json:"outbox_max_mb"`
+	TLSCAFile      string   `json:"tls_ca_file"` // PEM bundle to trust in addition to the system roots
+
+	ProcessInterval duration `json:"process_interval"` // how often the top processes are reported
+	ProcessTopN     int      `json:"process_top_n"`    // processes reported by CPU and by memory
+
+	InventoryInterval duration `json:"inventory_interval"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		ServerURL:      "http://localhost:8080/api/v1",
+		SampleInterval: duration(10 * time.Second),
+		PollInterval:   duration(time.Minute),
+		Probes:         []string{probeResources, probeMetrics, probeDNS, probeProcesses, probeInventory},
+		OutboxDir:      "outbox",
+		OutboxMaxMB:    100,
+
+		ProcessInterval: duration(time.Minute),
+		ProcessTopN:     10,
+
+		InventoryInterval: duration(24 * time.Hour),
+	}
+}
+
+// loadConfig reads the config file over the defaults. A missing file is only
+// an error if it was asked for explicitly.
+func loadConfig(path string, required bool) (Config, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	cfg.ServerURL = strings.TrimRight(cfg.ServerURL, "/")
+	if cfg.ProcessTopN < 1 || cfg.ProcessTopN > maxProcessTopN {
+		return cfg, fmt.Errorf("process_top_n must be between 1 and %d", maxProcessTopN)
+	}
+	return cfg, nil
+}
+
+// ProbeEnabled reports whether the config file enables a probe
+func (cfg *Config) ProbeEnabled(name string) bool {
+	for _, probe := range cfg.Probes {
+		if probe == name {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClient returns the client used for every request to the server
+func (cfg *Config) httpClient() (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.TLSCAFile == "" {
+		return client, nil
+	}
+
+	pem, err := os.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+	}
+
+	client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}
+	return client, nil
+}
+
+// RemoteConfig is what GET /computers/:id/config returns. Unset intervals
+// mean the server has no override for them.
+type RemoteConfig struct {
+	SampleIntervalSeconds *int `json:"sample_interval_seconds"`
+	PollIntervalSeconds   *int `json:"poll_interval_seconds"`
+	DNSCapture            bool `json:"dns_capture"`
+	Paused                bool `json:"paused"`
+}
+
+// settings are the values the server can change while the collector runs
+type settings struct {
+	mu             sync.RWMutex
+	sampleInterval time.Duration
+	pollInterval   time.Duration
+	dnsCapture     bool
+	paused         bool
+
+	// the config file's intervals, used whenever the server sets none
+	localSample time.Duration
+	localPoll   time.Duration
+}
+
+func newSettings(cfg Config) *settings {
+	return &settings{
+		sampleInterval: time.Duration(cfg.SampleInterval),
+		pollInterval:   time.Duration(cfg.PollInterval),
+		dnsCapture:     true,
+		localSample:    time.Duration(cfg.SampleInterval),
+		localPoll:      time.Duration(cfg.PollInterval),
+	}
+}
+
+func (s *settings) SampleInterval() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sampleInterval
+}
+
+func (s *settings) PollInterval() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pollInterval
+}
+
+// CaptureDNS reports whether DNS queries should be queued right now
+func (s *settings) CaptureDNS() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dnsCapture && !s.paused
+}
+
+func (s *settings) Paused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paused
+}
+
+// apply takes over the server's settings, logging what changed
+func (s *settings) apply(remote RemoteConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := remoteInterval(remote.SampleIntervalSeconds, s.localSample)
+	poll := remoteInterval(remote.PollIntervalSeconds, s.localPoll)
+	if sample != s.sampleInterval {
+		log.Printf("Sample interval changed to %s", sample)
+		s.sampleInterval = sample
+	}
+	if poll != s.pollInterval {
+		log.Printf("Config poll interval changed to %s", poll)
+		s.pollInterval = poll
+	}
+	if remote.DNSCapture != s.dnsCapture {
+		log.Printf("DNS capture enabled: %v", remote.DNSCapture)
+		s.dnsCapture = remote.DNSCapture
+	}
+	if remote.Paused != s.paused {
+		log.Printf("Monitoring paused: %v", remote.Paused)
+		s.paused = remote.Paused
+	}
+}
+
+// remoteInterval returns the server's interval, or the local one if the
+// server sets none
+func remoteInterval(seconds *int, local time.Duration) time.Duration {
+	if seconds == nil || *seconds <= 0 {
+		return local
+	}
+	return time.Duration(*seconds) * time.Second
+}
+
+// pollConfig keeps the settings in line with the server's config for this computer
+func pollConfig(computerID string, current *settings) {
+	for {
+		remote, err := fetchConfig(computerID)
+		if err != nil {
+			log.Printf("Error fetching config: %v", err)
+		} else {
+			current.apply(remote)
+		}
+		time.Sleep(current.PollInterval())
+	}
+}
+
+func fetchConfig(computerID string) (RemoteConfig, error) {
+	var body struct {
+		Data RemoteConfig `json:"data"`
+	}
+
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/computers/"+computerID+"/config", nil)
+	if err != nil {
+		return body.Data, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+deviceToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return body.Data, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return body.Data, err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return body.Data, fmt.Errorf("invalid config response: %v", err)
+	}
+	return body.Data, nil
+}
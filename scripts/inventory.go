@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+type Disk struct {
+	Device     string `json:"device"`
+	Mountpoint string `json:"mountpoint"`
+	Fstype     string `json:"fstype"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+type NetworkInterface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac"`
+	Addresses []string `json:"addresses"`
+}
+
+type Software struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Inventory is the hardware and software of this computer, sent on startup
+// and every inventory interval
+type Inventory struct {
+	Hostname      string             `json:"hostname"`
+	OS            string             `json:"os"`
+	OSVersion     string             `json:"os_version"`
+	KernelVersion string             `json:"kernel_version"`
+	CPUModel      string             `json:"cpu_model"`
+	CPUCores      int                `json:"cpu_cores"`
+	MemoryBytes   uint64             `json:"memory_bytes"`
+	Disks         []Disk             `json:"disks"`
+	Interfaces    []NetworkInterface `json:"interfaces"`
+	Software      []Software         `json:"software"`
+	CollectedAt   time.Time          `json:"collected_at"`
+}
+
+// reportInventory queues the inventory now and then every interval
+func reportInventory(queue *outbox, interval time.Duration, current *settings) {
+	for {
+		if !current.Paused() {
+			if err := queue.Push(collectInventory()); err != nil {
+				log.Printf("Error queueing inventory: %v", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// collectInventory gathers what it can; anything unsupported on this
+// platform is left empty
+func collectInventory() Inventory {
+	inv := Inventory{CollectedAt: time.Now().UTC()}
+
+	if info, err := host.Info(); err == nil {
+		inv.Hostname = info.Hostname
+		inv.OS = info.Platform
+		inv.OSVersion = info.PlatformVersion
+		inv.KernelVersion = info.KernelVersion
+	} else {
+		log.Printf("Error getting host info: %v", err)
+	}
+
+	if infos, err := cpu.Info(); err == nil && len(infos) > 0 {
+		inv.CPUModel = strings.TrimSpace(infos[0].ModelName)
+	}
+	if cores, err := cpu.Counts(true); err == nil {
+		inv.CPUCores = cores
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		inv.MemoryBytes = vm.Total
+	}
+
+	if partitions, err := disk.Partitions(false); err == nil {
+		for _, p := range partitions {
+			usage, err := disk.Usage(p.Mountpoint)
+			if err != nil || usage.Total == 0 {
+				continue
+			}
+			inv.Disks = append(inv.Disks, Disk{
+				Device:     p.Device,
+				Mountpoint: p.Mountpoint,
+				Fstype:     p.Fstype,
+				TotalBytes: usage.Total,
+			})
+		}
+	}
+
+	if interfaces, err := net.Interfaces(); err == nil {
+		for _, iface := range interfaces {
+			if iface.HardwareAddr == "" || hasFlag(iface.Flags, "loopback") {
+				continue
+			}
+			n := NetworkInterface{Name: iface.Name, MAC: iface.HardwareAddr, Addresses: []string{}}
+			for _, addr := range iface.Addrs {
+				n.Addresses = append(n.Addresses, addr.Addr)
+			}
+			inv.Interfaces = append(inv.Interfaces, n)
+		}
+	}
+
+	software, err := installedSoftware()
+	if err != nil {
+		log.Printf("Error listing installed software: %v", err)
+	}
+	inv.Software = software
+
+	return inv
+}
+
+func hasFlag(flags []string, name string) bool {
+	for _, flag := range flags {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
+
+// installedSoftware lists installed packages with the platform's package
+// manager, or the uninstall registry keys on Windows
+func installedSoftware() ([]Software, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return listSoftware("powershell", "-NoProfile", "-Command",
+			`Get-ItemProperty HKLM:\Software\Microsoft\Windows\CurrentVersion\Uninstall\*, `+
+				`HKLM:\Software\Wow6432Node\Microsoft\Windows\CurrentVersion\Uninstall\* -ErrorAction SilentlyContinue | `+
+				`Where-Object DisplayName | ForEach-Object { $_.DisplayName + "`+"`t"+`" + $_.DisplayVersion }`)
+	case "darwin":
+		return listSoftware("sh", "-c", `for app in /Applications/*.app; do `+
+			`printf '%s\t%s\n' "$(basename "$app" .app)" "$(defaults read "$app/Contents/Info" CFBundleShortVersionString 2>/dev/null)"; done`)
+	}
+
+	if _, err := exec.LookPath("dpkg-query"); err == nil {
+		return listSoftware("dpkg-query", "-W", "-f", `${Package}\t${Version}\n`)
+	}
+	if _, err := exec.LookPath("rpm"); err == nil {
+		return listSoftware("rpm", "-qa", "--qf", `%{NAME}\t%{VERSION}-%{RELEASE}\n`)
+	}
+	return nil, nil
+}
+
+// listSoftware runs a command printing one "name<TAB>version" line per package
+func listSoftware(name string, args ...string) ([]Software, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var software []Software
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimRight(scanner.Text(), "\r"), "\t", 2)
+		if strings.TrimSpace(fields[0]) == "" {
+			continue
+		}
+		s := Software{Name: strings.TrimSpace(fields[0])}
+		if len(fields) == 2 {
+			s.Version = strings.TrimSpace(fields[1])
+		}
+		software = append(software, s)
+	}
+	return software, scanner.Err()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// counter is the previous reading of a monotonically increasing counter
+type counter struct {
+	value uint64
+	at    time.Time
+}
+
+// rates turns counters such as bytes read into per-second rates between
+// consecutive samples
+type rates struct {
+	prev map[string]counter
+}
+
+func newRates() *rates {
+	return &rates{prev: make(map[string]counter)}
+}
+
+// rate records a counter reading and returns its rate since the previous one.
+// There is none for the first reading or after the counter was reset.
+func (r *rates) rate(name string, value uint64, now time.Time) (float64, bool) {
+	prev, ok := r.prev[name]
+	r.prev[name] = counter{value: value, at: now}
+	if !ok || value < prev.value {
+		return 0, false
+	}
+	elapsed := now.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return float64(value-prev.value) / elapsed, true
+}
+
+// reset forgets every reading, e.g. after sampling was paused
+func (r *rates) reset() {
+	r.prev = make(map[string]counter)
+}
+
+// hostMetrics collects the extended metrics sent with each sample. Probes
+// that aren't supported on this platform are skipped.
+func hostMetrics(perCore []float64, counters *rates, now time.Time) (map[string]float64, []string) {
+	metrics := make(map[string]float64)
+
+	for i, percent := range perCore {
+		metrics[fmt.Sprintf("cpu.core.%d", i)] = percent
+	}
+
+	if avg, err := load.Avg(); err == nil {
+		metrics["load.1"] = avg.Load1
+		metrics["load.5"] = avg.Load5
+		metrics["load.15"] = avg.Load15
+	}
+
+	if vm, err := mem.VirtualMemory(); err == nil {
+		metrics["mem.total_bytes"] = float64(vm.Total)
+		metrics["mem.used_bytes"] = float64(vm.Used)
+		metrics["mem.available_bytes"] = float64(vm.Available)
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		metrics["swap.total_bytes"] = float64(swap.Total)
+		metrics["swap.used_bytes"] = float64(swap.Used)
+		metrics["swap.used_percent"] = swap.UsedPercent
+	}
+
+	if partitions, err := disk.Partitions(false); err == nil {
+		for _, p := range partitions {
+			usage, err := disk.Usage(p.Mountpoint)
+			if err != nil || usage.Total == 0 {
+				continue
+			}
+			prefix := "disk." + p.Mountpoint
+			metrics[prefix+".total_bytes"] = float64(usage.Total)
+			metrics[prefix+".free_bytes"] = float64(usage.Free)
+			metrics[prefix+".used_percent"] = usage.UsedPercent
+		}
+	}
+
+	if io, err := disk.IOCounters(); err == nil {
+		for name, c := range io {
+			prefix := "diskio." + name
+			if v, ok := counters.rate(prefix+".read_bytes", c.ReadBytes, now); ok {
+				metrics[prefix+".read_bytes_per_sec"] = v
+			}
+			if v, ok := counters.rate(prefix+".write_bytes", c.WriteBytes, now); ok {
+				metrics[prefix+".write_bytes_per_sec"] = v
+			}
+			if v, ok := counters.rate(prefix+".read_count", c.ReadCount, now); ok {
+				metrics[prefix+".reads_per_sec"] = v
+			}
+			if v, ok := counters.rate(prefix+".write_count", c.WriteCount, now); ok {
+				metrics[prefix+".writes_per_sec"] = v
+			}
+		}
+	}
+
+	if interfaces, err := net.IOCounters(true); err == nil {
+		for _, c := range interfaces {
+			prefix := "net." + c.Name
+			if v, ok := counters.rate(prefix+".bytes_recv", c.BytesRecv, now); ok {
+				metrics[prefix+".bytes_recv_per_sec"] = v
+			}
+			if v, ok := counters.rate(prefix+".bytes_sent", c.BytesSent, now); ok {
+				metrics[prefix+".bytes_sent_per_sec"] = v
+			}
+			if v, ok := counters.rate(prefix+".errors", c.Errin+c.Errout, now); ok {
+				metrics[prefix+".errors_per_sec"] = v
+			}
+			if v, ok := counters.rate(prefix+".drops", c.Dropin+c.Dropout, now); ok {
+				metrics[prefix+".drops_per_sec"] = v
+			}
+		}
+	}
+
+	if uptime, err := host.Uptime(); err == nil {
+		metrics["host.uptime_seconds"] = float64(uptime)
+	}
+
+	var users []string
+	if sessions, err := host.Users(); err == nil {
+		seen := make(map[string]bool)
+		for _, s := range sessions {
+			name := strings.TrimSpace(s.User)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				users = append(users, name)
+			}
+		}
+		sort.Strings(users)
+		metrics["host.users"] = float64(len(users))
+	}
+
+	return metrics, users
+}
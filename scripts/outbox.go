@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentBytes = 1 << 20 // size of each outbox segment file
+	minBackoff   = time.Second
+	maxBackoff   = 5 * time.Minute
+)
+
+// cursor is a position in an outbox: the next unsent byte of a segment
+type cursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// outbox is an on-disk FIFO of submissions that haven't reached the server
+// yet. Records are JSON lines in numbered segment files; the read position is
+// saved after every acknowledged send so a restart resumes where it left off.
+// When the outbox grows past maxBytes the oldest segments are dropped.
+type outbox struct {
+	dir      string
+	name     string
+	maxBytes int64
+
+	mu       sync.Mutex
+	sizes    map[uint64]int64
+	writer   *os.File
+	writeSeq uint64
+	read     cursor
+	notify   chan struct{}
+}
+
+func openOutbox(dir, name string, maxBytes int64) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &outbox{
+		dir:      dir,
+		name:     name,
+		maxBytes: maxBytes,
+		sizes:    make(map[uint64]int64),
+		notify:   make(chan struct{}, 1),
+	}
+
+	if data, err := os.ReadFile(q.cursorPath()); err == nil {
+		if err := json.Unmarshal(data, &q.read); err != nil {
+			log.Printf("Ignoring invalid %s outbox position: %v", name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		seq, ok := q.parseSegment(entry.Name())
+		if !ok {
+			continue
+		}
+		if seq < q.read.Segment {
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.sizes[seq] = info.Size()
+		if seq > q.writeSeq {
+			q.writeSeq = seq
+		}
+	}
+
+	if len(q.sizes) == 0 {
+		// Everything was sent; continue numbering after the last segment
+		q.writeSeq = q.read.Segment
+	} else {
+		if first := q.segments()[0]; first > q.read.Segment {
+			q.read = cursor{Segment: first}
+		}
+		if err := q.repairTail(); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (q *outbox) parseSegment(name string) (uint64, bool) {
+	prefix := q.name + "-"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".jsonl") {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".jsonl"), 10, 64)
+	return seq, err == nil
+}
+
+func (q *outbox) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%s-%010d.jsonl", q.name, seq))
+}
+
+func (q *outbox) cursorPath() string {
+	return filepath.Join(q.dir, q.name+".pos")
+}
+
+// segments returns the segment numbers in order; the caller holds q.mu
+func (q *outbox) segments() []uint64 {
+	seqs := make([]uint64, 0, len(q.sizes))
+	for seq := range q.sizes {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// repairTail drops a half-written last line left by a crash
+func (q *outbox) repairTail() error {
+	path := q.segmentPath(q.writeSeq)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	valid := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if valid == int64(len(data)) {
+		return nil
+	}
+	log.Printf("Dropping %d bytes of a partial record at the end of %s", int64(len(data))-valid, path)
+	q.sizes[q.writeSeq] = valid
+	return os.Truncate(path, valid)
+}
+
+// Push appends a record to the outbox
+func (q *outbox) Push(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.writer == nil || q.sizes[q.writeSeq]+int64(len(line)) > segmentBytes {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := q.writer.Write(line); err != nil {
+		q.writer.Truncate(q.sizes[q.writeSeq])
+		return err
+	}
+	q.sizes[q.writeSeq] += int64(len(line))
+	q.enforceCap()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rotate opens a fresh segment for writing; the caller holds q.mu
+func (q *outbox) rotate() error {
+	if q.writer != nil {
+		q.writer.Close()
+		q.writer = nil
+	}
+	// Keep appending to the last segment after a restart if it has room
+	if _, ok := q.sizes[q.writeSeq]; !ok || q.sizes[q.writeSeq] >= segmentBytes {
+		q.writeSeq++
+	}
+
+	file, err := os.OpenFile(q.segmentPath(q.writeSeq), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	q.writer = file
+	if _, ok := q.sizes[q.writeSeq]; !ok {
+		q.sizes[q.writeSeq] = 0
+	}
+	if len(q.sizes) == 1 && q.read.Segment < q.writeSeq {
+		q.read = cursor{Segment: q.writeSeq}
+	}
+	return nil
+}
+
+// enforceCap drops the oldest segments while the outbox is over its size
+// limit; the caller holds q.mu
+func (q *outbox) enforceCap() {
+	var total int64
+	for _, size := range q.sizes {
+		total += size
+	}
+
+	for _, seq := range q.segments() {
+		if total <= q.maxBytes || seq == q.writeSeq {
+			return
+		}
+		log.Printf("%s outbox is over %d bytes, dropping its oldest %d bytes of unsent data", q.name, q.maxBytes, q.sizes[seq])
+		total -= q.sizes[seq]
+		q.removeSegment(seq)
+	}
+}
+
+// removeSegment deletes a segment, moving the read position past it if
+// needed; the caller holds q.mu
+func (q *outbox) removeSegment(seq uint64) {
+	os.Remove(q.segmentPath(seq))
+	delete(q.sizes, seq)
+	if q.read.Segment <= seq {
+		next := seq + 1
+		if seqs := q.segments(); len(seqs) > 0 {
+			next = seqs[0]
+		}
+		q.read = cursor{Segment: next}
+		q.saveCursor()
+	}
+}
+
+func (q *outbox) saveCursor() {
+	data, _ := json.Marshal(q.read)
+	tmp := q.cursorPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Error saving %s outbox position: %v", q.name, err)
+		return
+	}
+	if err := os.Rename(tmp, q.cursorPath()); err != nil {
+		log.Printf("Error saving %s outbox position: %v", q.name, err)
+	}
+}
+
+// Peek returns up to n of the oldest unsent records and the position just
+// past them, to pass to Ack once they have been delivered
+func (q *outbox) Peek(n int) ([]json.RawMessage, cursor, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		size, ok := q.sizes[q.read.Segment]
+		if !ok {
+			return nil, q.read, nil
+		}
+		if q.read.Offset < size {
+			break
+		}
+		if q.read.Segment == q.writeSeq {
+			return nil, q.read, nil
+		}
+		q.removeSegment(q.read.Segment)
+	}
+
+	file, err := os.Open(q.segmentPath(q.read.Segment))
+	if err != nil {
+		return nil, q.read, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(q.read.Offset, io.SeekStart); err != nil {
+		return nil, q.read, err
+	}
+
+	r := bufio.NewReader(io.LimitReader(file, q.sizes[q.read.Segment]-q.read.Offset))
+	next := q.read
+	var records []json.RawMessage
+	for len(records) < n {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		next.Offset += int64(len(line))
+		if !json.Valid(line) {
+			log.Printf("Skipping corrupt record in %s outbox", q.name)
+			continue
+		}
+		records = append(records, json.RawMessage(bytes.TrimSpace(line)))
+	}
+	return records, next, nil
+}
+
+// Ack marks everything before c as delivered
+func (q *outbox) Ack(c cursor) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The segment may have been dropped by the size cap while sending
+	if c.Segment != q.read.Segment {
+		return
+	}
+	q.read = c
+	q.saveCursor()
+}
+
+// Wait blocks until a record is pushed or the timeout passes
+func (q *outbox) Wait(timeout time.Duration) {
+	select {
+	case <-q.notify:
+	case <-time.After(timeout):
+	}
+}
+
+// errRejected marks submissions the server will never accept, so they are
+// dropped instead of retried
+var errRejected = errors.New("rejected by server")
+
+// errTooLarge marks a batch the server refused for its size (413); drain
+// retries it in smaller batches and only drops a single record that is
+// still too large
+var errTooLarge = errors.New("batch too large for server")
+
+// retryAfterError carries the delay the server asked for with a 429
+type retryAfterError struct {
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("server is busy (429), retry after %s", e.delay)
+}
+
+// sendFunc delivers a batch of records to the server
+type sendFunc func(records []json.RawMessage) error
+
+// drain sends the outbox's records in order, backing off exponentially while
+// the server is unreachable. Batches the server finds too large are halved
+// until they fit, then grow back to batchSize as sends succeed.
+func drain(q *outbox, batchSize int, send sendFunc) {
+	backoff := minBackoff
+	size := batchSize
+	for {
+		records, next, err := q.Peek(size)
+		if err != nil {
+			log.Printf("Error reading %s outbox: %v", q.name, err)
+			time.Sleep(backoff)
+			continue
+		}
+		if len(records) == 0 {
+			q.Wait(time.Minute)
+			continue
+		}
+
+		err = send(records)
+		switch {
+		case err == nil:
+			q.Ack(next)
+			backoff = minBackoff
+			if size *= 2; size > batchSize {
+				size = batchSize
+			}
+			continue
+		case errors.Is(err, errTooLarge) && len(records) > 1:
+			size = len(records) / 2
+			log.Printf("Server refused %d %s records as too large, retrying in batches of %d", len(records), q.name, size)
+			continue
+		case errors.Is(err, errTooLarge), errors.Is(err, errRejected):
+			log.Printf("Dropping %d %s records: %v", len(records), q.name, err)
+			q.Ack(next)
+			continue
+		}
+
+		delay := backoff
+		var busy *retryAfterError
+		if errors.As(err, &busy) && busy.delay > delay {
+			delay = busy.delay
+		}
+		log.Printf("Error submitting %s, %d records kept for retry in %s: %v", q.name, len(records), delay, err)
+		time.Sleep(delay)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// checkResponse turns a submission's status code into an error
+func checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusAccepted:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("server rejected device token (401); ask an admin to rotate it")
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &retryAfterError{delay: time.Duration(seconds) * time.Second}
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: status %d", errTooLarge, resp.StatusCode)
+	case resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: status %d", errRejected, resp.StatusCode)
+	default:
+		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ProcessInfo is one process in the top processes sent with a sample
+type ProcessInfo struct {
+	PID         int32   `json:"pid"`
+	Name        string  `json:"name"`
+	User        string  `json:"user"`
+	CPU         float64 `json:"cpu"`
+	Memory      float64 `json:"memory"`
+	RSS         uint64  `json:"rss"`
+	CmdlineHash string  `json:"cmdline_hash"` // command lines can hold secrets, so only a hash is sent
+}
+
+// tracked is a process seen in an earlier scan; keeping its handle lets
+// Percent measure CPU since then instead of since the process started
+type tracked struct {
+	proc    *process.Process
+	created int64
+	info    ProcessInfo
+}
+
+// processTracker remembers processes between scans
+type processTracker struct {
+	seen map[int32]*tracked
+}
+
+func newProcessTracker() *processTracker {
+	return &processTracker{seen: make(map[int32]*tracked)}
+}
+
+// top returns the n processes using the most CPU together with the n using
+// the most memory. Processes that exit or can't be read mid-scan are skipped.
+func (t *processTracker) top(n int) []ProcessInfo {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil
+	}
+
+	current := make(map[int32]*tracked, len(procs))
+	var infos []ProcessInfo
+	for _, p := range procs {
+		created, err := p.CreateTime()
+		if err != nil {
+			continue
+		}
+		// A different creation time means the PID was reused
+		entry, ok := t.seen[p.Pid]
+		if !ok || entry.created != created {
+			entry = &tracked{proc: p, created: created}
+			entry.info = describeProcess(p)
+		}
+		if entry.info.Name == "" {
+			continue
+		}
+		current[p.Pid] = entry
+
+		cpuPercent, err := entry.proc.Percent(0)
+		if err != nil {
+			continue
+		}
+		info := entry.info
+		info.CPU = cpuPercent
+		if memPercent, err := entry.proc.MemoryPercent(); err == nil {
+			info.Memory = float64(memPercent)
+		}
+		if memInfo, err := entry.proc.MemoryInfo(); err == nil {
+			info.RSS = memInfo.RSS
+		}
+		infos = append(infos, info)
+	}
+	t.seen = current
+
+	return topProcesses(infos, n)
+}
+
+// describeProcess reads the fields that don't change while a process runs
+func describeProcess(p *process.Process) ProcessInfo {
+	info := ProcessInfo{PID: p.Pid}
+	info.Name, _ = p.Name()
+	info.User, _ = p.Username()
+	if cmdline, err := p.Cmdline(); err == nil && cmdline != "" {
+		sum := sha256.Sum256([]byte(cmdline))
+		info.CmdlineHash = hex.EncodeToString(sum[:])
+	}
+	info.Name = strings.TrimSpace(info.Name)
+	return info
+}
+
+// topProcesses merges the top n by CPU and the top n by memory, ordered by CPU
+func topProcesses(infos []ProcessInfo, n int) []ProcessInfo {
+	picked := make(map[int32]bool)
+	var result []ProcessInfo
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Memory > infos[j].Memory })
+	for i := 0; i < n && i < len(infos); i++ {
+		picked[infos[i].PID] = true
+		result = append(result, infos[i])
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CPU > infos[j].CPU })
+	for i := 0; i < n && i < len(infos); i++ {
+		if !picked[infos[i].PID] {
+			result = append(result, infos[i])
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].CPU > result[j].CPU })
+	return result
+}
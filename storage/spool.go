@@ -0,0 +1,592 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+)
+
+const (
+	defaultSpoolDir     = "spool"
+	defaultMaxBytes     = 512 << 20
+	defaultSegmentBytes = 16 << 20
+	defaultReplayEvery  = 30 * time.Second
+	replayChunk         = 500
+	maxRecordBytes      = 1 << 20
+	recordHeaderBytes   = 8 // payload length and CRC-32, both big-endian uint32
+	commitFile          = "commit.json"
+	segmentPrefix       = "segment-"
+	segmentSuffix       = ".log"
+	legacyBufferFile    = "buffer.log"
+)
+
+// ErrSpoolFull is returned when writing would exceed the spool's size cap
+var ErrSpoolFull = errors.New("spool is full")
+
+// position is how far replay has got: the next unread byte of a segment
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// SpoolStats is a snapshot of the spool's size and counters
+type SpoolStats struct {
+	Segments     int        `json:"segments"`
+	Bytes        int64      `json:"bytes"`
+	MaxBytes     int64      `json:"max_bytes"`
+	Written      uint64     `json:"written"`
+	Replayed     uint64     `json:"replayed"`
+	Corrupt      uint64     `json:"corrupt"`   // records skipped because their checksum failed
+	Dropped      uint64     `json:"dropped"`   // refused because the spool was full
+	Discarded    uint64     `json:"discarded"` // rejected by the processor, e.g. for deleted computers
+	LastReplayAt *time.Time `json:"last_replay_at"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Spool is a write-ahead log of resource samples that could not be stored.
+// Records are appended to numbered segment files, each framed with its length
+// and a CRC-32. Replay resumes from a persisted commit position, so samples
+// are handed to the processor at least once; the processor must be idempotent
+// (samples carry their final ResourceLog.ID).
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu         sync.Mutex
+	active     *os.File
+	activeSeq  uint64
+	activeSize int64
+	segments   map[uint64]int64 // size of every segment on disk
+	commit     position
+	stats      SpoolStats
+
+	replayMu sync.Mutex // one replay at a time
+	kick     chan struct{}
+}
+
+// Default is the process-wide spool, set by Open
+var Default *Spool
+
+// Open opens (or creates) the spool in SPOOL_DIR. SPOOL_MAX_BYTES caps its
+// total size and SPOOL_SEGMENT_BYTES the size of each segment file.
+func Open() (*Spool, error) {
+	dir := os.Getenv("SPOOL_DIR")
+	if dir == "" {
+		dir = defaultSpoolDir
+	}
+
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     int64(utils.EnvInt("SPOOL_MAX_BYTES", defaultMaxBytes)),
+		segmentBytes: int64(utils.EnvInt("SPOOL_SEGMENT_BYTES", defaultSegmentBytes)),
+		segments:     make(map[uint64]int64),
+		kick:         make(chan struct{}, 1),
+	}
+	s.stats.MaxBytes = s.maxBytes
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.importLegacyBuffer(); err != nil {
+		utils.LogError("Failed to import %s into the spool: %v", legacyBufferFile, err)
+	}
+
+	Default = s
+	return s, nil
+}
+
+// load reads the commit position and segment list, removes segments that
+// were already replayed, and repairs a torn write at the end of the last one
+func (s *Spool) load() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, commitFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &s.commit); err != nil {
+			return fmt.Errorf("invalid spool commit marker: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		seq, ok := parseSegmentName(entry.Name())
+		if !ok {
+			continue
+		}
+		if seq < s.commit.Segment {
+			if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		s.segments[seq] = info.Size()
+		if seq > s.activeSeq {
+			s.activeSeq = seq
+		}
+	}
+
+	if len(s.segments) == 0 {
+		if s.commit.Segment > 0 {
+			s.activeSeq = s.commit.Segment - 1
+		}
+		return nil
+	}
+
+	// A crash mid-write leaves a partial record at the end of the newest segment
+	name := s.segmentPath(s.activeSeq)
+	valid, err := validLength(name)
+	if err != nil {
+		return err
+	}
+	if valid < s.segments[s.activeSeq] {
+		utils.LogWarning("Truncating torn write at the end of %s (%d -> %d bytes)", name, s.segments[s.activeSeq], valid)
+		if err := os.Truncate(name, valid); err != nil {
+			return err
+		}
+		s.segments[s.activeSeq] = valid
+	}
+
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = file
+	s.activeSize = s.segments[s.activeSeq]
+	return nil
+}
+
+// importLegacyBuffer moves samples from the old newline-delimited buffer.log
+// into the spool
+func (s *Spool) importLegacyBuffer() error {
+	data, err := os.ReadFile(legacyBufferFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var logs []models.ResourceLog
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var log models.ResourceLog
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			s.stats.Corrupt++
+			continue
+		}
+		logs = append(logs, log)
+	}
+
+	if len(logs) > 0 {
+		if err := s.Write(logs); err != nil {
+			return err
+		}
+		utils.LogInfo("Imported %d samples from %s into the spool", len(logs), legacyBufferFile)
+	}
+	return os.Remove(legacyBufferFile)
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+	return seq, err == nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", segmentPrefix, seq, segmentSuffix))
+}
+
+// encodeRecord frames a payload as length, CRC-32, payload
+func encodeRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderBytes+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderBytes:], payload)
+	return record
+}
+
+// readRecord reads one framed record. corrupt is set, with err nil, when the
+// record is complete but its checksum does not match.
+func readRecord(r io.Reader) (payload []byte, size int64, corrupt bool, err error) {
+	var header [recordHeaderBytes]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, false, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordBytes {
+		return nil, 0, false, fmt.Errorf("record length %d exceeds limit", length)
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, false, err
+	}
+
+	size = int64(recordHeaderBytes) + int64(length)
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, size, true, nil
+	}
+	return payload, size, false, nil
+}
+
+// validLength returns the length of a segment's leading run of complete records
+func validLength(name string) (int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var valid int64
+	for {
+		_, size, _, err := readRecord(r)
+		if err != nil {
+			return valid, nil
+		}
+		valid += size
+	}
+}
+
+// Write appends samples to the spool and syncs them to disk
+func (s *Spool) Write(logs []models.ResourceLog) error {
+	var buf []byte
+	for i := range logs {
+		payload, err := json.Marshal(&logs[i])
+		if err != nil {
+			return err
+		}
+		buf = append(buf, encodeRecord(payload)...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totalBytes()+int64(len(buf)) > s.maxBytes {
+		s.stats.Dropped += uint64(len(logs))
+		return ErrSpoolFull
+	}
+
+	if s.active == nil || s.activeSize+int64(len(buf)) > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(buf); err != nil {
+		// Drop whatever part of the write landed so the segment stays well-formed
+		s.active.Truncate(s.activeSize)
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+
+	s.activeSize += int64(len(buf))
+	s.segments[s.activeSeq] = s.activeSize
+	s.stats.Written += uint64(len(logs))
+	return nil
+}
+
+// rotate starts a new segment; the caller holds s.mu
+func (s *Spool) rotate() error {
+	if s.active != nil {
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		s.active = nil
+	}
+
+	seq := s.activeSeq + 1
+	file, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.active = file
+	s.activeSeq = seq
+	s.activeSize = 0
+	s.segments[seq] = 0
+	return nil
+}
+
+func (s *Spool) totalBytes() int64 {
+	var total int64
+	for _, size := range s.segments {
+		total += size
+	}
+	return total
+}
+
+// Pending reports whether the spool holds samples that have not been replayed
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for seq, size := range s.segments {
+		if seq > s.commit.Segment || (seq == s.commit.Segment && size > s.commit.Offset) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveCommit persists the replay position atomically; the caller holds s.mu
+func (s *Spool) saveCommit(pos position) error {
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, commitFile+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, commitFile)); err != nil {
+		return err
+	}
+
+	s.commit = pos
+	return nil
+}
+
+// Processor stores replayed samples. It returns how many it deliberately
+// discarded (e.g. for computers that no longer exist); an error stops the
+// replay and the same samples are offered again next time.
+type Processor func(logs []models.ResourceLog) (discarded int, err error)
+
+// Replay hands every unreplayed sample to process in chunks, committing the
+// position after each chunk and deleting segments once fully replayed
+func (s *Spool) Replay(process Processor) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	replayed, err := s.replay(process)
+
+	now := time.Now()
+	s.mu.Lock()
+	s.stats.LastReplayAt = &now
+	s.stats.LastError = ""
+	if err != nil {
+		s.stats.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	return replayed, err
+}
+
+func (s *Spool) replay(process Processor) (int, error) {
+	replayed := 0
+	for {
+		s.mu.Lock()
+		pos := s.commit
+		var seqs []uint64
+		for seq := range s.segments {
+			if seq >= pos.Segment {
+				seqs = append(seqs, seq)
+			}
+		}
+		s.mu.Unlock()
+
+		if len(seqs) == 0 {
+			return replayed, nil
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+		seq := seqs[0]
+		offset := int64(0)
+		if seq == pos.Segment {
+			offset = pos.Offset
+		}
+
+		// When the segment isn't done, samples were appended to it while it
+		// was being read and the next pass picks them up
+		n, err := s.replaySegment(seq, offset, process)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+}
+
+// replaySegment replays one segment from offset, removing it once done
+func (s *Spool) replaySegment(seq uint64, offset int64, process Processor) (int, error) {
+	// Only read what had been completely written when we started
+	s.mu.Lock()
+	limit := s.segments[seq]
+	s.mu.Unlock()
+
+	file, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(io.LimitReader(file, limit-offset))
+
+	replayed := 0
+	for offset < limit {
+		var chunk []models.ResourceLog
+		end := offset
+		var corrupt uint64
+		for len(chunk) < replayChunk && end < limit {
+			payload, size, bad, err := readRecord(r)
+			if err != nil {
+				// The framing is broken; nothing after this point can be trusted
+				utils.LogError("Spool segment %d unreadable at offset %d, skipping the rest: %v", seq, end, err)
+				corrupt++
+				end = limit
+				break
+			}
+			end += size
+
+			var log models.ResourceLog
+			if bad || json.Unmarshal(payload, &log) != nil {
+				corrupt++
+				continue
+			}
+			chunk = append(chunk, log)
+		}
+
+		discarded := 0
+		if len(chunk) > 0 {
+			discarded, err = process(chunk)
+			if err != nil {
+				return replayed, err
+			}
+		}
+
+		s.mu.Lock()
+		s.stats.Corrupt += corrupt
+		s.stats.Replayed += uint64(len(chunk) - discarded)
+		s.stats.Discarded += uint64(discarded)
+		err = s.saveCommit(position{Segment: seq, Offset: end})
+		s.mu.Unlock()
+		if err != nil {
+			return replayed, err
+		}
+
+		replayed += len(chunk) - discarded
+		offset = end
+	}
+
+	return replayed, s.finishSegment(seq, offset)
+}
+
+// finishSegment removes a fully replayed segment, unless samples were
+// appended to it in the meantime
+func (s *Spool) finishSegment(seq uint64, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.segments[seq] > offset {
+		return nil
+	}
+
+	if seq == s.activeSeq && s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	if err := os.Remove(s.segmentPath(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.segments, seq)
+
+	return s.saveCommit(position{Segment: seq + 1})
+}
+
+// Kick asks the background replayer to try again now, e.g. after the
+// database accepted a write
+func (s *Spool) Kick() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// StartReplay retries replay in the background every SPOOL_REPLAY_INTERVAL,
+// or sooner when kicked, whenever the spool holds unreplayed samples
+func (s *Spool) StartReplay(process Processor) {
+	interval := utils.EnvDuration("SPOOL_REPLAY_INTERVAL", defaultReplayEvery)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if s.Pending() {
+				n, err := s.Replay(process)
+				if n > 0 {
+					utils.LogInfo("Replayed %d spooled samples", n)
+				}
+				if err != nil {
+					utils.LogWarning("Spool replay stopped, will retry: %v", err)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.kick:
+			}
+		}
+	}()
+}
+
+// Stats returns the spool's current size and counters
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Segments = len(s.segments)
+	stats.Bytes = s.totalBytes()
+	return stats
+}
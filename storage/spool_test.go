@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/google/uuid"
+)
+
+// openSpool opens a spool in a fresh directory with the given caps
+func openSpool(t *testing.T, maxBytes, segmentBytes int64) *Spool {
+	t.Helper()
+	t.Setenv("SPOOL_DIR", t.TempDir())
+	t.Setenv("SPOOL_MAX_BYTES", strconv.FormatInt(maxBytes, 10))
+	t.Setenv("SPOOL_SEGMENT_BYTES", strconv.FormatInt(segmentBytes, 10))
+	return reopen(t, nil)
+}
+
+// reopen opens the spool in SPOOL_DIR again, as after a restart
+func reopen(t *testing.T, s *Spool) *Spool {
+	t.Helper()
+	if s != nil && s.active != nil {
+		s.active.Close()
+	}
+	s, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if s.active != nil {
+			s.active.Close()
+		}
+	})
+	return s
+}
+
+// samples returns n samples whose CPU is their index
+func samples(n int) []models.ResourceLog {
+	logs := make([]models.ResourceLog, n)
+	for i := range logs {
+		logs[i] = models.ResourceLog{ID: uuid.New(), ComputerID: "pc-1", CPU: float64(i)}
+	}
+	return logs
+}
+
+// recordSize is how many bytes a sample takes in a segment
+func recordSize(t *testing.T, log models.ResourceLog) int64 {
+	t.Helper()
+	payload, err := json.Marshal(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(encodeRecord(payload)))
+}
+
+// collect is a processor that keeps every sample it is given
+func collect(got *[]models.ResourceLog) Processor {
+	return func(logs []models.ResourceLog) (int, error) {
+		*got = append(*got, logs...)
+		return 0, nil
+	}
+}
+
+// cpus returns the CPU values of samples, i.e. their indexes from samples
+func cpus(logs []models.ResourceLog) []int {
+	out := make([]int, len(logs))
+	for i, log := range logs {
+		out[i] = int(log.CPU)
+	}
+	return out
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReopenRepairsDamagedSegments(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, path string, record int64)
+		want    []int
+		corrupt uint64
+		size    int64 // of the segment after reopening, in records
+	}{
+		{
+			name: "torn header",
+			damage: func(t *testing.T, path string, record int64) {
+				appendBytes(t, path, []byte{0, 0, 1})
+			},
+			want: []int{0, 1, 2},
+			size: 3,
+		},
+		{
+			name: "torn payload",
+			damage: func(t *testing.T, path string, record int64) {
+				appendBytes(t, path, encodeRecord([]byte(`{"computer_id":"pc-1"}`))[:recordHeaderBytes+4])
+			},
+			want: []int{0, 1, 2},
+			size: 3,
+		},
+		{
+			name: "checksum mismatch",
+			damage: func(t *testing.T, path string, record int64) {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Flip a byte in the second record's payload
+				data[record+recordHeaderBytes+1] ^= 0xff
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:    []int{0, 2},
+			corrupt: 1,
+			size:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openSpool(t, 1<<20, 1<<20)
+			logs := samples(3)
+			if err := s.Write(logs); err != nil {
+				t.Fatal(err)
+			}
+			record := recordSize(t, logs[0])
+			path := s.segmentPath(s.activeSeq)
+			tt.damage(t, path, record)
+
+			s = reopen(t, s)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != tt.size*record {
+				t.Errorf("segment is %d bytes after reopening, want %d", info.Size(), tt.size*record)
+			}
+
+			var got []models.ResourceLog
+			n, err := s.Replay(collect(&got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(cpus(got), tt.want) || n != len(tt.want) {
+				t.Errorf("replayed %v (n=%d), want %v", cpus(got), n, tt.want)
+			}
+			if stats := s.Stats(); stats.Corrupt != tt.corrupt {
+				t.Errorf("Corrupt = %d, want %d", stats.Corrupt, tt.corrupt)
+			}
+		})
+	}
+}
+
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayResumesFromCommitAfterError(t *testing.T) {
+	s := openSpool(t, 64<<20, 64<<20)
+	total := 2*replayChunk + 100
+	logs := samples(total)
+	if err := s.Write(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second chunk fails; the first stays committed
+	failure := errors.New("database is down")
+	var got []models.ResourceLog
+	calls := 0
+	n, err := s.Replay(func(logs []models.ResourceLog) (int, error) {
+		calls++
+		if calls == 2 {
+			return 0, failure
+		}
+		got = append(got, logs...)
+		return 0, nil
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Replay error = %v, want %v", err, failure)
+	}
+	if n != replayChunk || len(got) != replayChunk {
+		t.Fatalf("replayed %d (n=%d) before the error, want %d", len(got), n, replayChunk)
+	}
+	if stats := s.Stats(); stats.LastError != failure.Error() {
+		t.Errorf("LastError = %q, want %q", stats.LastError, failure.Error())
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, commitFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commit position
+	if err := json.Unmarshal(data, &commit); err != nil {
+		t.Fatal(err)
+	}
+	var offset int64
+	for _, log := range logs[:replayChunk] {
+		offset += recordSize(t, log)
+	}
+	if want := (position{Segment: s.activeSeq, Offset: offset}); commit != want {
+		t.Errorf("commit = %+v, want %+v", commit, want)
+	}
+
+	// After a restart the rest comes back, starting with the failed chunk
+	s = reopen(t, s)
+	var rest []models.ResourceLog
+	if _, err := s.Replay(collect(&rest)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != total-replayChunk {
+		t.Fatalf("replayed %d after restart, want %d", len(rest), total-replayChunk)
+	}
+	for i, log := range rest {
+		if int(log.CPU) != replayChunk+i {
+			t.Fatalf("sample %d after restart is %d, want %d", i, int(log.CPU), replayChunk+i)
+		}
+	}
+	if s.Pending() {
+		t.Error("spool still pending after a full replay")
+	}
+}
+
+func TestSegmentsRotateAndAreRemovedOnceReplayed(t *testing.T) {
+	logs := samples(5)
+	record := recordSize(t, logs[0])
+	s := openSpool(t, 1<<20, 2*record)
+
+	for i := range logs {
+		if err := s.Write(logs[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := s.Stats(); stats.Segments != 3 || stats.Bytes != 5*record {
+		t.Fatalf("after writing: %d segments, %d bytes; want 3 segments, %d bytes", stats.Segments, stats.Bytes, 5*record)
+	}
+	last := s.activeSeq
+
+	var got []models.ResourceLog
+	if _, err := s.Replay(collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 3, 4}; !equal(cpus(got), want) {
+		t.Fatalf("replayed %v, want %v", cpus(got), want)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if _, ok := parseSegmentName(entry.Name()); ok {
+			t.Errorf("segment %s left after replay", entry.Name())
+		}
+	}
+	if stats := s.Stats(); stats.Segments != 0 || stats.Bytes != 0 {
+		t.Errorf("after replay: %d segments, %d bytes; want none", stats.Segments, stats.Bytes)
+	}
+	if want := (position{Segment: last + 1}); s.commit != want {
+		t.Errorf("commit = %+v, want %+v", s.commit, want)
+	}
+
+	// New writes go to a new segment past the commit, also after a restart
+	s = reopen(t, s)
+	if err := s.Write(samples(1)); err != nil {
+		t.Fatal(err)
+	}
+	if s.activeSeq <= last {
+		t.Errorf("wrote to segment %d, want one after %d", s.activeSeq, last)
+	}
+	got = nil
+	if _, err := s.Replay(collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("replayed %d samples written after the restart, want 1", len(got))
+	}
+}
+
+func TestWriteFailsWhenFull(t *testing.T) {
+	logs := samples(4)
+	record := recordSize(t, logs[0])
+	s := openSpool(t, 3*record, 1<<20)
+
+	if err := s.Write(logs[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(logs[3:]); !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("Write past SPOOL_MAX_BYTES = %v, want ErrSpoolFull", err)
+	}
+	if stats := s.Stats(); stats.Dropped != 1 || stats.Written != 3 {
+		t.Errorf("Dropped = %d, Written = %d; want 1 and 3", stats.Dropped, stats.Written)
+	}
+
+	// Replaying frees the space
+	var got []models.ResourceLog
+	if _, err := s.Replay(collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(logs[3:]); err != nil {
+		t.Errorf("Write after replay = %v", err)
+	}
+}
+
+func TestOpenImportsLegacyBuffer(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var buffer []byte
+	for _, log := range samples(2) {
+		line, err := json.Marshal(&log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer = append(buffer, line...)
+		buffer = append(buffer, '\n')
+	}
+	buffer = append(buffer, "\n{not json\n"...)
+	if err := os.WriteFile(legacyBufferFile, buffer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openSpool(t, 1<<20, 1<<20)
+	if _, err := os.Stat(legacyBufferFile); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed after the import: %v", legacyBufferFile, err)
+	}
+
+	var got []models.ResourceLog
+	if _, err := s.Replay(collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1}; !equal(cpus(got), want) {
+		t.Errorf("replayed %v, want %v", cpus(got), want)
+	}
+	if stats := s.Stats(); stats.Corrupt != 1 || stats.Written != 2 {
+		t.Errorf("Corrupt = %d, Written = %d; want 1 and 2", stats.Corrupt, stats.Written)
+	}
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewDeviceToken returns a random collector token and the hash to store for it.
+// The plain token is only ever shown to the caller once.
+func NewDeviceToken() (token string, hash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashDeviceToken(token), nil
+}
+
+// HashDeviceToken returns the hex SHA-256 of a device token
+func HashDeviceToken(token string) string {
+	return hashToken(token)
+}
+
+// randomToken returns 32 random bytes as URL-safe base64
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex SHA-256 of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
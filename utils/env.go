@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration reads a positive Go duration (e.g. "30s", "5m") from the
+// environment, falling back if it is unset or invalid
+func EnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		LogWarning("Invalid %s %q, using %s", name, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// EnvOptionalDuration reads a Go duration that may be 0 from the
+// environment, reporting false if it is unset or invalid
+func EnvOptionalDuration(name string) (time.Duration, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		LogWarning("Invalid %s %q, ignoring it", name, value)
+		return 0, false
+	}
+	return d, true
+}
+
+// EnvInt reads a positive integer from the environment, falling back if it
+// is unset or invalid
+func EnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		LogWarning("Invalid %s %q, using %d", name, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+// EnvBool reports whether an environment variable is set to "true"
+func EnvBool(name string) bool {
+	return os.Getenv(name) == "true"
+}
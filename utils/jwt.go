@@ -9,26 +9,65 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
 type JWTClaims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Username string    `json:"username"`
-	Role     string    `json:"role"`
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	SessionID uuid.UUID `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uuid.UUID, username, role string) (string, error) {
-	claims := JWTClaims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+// AccessTokenTTL is how long access tokens are valid, from ACCESS_TOKEN_TTL
+func AccessTokenTTL() time.Duration {
+	return EnvDuration("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is how long refresh tokens are valid, from REFRESH_TOKEN_TTL
+func RefreshTokenTTL() time.Duration {
+	return EnvDuration("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// GenerateToken issues a short-lived access token for a login session. The
+// returned claims carry the token's ID (jti) and expiry for revocation.
+func GenerateToken(userID uuid.UUID, username, role string, sessionID uuid.UUID) (string, *JWTClaims, error) {
+	now := time.Now()
+	claims := &JWTClaims{
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL())),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// NewRefreshToken returns a random refresh token and the hash to store for it
+func NewRefreshToken() (token string, hash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hex SHA-256 of a refresh token
+func HashRefreshToken(token string) string {
+	return hashToken(token)
 }
 
 func ValidateToken(tokenString string) (*JWTClaims, error) {
@@ -44,6 +83,10 @@ func ValidateToken(tokenString string) (*JWTClaims, error) {
 	}
 
 	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
+		// Tokens without an ID predate revocation support and cannot be revoked
+		if claims.ID == "" || claims.SessionID == uuid.Nil {
+			return nil, errors.New("token has no ID")
+		}
 		return claims, nil
 	}
 
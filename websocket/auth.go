@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// authTimeout is how long a connection opened without a token has to
+	// send its auth frame
+	authTimeout = 10 * time.Second
+
+	// revalidateInterval is how often a connection's token is checked
+	// against revocations and its lab assignments reloaded
+	revalidateInterval = 30 * time.Second
+)
+
+// session is the user a connection is authenticated as
+type session struct {
+	token  string
+	claims *utils.JWTClaims
+	scope  *middleware.Scope
+}
+
+// authenticate checks a token as the HTTP middleware does. On failure it
+// returns the status and message to report.
+func authenticate(token string) (*session, int, string) {
+	claims, scope, status, msg := middleware.AuthenticateToken(token)
+	if status != 0 {
+		return nil, status, msg
+	}
+	return &session{token: token, claims: claims, scope: scope}, 0, ""
+}
+
+// expiresAt is when the session's access token stops being valid
+func (s *session) expiresAt() time.Time {
+	return s.claims.ExpiresAt.Time
+}
+
+// allowsTopic reports whether the user may subscribe to a topic. Lab
+// managers may only follow their own labs and computers; alerts and all are
+// filtered per event instead.
+func (s *session) allowsTopic(topic string) bool {
+	switch {
+	case strings.HasPrefix(topic, topicLabPrefix):
+		labID, err := uuid.Parse(strings.TrimPrefix(topic, topicLabPrefix))
+		return err == nil && s.scope.AllowsLab(labID)
+	case strings.HasPrefix(topic, topicComputerPrefix):
+		return s.scope.AllowsComputer(config.DB, strings.TrimPrefix(topic, topicComputerPrefix))
+	}
+	return true
+}
+
+// requestToken returns the access token given as ?token= or in the
+// Authorization header, for clients such as EventSource that can't set headers
+func requestToken(c *fiber.Ctx) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if header := c.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+// CheckUpgrade only lets websocket upgrades through. A token given as
+// ?token= or in the Authorization header is checked before upgrading, so bad
+// tokens get a plain 401; without one the client must send an auth frame.
+func CheckUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	if token := requestToken(c); token != "" {
+		s, status, msg := authenticate(token)
+		if s == nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		c.Locals("session", s)
+	}
+
+	c.Locals("allowed", true)
+	return c.Next()
+}
+
+// awaitAuth reads the first frame of a connection opened without a token,
+// which must be an auth request
+func (c *Client) awaitAuth() (*session, string) {
+	c.Conn.SetReadDeadline(time.Now().Add(authTimeout))
+
+	_, msg, err := c.Conn.ReadMessage()
+	if err != nil {
+		return nil, "Authentication required"
+	}
+	req, err := parseRequest(msg)
+	if err != nil || req.Action != actionAuth || req.Token == "" {
+		return nil, "Authentication required: send {\"action\": \"auth\", \"token\": \"<access token>\"} first"
+	}
+
+	s, _, errMsg := authenticate(req.Token)
+	if s == nil {
+		return nil, errMsg
+	}
+	expiresAt := s.expiresAt()
+	c.reply(ackReply{Type: "ack", Action: actionAuth, ID: req.ID, Topics: []string{}, ExpiresAt: &expiresAt})
+	return s, ""
+}
+
+// reauthenticate replaces the session's token, e.g. with a refreshed one
+// before the current token expires. The token must belong to the same user.
+func (c *Client) reauthenticate(token string) (*session, error) {
+	s, _, msg := authenticate(token)
+	if s == nil {
+		return nil, errorString(msg)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.claims.UserID != c.session.claims.UserID {
+		return nil, errorString("Token belongs to a different user")
+	}
+	c.session = s
+
+	select {
+	case c.reauthed <- struct{}{}:
+	default:
+	}
+	return s, nil
+}
+
+// watch disconnects the client when its token expires or is revoked, and
+// keeps its lab scope current
+func (c *Client) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(revalidateInterval)
+	defer ticker.Stop()
+
+	for {
+		expiry := time.NewTimer(time.Until(c.currentSession().expiresAt()))
+		select {
+		case <-done:
+			expiry.Stop()
+			return
+		case <-c.reauthed:
+			expiry.Stop()
+		case <-expiry.C:
+			c.disconnect("Token expired")
+			return
+		case <-ticker.C:
+			expiry.Stop()
+			current := c.currentSession()
+			s, status, msg := authenticate(current.token)
+			if status == fiber.StatusInternalServerError {
+				continue // keep the connection and try again next tick
+			}
+			if s == nil {
+				c.disconnect(msg)
+				return
+			}
+			c.mu.Lock()
+			if c.session == current {
+				c.session = s
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *Client) currentSession() *session {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session
+}
+
+// disconnect tells the client why and closes the connection
+func (c *Client) disconnect(reason string) {
+	c.reply(errorReply{Type: "error", Error: reason})
+	c.stop(closeFrame{code: websocket.ClosePolicyViolation, reason: reason, flush: true})
+}
+
+// errorString is an error whose message is shown to the client as is
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
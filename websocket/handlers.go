@@ -2,83 +2,401 @@ package websocket
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+// Connection timing. Vars so tests can shorten them.
+var (
+	// writeWait is how long a single write may take
+	writeWait = 10 * time.Second
+
+	// pongWait is how long the connection may stay silent, pongs included
+	pongWait = 60 * time.Second
+
+	// pingPeriod is how often the server pings; shorter than pongWait so a
+	// healthy client always answers in time
+	pingPeriod = pongWait * 9 / 10
+)
+
+const (
+	// maxFrameSize bounds the frames clients may send; they only send
+	// control requests
+	maxFrameSize = 16 * 1024
+
+	// maxCloseReason is the most a close frame's reason may hold
+	maxCloseReason = 123
 )
 
 type Client struct {
 	Conn *websocket.Conn
-	Mu   sync.Mutex
+	hub  *Hub
+
+	mu       sync.RWMutex // guards topics and session
+	topics   map[string]bool
+	session  *session
+	reauthed chan struct{}
+
+	send      chan []byte   // messages waiting for the writer
+	missed    atomic.Int64  // messages dropped in a row because send was full
+	closing   chan struct{} // closed to stop the writer
+	closeOnce sync.Once
+	close     closeFrame
+	stopped   chan struct{} // closed once the writer has closed Conn
 }
 
-var (
-	clients   = make(map[*Client]bool)
-	clientsMu sync.RWMutex
-	broadcast = make(chan []byte)
-)
+// closeFrame is how the writer ends the connection; flush sends the messages
+// already queued first
+type closeFrame struct {
+	code   int
+	reason string
+	flush  bool
+}
 
-func init() {
-	go handleBroadcasts()
-}
-
-func handleBroadcasts() {
-	for message := range broadcast {
-		clientsMu.RLock()
-		for client := range clients {
-			go func(c *Client, msg []byte) {
-				c.Mu.Lock()
-				defer c.Mu.Unlock()
-				if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-					log.Printf("Error writing to client: %v", err)
-					clientsMu.Lock()
-					delete(clients, c)
-					clientsMu.Unlock()
-					c.Conn.Close()
-				}
-			}(client, message)
+// message is an encoded event, the topics it is for and the lab it belongs to
+type message struct {
+	seq    uint64
+	data   []byte
+	topics []string
+	labID  uuid.UUID
+}
+
+func newClient(hub *Hub, conn *websocket.Conn) *Client {
+	return &Client{
+		Conn:     conn,
+		hub:      hub,
+		topics:   make(map[string]bool),
+		reauthed: make(chan struct{}, 1),
+		send:     make(chan []byte, hub.queueSize),
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// wants reports whether the client follows one of the message's topics and
+// may see its lab. Events without a lab only reach unrestricted users.
+func (c *Client) wants(msg message) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.session.scope.AllowsLab(msg.labID) {
+		return false
+	}
+	for _, topic := range msg.topics {
+		if c.topics[topic] {
+			return true
 		}
-		clientsMu.RUnlock()
 	}
+	return false
 }
 
-func HandleWebSocket(c *websocket.Conn) {
-	client := &Client{Conn: c}
+// enqueue hands a message to the writer without blocking. It reports false
+// if the message was dropped because the queue is full or the connection is
+// closing; a client that misses too many in a row is evicted.
+func (c *Client) enqueue(data []byte) bool {
+	select {
+	case <-c.closing:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		c.missed.Store(0)
+		c.hub.delivered.Add(1)
+		return true
+	default:
+	}
+
+	c.hub.dropped.Add(1)
+	if c.missed.Add(1) == int64(c.hub.evictAfter) {
+		c.hub.evicted.Add(1)
+		log.Printf("Evicting slow websocket client after %d dropped messages", c.hub.evictAfter)
+		c.stop(closeFrame{code: websocket.CloseTryAgainLater, reason: "Too slow to keep up"})
+	}
+	return false
+}
 
-	// Register client
-	clientsMu.Lock()
-	clients[client] = true
-	clientsMu.Unlock()
+// stop asks the writer to close the connection; only the first call counts
+func (c *Client) stop(frame closeFrame) {
+	c.closeOnce.Do(func() {
+		c.close = frame
+		close(c.closing)
+	})
+}
 
+// writePump is the only goroutine writing to Conn. It sends queued messages
+// and pings until stopped or a write fails, then closes Conn.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
-		clientsMu.Lock()
-		delete(clients, client)
-		clientsMu.Unlock()
-		c.Close()
+		ticker.Stop()
+		c.Conn.Close()
+		close(c.stopped)
 	}()
 
 	for {
-		_, msg, err := c.ReadMessage()
-		if err != nil {
-			break
+		select {
+		case data := <-c.send:
+			if err := c.writeFrame(websocket.TextMessage, data); err != nil {
+				c.stop(closeFrame{})
+				return
+			}
+		case <-ticker.C:
+			if err := c.writeFrame(websocket.PingMessage, nil); err != nil {
+				c.stop(closeFrame{})
+				return
+			}
+		case <-c.closing:
+			c.flush()
+			return
 		}
+	}
+}
 
-		// Echo the message back for now
-		// In production, you might want to validate/process the message
-		if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
-			break
+// flush sends what is already queued if the close asks for it, then the
+// close frame
+func (c *Client) flush() {
+	frame := c.close
+	if frame.flush {
+	drain:
+		for {
+			select {
+			case data := <-c.send:
+				if err := c.writeFrame(websocket.TextMessage, data); err != nil {
+					return
+				}
+			default:
+				break drain
+			}
+		}
+	}
+	if frame.code != 0 {
+		reason := frame.reason
+		if len(reason) > maxCloseReason {
+			reason = reason[:maxCloseReason]
 		}
+		c.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(frame.code, reason),
+			time.Now().Add(writeWait))
 	}
 }
 
-// BroadcastResourceUpdate sends a resource update to all connected clients
-func BroadcastResourceUpdate(data interface{}) {
-	jsonData, err := json.Marshal(data)
+func (c *Client) writeFrame(messageType int, data []byte) error {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// subscribe adds topics, all or nothing, and queues the ack followed by the
+// catch-up for the added topics: the buffered events after since if the
+// buffer still reaches back that far, otherwise a snapshot of the current
+// state. Events published later follow in sequence order.
+func (c *Client) subscribe(topics []string, since *uint64, id string) error {
+	for _, topic := range topics {
+		if err := ValidateTopic(topic); err != nil {
+			return err
+		}
+	}
+
+	// Checked before locking, as computer topics need a lookup
+	session := c.currentSession()
+	for _, topic := range topics {
+		if !session.allowsTopic(topic) {
+			return fmt.Errorf("not allowed to subscribe to %s", topic)
+		}
+	}
+
+	// Holding the hub keeps events from being published between adding the
+	// topics and collecting the replay
+	c.hub.mu.RLock()
+	previous, added, err := c.addTopics(topics)
 	if err != nil {
-		log.Printf("Error marshaling broadcast data: %v", err)
+		c.hub.mu.RUnlock()
+		return err
+	}
+	seq := c.hub.seq
+	c.mu.RLock()
+	c.reply(ackReply{Type: "ack", Action: actionSubscribe, ID: id, Topics: c.topicList(), Seq: &seq})
+	c.mu.RUnlock()
+
+	if len(added) == 0 {
+		c.hub.mu.RUnlock()
+		return nil
+	}
+	if since != nil {
+		events, ok := c.hub.since(*since, func(msg message) bool {
+			return c.wants(msg) && !subscribedTo(previous, msg.topics)
+		})
+		if ok {
+			c.reply(replayReply{Type: "replay", Seq: seq, Data: events})
+			c.hub.mu.RUnlock()
+			return nil
+		}
+	}
+	c.hub.mu.RUnlock()
+
+	snapshot, err := c.hub.snapshot(session, added)
+	if err != nil {
+		log.Printf("Error loading websocket snapshot: %v", err)
+		c.reply(errorReply{Type: "error", ID: id, Error: "Failed to load the current state"})
+		return nil
+	}
+	c.reply(snapshotReply{Type: "snapshot", Seq: seq, Data: snapshot})
+	return nil
+}
+
+// addTopics subscribes to topics, all or nothing, and returns the topics
+// followed before and the ones added
+func (c *Client) addTopics(topics []string) (map[string]bool, []string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := make(map[string]bool, len(c.topics))
+	for topic := range c.topics {
+		previous[topic] = true
+	}
+
+	var added []string
+	for _, topic := range topics {
+		if !previous[topic] && !contains(added, topic) {
+			added = append(added, topic)
+		}
+	}
+	if len(c.topics)+len(added) > maxTopicsPerClient {
+		return nil, nil, errTooManyTopics
+	}
+	for _, topic := range added {
+		c.topics[topic] = true
+	}
+	return previous, added, nil
+}
+
+func subscribedTo(subscribed map[string]bool, topics []string) bool {
+	for _, topic := range topics {
+		if subscribed[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// unsubscribe removes topics and returns the current topics
+func (c *Client) unsubscribe(topics []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+	return c.topicList()
+}
+
+// topicList returns the subscribed topics in order; the caller holds mu
+func (c *Client) topicList() []string {
+	list := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		list = append(list, topic)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// reply queues a control message for the client
+func (c *Client) reply(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshaling websocket reply: %v", err)
 		return
 	}
+	c.enqueue(data)
+}
+
+// keepAlive expects traffic, at least pongs, within pongWait
+func (c *Client) keepAlive() {
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+}
+
+// HandleWebSocket serves a dashboard connection. Connections not
+// authenticated at upgrade must send an auth frame first. Clients receive only
+// the topics they subscribe to, either with ?topics=a,b on the URL or by
+// sending subscribe and unsubscribe frames, and only for labs they may see.
+func HandleWebSocket(c *websocket.Conn) {
+	client := newClient(Default, c)
+	c.SetReadLimit(maxFrameSize)
+
+	go client.writePump()
+	// Conn is recycled once this returns, so wait for the writer to let go
+	defer func() { <-client.stopped }()
+
+	if s, ok := c.Locals("session").(*session); ok {
+		client.session = s
+	} else {
+		s, msg := client.awaitAuth()
+		if s == nil {
+			client.disconnect(msg)
+			return
+		}
+		client.session = s
+	}
+
+	// Registered before subscribing so no event is missed between the
+	// catch-up and live delivery; without topics nothing is delivered yet
+	client.hub.register(client)
+
+	if initial := c.Query("topics"); initial != "" {
+		since, err := parseSeq(c.Query("since"))
+		if err == nil {
+			err = client.subscribe(strings.Split(initial, ","), since, "")
+		}
+		if err != nil {
+			client.hub.unregister(client)
+			client.disconnect(err.Error())
+			return
+		}
+	}
+
+	done := make(chan struct{})
+	go client.watch(done)
+
+	defer func() {
+		close(done)
+		client.hub.unregister(client)
+		client.stop(closeFrame{code: websocket.CloseNormalClosure})
+	}()
+
+	c.SetPongHandler(func(string) error {
+		client.keepAlive()
+		return nil
+	})
+	client.keepAlive()
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			break
+		}
+		client.keepAlive()
+		if reply := client.handleRequest(msg); reply != nil {
+			client.reply(reply)
+		}
+	}
+}
 
-	broadcast <- jsonData
+// BroadcastResourceUpdate sends an event to the clients subscribed to it
+func BroadcastResourceUpdate(event Event) {
+	Default.publish(event)
 }
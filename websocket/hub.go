@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+const (
+	// defaultSendQueue is how many messages may wait for a slow connection
+	defaultSendQueue = 256
+
+	// defaultEvictAfter is how many messages in a row a connection may miss
+	// because its queue is full before it is disconnected
+	defaultEvictAfter = 64
+
+	// defaultReplaySize is how many recent events are kept for clients
+	// resuming after a disconnect
+	defaultReplaySize = 10000
+)
+
+// Stats is a snapshot of the hub's connections and counters
+type Stats struct {
+	Clients       int    `json:"clients"`
+	QueueCapacity int    `json:"queue_capacity"` // per connection
+	Seq           uint64 `json:"seq"`            // sequence of the last event
+	Buffered      int    `json:"buffered"`       // events kept for replay
+	Published     uint64 `json:"published"`
+	Delivered     uint64 `json:"delivered"` // queued for a connection
+	Dropped       uint64 `json:"dropped"`   // skipped because a connection's queue was full
+	Evicted       uint64 `json:"evicted"`   // connections closed for falling behind
+}
+
+// Hub fans events out to connections. Publishing never blocks: each
+// connection has a bounded queue drained by its own writer, messages that
+// don't fit are dropped for that connection, and connections that keep
+// missing messages are evicted. Every event gets the next sequence number and
+// the latest ones are kept in a ring buffer for replay.
+type Hub struct {
+	mu         sync.RWMutex // held exclusively to publish, so clients get events in sequence order
+	clients    map[*Client]bool
+	closed     bool // set by Close; later connections are stopped right away
+	queueSize  int
+	evictAfter int
+
+	seq     uint64    // sequence of the last event published
+	history []message // ring buffer of the latest events
+	next    int       // where the next event goes once history is full
+
+	// snapshot loads the current state for newly subscribed topics
+	snapshot func(s *session, topics []string) (*Snapshot, error)
+
+	published, delivered, dropped, evicted atomic.Uint64
+}
+
+// Default is the hub dashboards connect to
+var Default = NewHub(defaultSendQueue, defaultEvictAfter, defaultReplaySize)
+
+// NewHub returns a hub whose connections queue up to queueSize messages and
+// are evicted after missing evictAfter messages in a row, keeping the last
+// replaySize events. Sequences start at the current time in microseconds, so
+// they keep increasing across restarts and a client resuming from before a
+// restart gets a snapshot instead of a replay.
+func NewHub(queueSize, evictAfter, replaySize int) *Hub {
+	return &Hub{
+		clients:    make(map[*Client]bool),
+		queueSize:  queueSize,
+		evictAfter: evictAfter,
+		seq:        uint64(time.Now().UnixMicro()),
+		history:    make([]message, 0, replaySize),
+		snapshot:   loadSnapshot,
+	}
+}
+
+func (h *Hub) register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		c.stop(closeFrame{code: websocket.CloseGoingAway, reason: "Server shutting down"})
+	}
+	h.clients[c] = true
+}
+
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+// Close disconnects every websocket and event stream client, after what is
+// already queued for it, and any that connect later. Streams hold their
+// request open, so the server can't shut down until they end.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		c.stop(closeFrame{code: websocket.CloseGoingAway, reason: "Server shutting down", flush: true})
+	}
+}
+
+// publish numbers an event, keeps it for replay and queues it for every
+// connection that wants it
+func (h *Hub) publish(event Event) {
+	payload, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Printf("Error marshaling broadcast data: %v", err)
+		return
+	}
+	event.Data = json.RawMessage(payload)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event.Seq = h.seq
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling broadcast data: %v", err)
+		return
+	}
+	msg := message{seq: h.seq, data: data, topics: event.Topics(), labID: event.LabID}
+	h.record(msg)
+	h.published.Add(1)
+
+	for client := range h.clients {
+		if client.wants(msg) {
+			client.enqueue(msg.data)
+		}
+	}
+}
+
+// record adds a message to the ring buffer; the caller holds mu
+func (h *Hub) record(msg message) {
+	if cap(h.history) == 0 {
+		return
+	}
+	if len(h.history) < cap(h.history) {
+		h.history = append(h.history, msg)
+		return
+	}
+	h.history[h.next] = msg
+	h.next = (h.next + 1) % len(h.history)
+}
+
+// since returns the buffered events after seq that keep accepts, oldest
+// first. It reports false if the buffer no longer reaches back to seq, or seq
+// is from the future (e.g. before a restart with a slow clock). The caller
+// holds mu.
+func (h *Hub) since(seq uint64, keep func(message) bool) ([]json.RawMessage, bool) {
+	oldest := h.seq + 1 - uint64(len(h.history))
+	if seq > h.seq || seq+1 < oldest {
+		return nil, false
+	}
+
+	events := []json.RawMessage{}
+	for i := range h.history {
+		msg := h.history[(h.next+i)%len(h.history)]
+		if msg.seq > seq && keep(msg) {
+			events = append(events, msg.data)
+		}
+	}
+	return events, true
+}
+
+// Stats returns the number of connections and the hub's counters
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	clients, seq, buffered := len(h.clients), h.seq, len(h.history)
+	h.mu.RUnlock()
+
+	return Stats{
+		Clients:       clients,
+		QueueCapacity: h.queueSize,
+		Seq:           seq,
+		Buffered:      buffered,
+		Published:     h.published.Load(),
+		Delivered:     h.delivered.Load(),
+		Dropped:       h.dropped.Load(),
+		Evicted:       h.evicted.Load(),
+	}
+}
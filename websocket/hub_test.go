@@ -0,0 +1,483 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/middleware"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/Frhnmj2004/LabMonitoring-server/utils"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+func testSession(role string, labIDs ...uuid.UUID) *session {
+	return &session{
+		claims: &utils.JWTClaims{
+			UserID: uuid.New(),
+			Role:   role,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		},
+		scope: &middleware.Scope{Role: role, LabIDs: labIDs},
+	}
+}
+
+// testClient is a registered client without a connection; tests read its
+// queue directly
+func testClient(h *Hub, s *session, topics ...string) *Client {
+	c := newClient(h, nil)
+	c.session = s
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+	h.register(c)
+	return c
+}
+
+// testHub is a hub whose snapshots are empty instead of loaded from the
+// database
+func testHub(queueSize, evictAfter, replaySize int) *Hub {
+	h := NewHub(queueSize, evictAfter, replaySize)
+	h.snapshot = func(*session, []string) (*Snapshot, error) {
+		return &Snapshot{Resources: []models.ResourceLog{}, Alerts: []models.Alert{}}, nil
+	}
+	return h
+}
+
+func testEvent(labID uuid.UUID, n int) Event {
+	return Event{Type: "resource_update", Data: n, ComputerID: "pc-1", LabID: labID}
+}
+
+// frame is any message sent to a client
+type frame struct {
+	Type   string            `json:"type"`
+	Action string            `json:"action"`
+	Seq    uint64            `json:"seq"`
+	Data   json.RawMessage   `json:"data"`
+	Topics []string          `json:"topics"`
+	Error  string            `json:"error"`
+	Events []json.RawMessage `json:"-"`
+}
+
+// next decodes the next queued message of a test client
+func next(t *testing.T, c *Client) frame {
+	t.Helper()
+	select {
+	case data := <-c.send:
+		var f frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatal(err)
+		}
+		if f.Type == "replay" {
+			json.Unmarshal(f.Data, &f.Events)
+		}
+		return f
+	default:
+		t.Fatal("no message queued")
+		return frame{}
+	}
+}
+
+func TestPublishFiltersByTopicAndScope(t *testing.T) {
+	h := testHub(8, 8, 100)
+	lab, otherLab := uuid.New(), uuid.New()
+
+	admin := testClient(h, testSession(models.RoleAdmin), TopicAll)
+	manager := testClient(h, testSession(models.RoleLabManager, lab), TopicAll)
+	other := testClient(h, testSession(models.RoleAdmin), LabTopic(otherLab))
+
+	h.publish(testEvent(lab, 1))
+	h.publish(testEvent(uuid.Nil, 2))
+
+	if got := len(admin.send); got != 2 {
+		t.Errorf("admin got %d messages, want 2", got)
+	}
+	if got := len(manager.send); got != 1 {
+		t.Errorf("lab manager got %d messages, want 1 (events without a lab are withheld)", got)
+	}
+	if got := len(other.send); got != 0 {
+		t.Errorf("client subscribed to another lab got %d messages, want 0", got)
+	}
+
+	stats := h.Stats()
+	if stats.Clients != 3 || stats.Published != 2 || stats.Delivered != 3 || stats.Dropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSlowClientIsEvicted(t *testing.T) {
+	h := testHub(4, 3, 100)
+	slow := testClient(h, testSession(models.RoleAdmin), TopicAll)
+	fast := testClient(h, testSession(models.RoleAdmin), TopicAll)
+
+	for i := 0; i < 6; i++ {
+		h.publish(testEvent(uuid.Nil, i))
+		<-fast.send
+	}
+
+	// 4 fit in the queue, the next 2 are dropped
+	select {
+	case <-slow.closing:
+		t.Fatal("slow client evicted before missing evictAfter messages")
+	default:
+	}
+	if got := h.Stats().Dropped; got != 2 {
+		t.Errorf("dropped = %d, want 2", got)
+	}
+
+	h.publish(testEvent(uuid.Nil, 6))
+	<-fast.send
+	select {
+	case <-slow.closing:
+	default:
+		t.Fatal("slow client not evicted")
+	}
+	if slow.close.code != websocket.CloseTryAgainLater || slow.close.flush {
+		t.Errorf("eviction close frame = %+v", slow.close)
+	}
+
+	// Nothing more is queued once a client is closing
+	h.publish(testEvent(uuid.Nil, 7))
+	<-fast.send
+	stats := h.Stats()
+	if stats.Evicted != 1 || stats.Dropped != 3 || stats.Delivered != 12 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDeliveryResetsMissedCount(t *testing.T) {
+	h := testHub(1, 2, 100)
+	c := testClient(h, testSession(models.RoleAdmin), TopicAll)
+
+	for i := 0; i < 10; i++ {
+		h.publish(testEvent(uuid.Nil, i)) // fills the queue
+		h.publish(testEvent(uuid.Nil, i)) // dropped
+		<-c.send
+	}
+
+	select {
+	case <-c.closing:
+		t.Fatal("client evicted although it kept draining its queue")
+	default:
+	}
+	if got := h.Stats().Dropped; got != 10 {
+		t.Errorf("dropped = %d, want 10", got)
+	}
+}
+
+func TestConcurrentPublishAndSubscribe(t *testing.T) {
+	h := testHub(16, 1<<30, 100)
+	lab := uuid.New()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	for i := 0; i < 8; i++ {
+		c := testClient(h, testSession(models.RoleAdmin))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-c.send:
+				case <-stop:
+					return
+				}
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				c.subscribe([]string{TopicAll, LabTopic(lab)}, nil, "")
+				c.unsubscribe([]string{TopicAll})
+			}
+		}()
+	}
+
+	var publishers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			for j := 0; j < 500; j++ {
+				h.publish(testEvent(lab, j))
+			}
+		}()
+	}
+	publishers.Add(1)
+	go func() {
+		defer publishers.Done()
+		for j := 0; j < 200; j++ {
+			c := testClient(h, testSession(models.RoleAdmin), TopicAll)
+			h.unregister(c)
+			h.Stats()
+		}
+	}()
+
+	publishers.Wait()
+	close(stop)
+	wg.Wait()
+
+	stats := h.Stats()
+	if stats.Published != 2000 {
+		t.Errorf("published = %d, want 2000", stats.Published)
+	}
+}
+
+func TestEventsAreNumberedInOrder(t *testing.T) {
+	h := testHub(16, 16, 100)
+	c := testClient(h, testSession(models.RoleAdmin), TopicAll)
+	start := h.Stats().Seq
+
+	for i := 0; i < 5; i++ {
+		h.publish(testEvent(uuid.Nil, i))
+	}
+	for i := 0; i < 5; i++ {
+		if f := next(t, c); f.Seq != start+uint64(i)+1 || f.Type != "resource_update" {
+			t.Fatalf("event %d = %+v", i, f)
+		}
+	}
+	if stats := h.Stats(); stats.Seq != start+5 || stats.Buffered != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	h := testHub(16, 16, 100)
+	lab, otherLab := uuid.New(), uuid.New()
+	start := h.Stats().Seq
+
+	h.publish(testEvent(lab, 0))
+	h.publish(testEvent(otherLab, 1))
+	h.publish(testEvent(lab, 2))
+	h.publish(testEvent(lab, 3))
+
+	c := testClient(h, testSession(models.RoleAdmin))
+	since := start + 1
+	if err := c.subscribe([]string{LabTopic(lab)}, &since, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ack := next(t, c)
+	if ack.Type != "ack" || ack.Seq != start+4 {
+		t.Fatalf("ack = %+v", ack)
+	}
+	replay := next(t, c)
+	if replay.Type != "replay" || replay.Seq != start+4 || len(replay.Events) != 2 {
+		t.Fatalf("replay = %+v", replay)
+	}
+	for i, data := range replay.Events {
+		var event frame
+		json.Unmarshal(data, &event)
+		if want := start + uint64(i) + 3; event.Seq != want {
+			t.Errorf("replayed event %d has seq %d, want %d", i, event.Seq, want)
+		}
+	}
+
+	h.publish(testEvent(lab, 4))
+	if f := next(t, c); f.Seq != start+5 {
+		t.Errorf("live event after replay has seq %d, want %d", f.Seq, start+5)
+	}
+}
+
+func TestReplaySkipsTopicsAlreadyFollowed(t *testing.T) {
+	h := testHub(16, 16, 100)
+	lab, otherLab := uuid.New(), uuid.New()
+	start := h.Stats().Seq
+	c := testClient(h, testSession(models.RoleAdmin), LabTopic(lab))
+
+	h.publish(testEvent(lab, 0))
+	h.publish(testEvent(otherLab, 1))
+	next(t, c)
+
+	if err := c.subscribe([]string{TopicAll}, &start, ""); err != nil {
+		t.Fatal(err)
+	}
+	next(t, c)
+	replay := next(t, c)
+	if len(replay.Events) != 1 {
+		t.Fatalf("replayed %d events, want only the one not already delivered", len(replay.Events))
+	}
+}
+
+func TestSubscribeSendsSnapshotWhenReplayIsImpossible(t *testing.T) {
+	h := testHub(16, 16, 3)
+	start := h.Stats().Seq
+	for i := 0; i < 5; i++ {
+		h.publish(testEvent(uuid.Nil, i))
+	}
+
+	tooOld, future := start+1, start+10
+	for _, since := range []*uint64{nil, &tooOld, &future} {
+		c := testClient(h, testSession(models.RoleAdmin))
+		if err := c.subscribe([]string{TopicAll}, since, ""); err != nil {
+			t.Fatal(err)
+		}
+		next(t, c)
+		if f := next(t, c); f.Type != "snapshot" || f.Seq != start+5 {
+			t.Errorf("since %v: got %+v, want a snapshot as of %d", since, f, start+5)
+		}
+	}
+
+	// The last 3 events are still buffered
+	c := testClient(h, testSession(models.RoleAdmin))
+	since := start + 2
+	c.subscribe([]string{TopicAll}, &since, "")
+	next(t, c)
+	if f := next(t, c); f.Type != "replay" || len(f.Events) != 3 {
+		t.Errorf("got %+v, want a replay of 3 events", f)
+	}
+}
+
+// serve runs HandleWebSocket on a local port, authenticating every
+// connection as an admin. Cleanup waits for the connections to end, so they
+// don't outlive the test.
+func serve(t *testing.T) string {
+	t.Helper()
+	var handlers sync.WaitGroup
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use("/ws", func(c *fiber.Ctx) error {
+		c.Locals("session", testSession(models.RoleAdmin))
+		c.Locals("allowed", true)
+		handlers.Add(1)
+		return c.Next()
+	})
+	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
+		defer handlers.Done()
+		HandleWebSocket(c)
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() {
+		handlers.Wait()
+		app.Shutdown()
+	})
+	return fmt.Sprintf("ws://%s/ws", ln.Addr())
+}
+
+// shortTimings speeds up keepalives for a test
+func shortTimings(t *testing.T) {
+	saved := [3]time.Duration{writeWait, pongWait, pingPeriod}
+	writeWait, pongWait, pingPeriod = time.Second, 300*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() { writeWait, pongWait, pingPeriod = saved[0], saved[1], saved[2] })
+}
+
+func dial(t *testing.T, url string) *fastws.Conn {
+	t.Helper()
+	conn, _, err := fastws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConnectionReceivesEventsInOrder(t *testing.T) {
+	Default = testHub(defaultSendQueue, defaultEvictAfter, 100)
+	conn := dial(t, serve(t)+"?topics=all")
+
+	// The subscription is in place once its ack and snapshot arrive
+	var ack, snapshot frame
+	conn.ReadJSON(&ack)
+	conn.ReadJSON(&snapshot)
+	if ack.Type != "ack" || snapshot.Type != "snapshot" || snapshot.Seq != ack.Seq {
+		t.Fatalf("got %+v then %+v, want an ack and a snapshot", ack, snapshot)
+	}
+
+	for i := 0; i < 100; i++ {
+		BroadcastResourceUpdate(Event{Type: "resource_update", Data: i, ComputerID: "pc-1"})
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 100; i++ {
+		var event struct {
+			Seq  uint64 `json:"seq"`
+			Data int    `json:"data"`
+		}
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("reading event %d: %v", i, err)
+		}
+		if event.Data != i || event.Seq != snapshot.Seq+uint64(i)+1 {
+			t.Fatalf("event %d arrived as %d with seq %d", i, event.Data, event.Seq)
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	shortTimings(t)
+	Default = testHub(defaultSendQueue, defaultEvictAfter, 100)
+	url := serve(t) + "?topics=all"
+
+	// A client that keeps reading answers pings and stays connected
+	live := dial(t, url)
+	var pings atomic.Int64
+	live.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return live.WriteControl(fastws.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	received := make(chan struct{}, 1)
+	go func() {
+		for {
+			var f frame
+			if err := live.ReadJSON(&f); err != nil {
+				close(received)
+				return
+			}
+			if f.Type == "resource_update" {
+				received <- struct{}{}
+			}
+		}
+	}()
+
+	// One that doesn't read never answers pings and is dropped
+	silent := dial(t, url)
+	deadline := time.Now().Add(5 * time.Second)
+	for Default.Stats().Clients != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("clients = %d, want 2", Default.Stats().Clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for Default.Stats().Clients != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("silent client still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	silent.SetPingHandler(func(string) error { return nil })
+	silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := silent.ReadMessage(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Error("server did not close the silent client's connection")
+			}
+			break
+		}
+	}
+
+	BroadcastResourceUpdate(Event{Type: "resource_update", ComputerID: "pc-1"})
+	select {
+	case _, ok := <-received:
+		if !ok {
+			t.Fatal("live client disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("live client got no event")
+	}
+	if pings.Load() == 0 {
+		t.Error("no pings received")
+	}
+}
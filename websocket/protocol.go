@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Client request actions
+const (
+	actionAuth        = "auth"
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+var errTooManyTopics = fmt.Errorf("at most %d topics may be subscribed", maxTopicsPerClient)
+
+// request is a control frame sent by a client, e.g.
+// {"action": "subscribe", "topics": ["lab:<id>", "alerts"], "id": "1"} or
+// {"action": "auth", "token": "<access token>"}. id is optional and echoed in
+// the reply; since asks a subscribe to replay the events after that sequence.
+type request struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+	Since  *uint64  `json:"since,omitempty"`
+	Token  string   `json:"token,omitempty"`
+	ID     string   `json:"id,omitempty"`
+}
+
+// ackReply confirms a request and lists the client's topics afterwards.
+// Subscribes report the sequence of the last event published, auth requests
+// when the new token expires.
+type ackReply struct {
+	Type      string     `json:"type"`
+	Action    string     `json:"action"`
+	ID        string     `json:"id,omitempty"`
+	Topics    []string   `json:"topics"`
+	Seq       *uint64    `json:"seq,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// replayReply carries the events a resuming client missed, oldest first.
+// Seq is the last event it accounts for; live events follow it.
+type replayReply struct {
+	Type string            `json:"type"`
+	Seq  uint64            `json:"seq"`
+	Data []json.RawMessage `json:"data"`
+}
+
+// snapshotReply carries the current state of newly subscribed topics as of
+// event Seq; live events follow it
+type snapshotReply struct {
+	Type string    `json:"type"`
+	Seq  uint64    `json:"seq"`
+	Data *Snapshot `json:"data"`
+}
+
+// errorReply reports a request that was not carried out
+type errorReply struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+func parseRequest(msg []byte) (request, error) {
+	var req request
+	err := json.Unmarshal(msg, &req)
+	return req, err
+}
+
+// parseSeq reads an optional ?since= sequence
+func parseSeq(value string) (*uint64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, errors.New("since must be an event sequence number")
+	}
+	return &seq, nil
+}
+
+// handleRequest carries out a control frame and returns the reply to send,
+// or nil if the request queued its own replies
+func (c *Client) handleRequest(msg []byte) interface{} {
+	req, err := parseRequest(msg)
+	if err != nil {
+		return errorReply{Type: "error", Error: "invalid JSON"}
+	}
+
+	if req.Action == actionAuth {
+		return c.handleAuth(req)
+	}
+
+	if err := c.apply(req); err != nil {
+		return errorReply{Type: "error", ID: req.ID, Error: err.Error()}
+	}
+	return nil
+}
+
+func (c *Client) handleAuth(req request) interface{} {
+	if req.Token == "" {
+		return errorReply{Type: "error", ID: req.ID, Error: "token is required"}
+	}
+	s, err := c.reauthenticate(req.Token)
+	if err != nil {
+		return errorReply{Type: "error", ID: req.ID, Error: err.Error()}
+	}
+
+	c.mu.RLock()
+	topics := c.topicList()
+	c.mu.RUnlock()
+	expiresAt := s.expiresAt()
+	return ackReply{Type: "ack", Action: actionAuth, ID: req.ID, Topics: topics, ExpiresAt: &expiresAt}
+}
+
+func (c *Client) apply(req request) error {
+	if len(req.Topics) == 0 {
+		return errors.New("topics is required")
+	}
+	switch req.Action {
+	case actionSubscribe:
+		return c.subscribe(req.Topics, req.Since, req.ID)
+	case actionUnsubscribe:
+		topics := c.unsubscribe(req.Topics)
+		c.reply(ackReply{Type: "ack", Action: req.Action, ID: req.ID, Topics: topics})
+		return nil
+	}
+	return fmt.Errorf("unknown action %q; use auth, subscribe or unsubscribe", req.Action)
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"strings"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/config"
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/google/uuid"
+)
+
+// Snapshot is the current state behind a set of topics: the latest sample
+// of each computer they cover and the open alerts
+type Snapshot struct {
+	Resources []models.ResourceLog `json:"resources"`
+	Alerts    []models.Alert       `json:"alerts"`
+}
+
+// loadSnapshot builds the snapshot for topics, limited to the labs the
+// session may see. The alerts topic covers open alerts on every computer
+// but no samples.
+func loadSnapshot(s *session, topics []string) (*Snapshot, error) {
+	var all, alerts bool
+	var computerIDs []string
+	var labIDs []uuid.UUID
+	for _, topic := range topics {
+		switch {
+		case topic == TopicAll:
+			all = true
+		case topic == TopicAlerts:
+			alerts = true
+		case strings.HasPrefix(topic, topicComputerPrefix):
+			computerIDs = append(computerIDs, strings.TrimPrefix(topic, topicComputerPrefix))
+		case strings.HasPrefix(topic, topicLabPrefix):
+			labIDs = append(labIDs, uuid.MustParse(strings.TrimPrefix(topic, topicLabPrefix)))
+		}
+	}
+
+	snapshot := &Snapshot{Resources: []models.ResourceLog{}, Alerts: []models.Alert{}}
+
+	// Computers whose latest samples are included
+	var ids []string
+	if all || len(computerIDs) > 0 || len(labIDs) > 0 {
+		query := config.DB.Model(&models.Computer{}).Scopes(models.NotArchived)
+		if !all {
+			query = query.Where("computer_id IN ? OR lab_id IN ?", computerIDs, labIDs)
+		}
+		query = s.scope.FilterLabs(query, "lab_id")
+		if err := query.Pluck("computer_id", &ids).Error; err != nil {
+			return nil, err
+		}
+
+		logs, err := models.GetLatestResourceLogs(config.DB, ids)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Resources = logs
+	}
+
+	if !all && !alerts && len(ids) == 0 {
+		return snapshot, nil
+	}
+	query := config.DB.Where("status <> ?", models.AlertStatusResolved)
+	if !all && !alerts {
+		query = query.Where("computer_id IN ?", ids)
+	}
+	query = s.scope.FilterComputers(query, "alerts.computer_id")
+	if err := query.Order("timestamp DESC").Find(&snapshot.Alerts).Error; err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
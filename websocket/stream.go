@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleStream serves the events of the topics in ?topics=a,b as server-sent
+// events, for clients that can't use websockets. The token comes from the
+// Authorization header or ?token=. Each event's id is its sequence, so a
+// reconnecting EventSource resumes through Last-Event-ID (or ?since=) the same
+// way a websocket subscribe with since does.
+func HandleStream(c *fiber.Ctx) error {
+	token := requestToken(c)
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Missing authorization token",
+		})
+	}
+	s, status, msg := authenticate(token)
+	if s == nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if c.Query("topics") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "topics is required",
+		})
+	}
+	topics := strings.Split(c.Query("topics"), ",")
+	for _, topic := range topics {
+		if err := ValidateTopic(topic); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if !s.allowsTopic(topic) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": fmt.Sprintf("Not allowed to subscribe to %s", topic),
+			})
+		}
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("since")
+	}
+	since, err := parseSeq(lastEventID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	client := newClient(Default, nil)
+	client.session = s
+	client.hub.register(client)
+	if err := client.subscribe(topics, since, ""); err != nil {
+		client.hub.unregister(client)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		done := make(chan struct{})
+		go client.watch(done)
+
+		defer func() {
+			close(done)
+			client.hub.unregister(client)
+			client.stop(closeFrame{})
+			// Don't leave the deadline on a connection that may be reused
+			conn.SetWriteDeadline(time.Time{})
+		}()
+
+		client.streamPump(w, conn)
+	})
+	return nil
+}
+
+// streamPump writes queued messages as server-sent events until stopped or
+// a write fails. A comment goes out every pingPeriod so proxies keep the
+// response open and a gone client is noticed. Each write must finish within
+// writeWait on conn, if given, so a stalled reader can't hold the stream open.
+func (c *Client) streamPump(w *bufio.Writer, conn net.Conn) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	deadline := func() {
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+		}
+	}
+
+	// Sends the headers right away
+	deadline()
+	if err := writeComment(w, "connected"); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case data := <-c.send:
+			deadline()
+			if err := writeEvent(w, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			deadline()
+			if err := writeComment(w, "ping"); err != nil {
+				return
+			}
+		case <-c.closing:
+			if c.close.flush {
+				for {
+					select {
+					case data := <-c.send:
+						deadline()
+						if err := writeEvent(w, data); err != nil {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+			return
+		}
+	}
+}
+
+// writeEvent writes a queued message as a server-sent event named after its
+// type, with its sequence as the id. Acks are skipped, as stream
+// subscriptions can't change, and replays are written event by event.
+func writeEvent(w *bufio.Writer, data []byte) error {
+	var frame struct {
+		Type string          `json:"type"`
+		Seq  uint64          `json:"seq"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return err
+	}
+
+	switch frame.Type {
+	case "ack":
+		return nil
+	case "replay":
+		var events []json.RawMessage
+		if err := json.Unmarshal(frame.Data, &events); err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := writeEvent(w, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if frame.Seq != 0 {
+		fmt.Fprintf(w, "id: %d\n", frame.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", frame.Type, data)
+	return w.Flush()
+}
+
+func writeComment(w *bufio.Writer, text string) error {
+	fmt.Fprintf(w, ": %s\n\n", text)
+	return w.Flush()
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/google/uuid"
+)
+
+func TestStreamWritesEventsWithIDs(t *testing.T) {
+	h := testHub(16, 16, 100)
+	lab := uuid.New()
+	start := h.Stats().Seq
+	h.publish(testEvent(lab, 0))
+	h.publish(testEvent(lab, 1))
+
+	c := testClient(h, testSession(models.RoleAdmin))
+	since := start
+	if err := c.subscribe([]string{LabTopic(lab)}, &since, ""); err != nil {
+		t.Fatal(err)
+	}
+	h.publish(testEvent(lab, 2))
+	c.disconnect("Token expired")
+
+	var out bytes.Buffer
+	c.streamPump(bufio.NewWriter(&out), nil)
+
+	var ids, events []string
+	for _, block := range strings.Split(strings.TrimSpace(out.String()), "\n\n") {
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				ids = append(ids, strings.TrimPrefix(line, "id: "))
+			case strings.HasPrefix(line, "event: "):
+				events = append(events, strings.TrimPrefix(line, "event: "))
+			}
+		}
+	}
+
+	// The ack is skipped, the replay is split into its events and the
+	// disconnect reason is flushed before the stream ends
+	wantEvents := []string{"resource_update", "resource_update", "resource_update", "error"}
+	if strings.Join(events, ",") != strings.Join(wantEvents, ",") {
+		t.Errorf("events = %v, want %v\n%s", events, wantEvents, out.String())
+	}
+	if len(ids) != 3 {
+		t.Fatalf("ids = %v, want one per resource_update", ids)
+	}
+	for i, id := range ids {
+		if want := strconv.FormatUint(start+uint64(i)+1, 10); id != want {
+			t.Errorf("id %d = %s, want %s", i, id, want)
+		}
+	}
+	if !strings.HasPrefix(out.String(), ": connected\n\n") {
+		t.Errorf("stream does not start with a comment:\n%s", out.String())
+	}
+}
+
+func TestCloseEndsStreams(t *testing.T) {
+	h := testHub(16, 16, 100)
+	lab := uuid.New()
+	c := testClient(h, testSession(models.RoleAdmin), LabTopic(lab))
+	h.publish(testEvent(lab, 0))
+	h.Close()
+
+	var out bytes.Buffer
+	c.streamPump(bufio.NewWriter(&out), nil)
+	if !strings.Contains(out.String(), "event: resource_update") {
+		t.Errorf("queued event was not flushed before the stream ended:\n%s", out.String())
+	}
+
+	late := testClient(h, testSession(models.RoleAdmin))
+	select {
+	case <-late.closing:
+	default:
+		t.Error("client registered after Close was not stopped")
+	}
+}
+
+func TestStreamWriteTimesOut(t *testing.T) {
+	shortTimings(t)
+	h := testHub(16, 16, 100)
+	c := testClient(h, testSession(models.RoleAdmin))
+
+	// Nobody reads the other end, so every write stalls
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		c.streamPump(bufio.NewWriter(server), server)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * writeWait):
+		c.stop(closeFrame{})
+		t.Fatal("stream kept writing to a stalled connection")
+	}
+}
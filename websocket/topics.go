@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Frhnmj2004/LabMonitoring-server/models"
+	"github.com/google/uuid"
+)
+
+// Topics clients can subscribe to. computer:<system_id> and lab:<lab_id>
+// carry everything about one computer or lab, alerts carries alert events
+// for every computer, and all carries every event.
+const (
+	TopicAlerts         = "alerts"
+	TopicAll            = "all"
+	topicComputerPrefix = "computer:"
+	topicLabPrefix      = "lab:"
+)
+
+// maxTopicsPerClient bounds how many topics one connection may subscribe to
+const maxTopicsPerClient = 200
+
+// Event is a message for dashboards about one computer. It is sent to
+// subscribers of the computer's and its lab's topics; alert events also go
+// to the alerts topic. Seq is set when the event is published.
+type Event struct {
+	Seq        uint64      `json:"seq"`
+	Type       string      `json:"type"`
+	Data       interface{} `json:"data"`
+	ComputerID string      `json:"-"`
+	LabID      uuid.UUID   `json:"-"`
+	Alert      bool        `json:"-"`
+}
+
+// ComputerEvent builds an event about a computer
+func ComputerEvent(eventType string, computer *models.Computer, data interface{}) Event {
+	return Event{Type: eventType, Data: data, ComputerID: computer.ComputerID, LabID: computer.LabID}
+}
+
+// AlertEvent builds an alert lifecycle event for a computer's alert
+func AlertEvent(eventType string, computer *models.Computer, alert interface{}) Event {
+	event := ComputerEvent(eventType, computer, alert)
+	event.Alert = true
+	return event
+}
+
+// Topics returns the topics whose subscribers receive the event
+func (e Event) Topics() []string {
+	topics := []string{TopicAll, ComputerTopic(e.ComputerID)}
+	if e.LabID != uuid.Nil {
+		topics = append(topics, LabTopic(e.LabID))
+	}
+	if e.Alert {
+		topics = append(topics, TopicAlerts)
+	}
+	return topics
+}
+
+// ComputerTopic is the topic for events about one computer
+func ComputerTopic(computerID string) string {
+	return topicComputerPrefix + computerID
+}
+
+// LabTopic is the topic for events about the computers in one lab
+func LabTopic(labID uuid.UUID) string {
+	return topicLabPrefix + labID.String()
+}
+
+// ValidateTopic checks a topic a client asked to subscribe to
+func ValidateTopic(topic string) error {
+	switch {
+	case topic == TopicAlerts || topic == TopicAll:
+		return nil
+	case strings.HasPrefix(topic, topicComputerPrefix):
+		id := strings.TrimPrefix(topic, topicComputerPrefix)
+		if id == "" || len(id) > 64 {
+			return fmt.Errorf("invalid computer topic %q", topic)
+		}
+		return nil
+	case strings.HasPrefix(topic, topicLabPrefix):
+		if _, err := uuid.Parse(strings.TrimPrefix(topic, topicLabPrefix)); err != nil {
+			return fmt.Errorf("invalid lab topic %q", topic)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown topic %q; use computer:<id>, lab:<id>, alerts or all", topic)
+}